@@ -0,0 +1,158 @@
+package irssi_log
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Aliases groups the nicks one person used.
+//
+// We learn that nicks go together from nick changes, and from nicks joining,
+// parting, or quitting with the same user@host. Each group is known by the
+// first nick we saw in it.
+//
+// Different people can have the same user@host, such as on a webchat gateway
+// or a shared bouncer. Set SharedHosts so we don't merge their nicks.
+type Aliases struct {
+	// SharedHosts, if set, matches user@hosts that more than one person may
+	// have. We don't merge nicks by a user@host it matches. To merge only by
+	// nick changes, have it match everything.
+	SharedHosts *regexp.Regexp
+
+	// parent links each nick to another in its group. Following the links leads
+	// to the group's first nick. Nicks are in IRC lower case.
+	parent map[string]string
+
+	// names has each nick as we first saw it, before lower casing.
+	names map[string]string
+
+	// seen is the order we saw each nick in.
+	seen map[string]int
+
+	// hosts has the first nick we saw with each user@host.
+	hosts map[string]string
+
+	// selfNick is our own nick, if we know it.
+	selfNick string
+}
+
+// NewAliases creates an empty set of aliases.
+func NewAliases() *Aliases {
+	return &Aliases{
+		parent: map[string]string{},
+		names:  map[string]string{},
+		seen:   map[string]int{},
+		hosts:  map[string]string{},
+	}
+}
+
+// Add learns from an entry. Add the entries of a log in order.
+func (a *Aliases) Add(entry *LogEntry) {
+	switch entry.Type {
+	case NickChange:
+		a.Merge(entry.Nick, entry.Text)
+
+	case YourNickChange:
+		if len(a.selfNick) > 0 {
+			a.Merge(a.selfNick, entry.Nick)
+		} else {
+			a.add(entry.Nick)
+		}
+		a.selfNick = entry.Nick
+
+	case Join, Part, Quit:
+		if len(entry.UserHost) == 0 ||
+			(a.SharedHosts != nil && a.SharedHosts.MatchString(entry.UserHost)) {
+			a.add(entry.Nick)
+			return
+		}
+
+		nick, exists := a.hosts[entry.UserHost]
+		if !exists {
+			a.hosts[entry.UserHost] = entry.Nick
+			a.add(entry.Nick)
+			return
+		}
+		a.Merge(nick, entry.Nick)
+
+	default:
+		if len(entry.Nick) > 0 {
+			a.add(entry.Nick)
+		}
+	}
+}
+
+// Merge records that two nicks belong to the same person.
+func (a *Aliases) Merge(nick1, nick2 string) {
+	root1 := a.root(a.add(nick1))
+	root2 := a.root(a.add(nick2))
+
+	if root1 == root2 {
+		return
+	}
+
+	// The group keeps the name of whichever nick we saw first.
+	if a.seen[root1] < a.seen[root2] {
+		a.parent[root2] = root1
+	} else {
+		a.parent[root1] = root2
+	}
+}
+
+// Canonical gives the name of the group a nick is in. For a nick we haven't
+// seen, it gives the nick.
+func (a *Aliases) Canonical(nick string) string {
+	lower := IRCLower(nick)
+	if _, exists := a.parent[lower]; !exists {
+		return nick
+	}
+	return a.names[a.root(lower)]
+}
+
+// Groups gives the nicks in each group with more than one, by the group's
+// name. Each group's nicks are sorted.
+func (a *Aliases) Groups() map[string][]string {
+	groups := map[string][]string{}
+	for lower := range a.parent {
+		name := a.names[a.root(lower)]
+		groups[name] = append(groups[name], a.names[lower])
+	}
+
+	for name, nicks := range groups {
+		if len(nicks) == 1 {
+			delete(groups, name)
+			continue
+		}
+		sort.Strings(nicks)
+	}
+
+	return groups
+}
+
+// add records a nick if it is new. It gives the nick in lower case.
+func (a *Aliases) add(nick string) string {
+	lower := IRCLower(nick)
+	if _, exists := a.parent[lower]; !exists {
+		a.parent[lower] = lower
+		a.names[lower] = nick
+		a.seen[lower] = len(a.seen)
+	}
+	return lower
+}
+
+// root finds the first nick in a nick's group. It shortens the links it
+// follows so later lookups are faster.
+func (a *Aliases) root(lower string) string {
+	root := lower
+	for a.parent[root] != root {
+		root = a.parent[root]
+	}
+
+	for lower != root {
+		next := a.parent[lower]
+		a.parent[lower] = root
+		lower = next
+	}
+
+	return root
+}
@@ -0,0 +1,142 @@
+package irssi_log
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAliases(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	aliases := NewAliases()
+	for _, entry := range parseFile(t, "testdata/sample.log", location) {
+		aliases.Add(entry)
+	}
+
+	// The log doesn't say what our nick was before alice_.
+	wanted := map[string][]string{"dave": {"dave", "dave_"}}
+	if groups := aliases.Groups(); !reflect.DeepEqual(groups, wanted) {
+		t.Errorf("Groups = %v, wanted %v", groups, wanted)
+	}
+
+	lines := []string{
+		"--- Log opened Wed Mar 30 08:00:00 2016",
+		"08:00 -!- You're now known as robert",
+		"08:01 -!- Bob_ [bob@example.com] has joined #channel",
+		"08:02 -!- bobby [bob@example.com] has quit [Quit: leaving]",
+		"08:03 -!- You're now known as rob",
+		"08:04 -!- erin [erin@example.com] has joined #channel",
+		"08:05 -!- erin is now known as ERIN|away",
+		"08:06 -!- frank [frank@example.com] has left #channel []",
+		"08:07 <@Bob_> hi",
+	}
+
+	parser := NewParser(location)
+	aliases = NewAliases()
+	for _, line := range lines {
+		entry, err := parser.Parse(line)
+		if err != nil {
+			t.Fatalf("Unable to parse: %s: %s", line, err.Error())
+		}
+		aliases.Add(entry)
+	}
+
+	wanted = map[string][]string{
+		"robert": {"rob", "robert"},
+		"Bob_":   {"Bob_", "bobby"},
+		"erin":   {"ERIN|away", "erin"},
+	}
+	if groups := aliases.Groups(); !reflect.DeepEqual(groups, wanted) {
+		t.Errorf("Groups = %v, wanted %v", groups, wanted)
+	}
+
+	cases := map[string]string{
+		"bobby":     "Bob_",
+		"BOB_":      "Bob_",
+		"erin|AWAY": "erin",
+		"frank":     "frank",
+		"unknown":   "unknown",
+	}
+	for nick, canonical := range cases {
+		if found := aliases.Canonical(nick); found != canonical {
+			t.Errorf("Canonical(%s) = %s, wanted %s", nick, found, canonical)
+		}
+	}
+
+	// Merging joins groups, keeping the name of the one we saw first.
+	aliases.Merge("rob", "frank")
+	if found := aliases.Canonical("frank"); found != "robert" {
+		t.Errorf("Canonical(frank) = %s after merging, wanted robert", found)
+	}
+}
+
+func TestAliasesSharedHosts(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	// Different people on a webchat gateway have the same user@host.
+	lines := []string{
+		"--- Log opened Wed Mar 30 08:00:00 2016",
+		"08:01 -!- alice [~webchat@gateway/web/example] has joined #channel",
+		"08:02 -!- bob [~webchat@gateway/web/example] has joined #channel",
+		"08:03 -!- bob is now known as bob_",
+		"08:04 -!- carol [carol@example.com] has joined #channel",
+		"08:05 -!- carol_ [carol@example.com] has joined #channel",
+	}
+
+	type TestCase struct {
+		SharedHosts *regexp.Regexp
+		Groups      map[string][]string
+	}
+
+	cases := []TestCase{
+		{
+			SharedHosts: nil,
+			Groups: map[string][]string{
+				"alice": {"alice", "bob", "bob_"},
+				"carol": {"carol", "carol_"},
+			},
+		},
+		{
+			SharedHosts: regexp.MustCompile(`^~webchat@gateway/`),
+			Groups: map[string][]string{
+				"bob":   {"bob", "bob_"},
+				"carol": {"carol", "carol_"},
+			},
+		},
+		// Only nick changes.
+		{
+			SharedHosts: regexp.MustCompile(`.`),
+			Groups: map[string][]string{
+				"bob": {"bob", "bob_"},
+			},
+		},
+	}
+
+	for _, testCase := range cases {
+		parser := NewParser(location)
+		aliases := NewAliases()
+		aliases.SharedHosts = testCase.SharedHosts
+
+		for _, line := range lines {
+			entry, err := parser.Parse(line)
+			if err != nil {
+				t.Fatalf("Unable to parse: %s: %s", line, err.Error())
+			}
+			aliases.Add(entry)
+		}
+
+		if groups := aliases.Groups(); !reflect.DeepEqual(groups,
+			testCase.Groups) {
+			t.Errorf("Groups with shared hosts %v = %v, wanted %v",
+				testCase.SharedHosts, groups, testCase.Groups)
+		}
+	}
+}
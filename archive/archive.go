@@ -0,0 +1,537 @@
+/*
+ * Package archive keeps parsed Irssi logs in a SQLite database.
+ *
+ * Entries go in one table, with the nicks, channels, and user@hosts they
+ * mention in their own tables. Entry text has a full text index.
+ *
+ * We remember how far into each log we got. Syncing a log again imports only
+ * the lines added since. When a log at a path is replaced, such as by
+ * rotation, we keep what we imported from the old one and start a new file
+ * for the path.
+ *
+ * Unlike the rest of the repository this package has a dependency outside the
+ * standard library: the pure Go SQLite driver, modernc.org/sqlite. We build
+ * with v1.60.1. Fetch it with:
+ *
+ *   go get modernc.org/sqlite@v1.60.1
+ */
+
+package archive
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/horgh/irssi_log"
+
+	// Register the SQLite driver. It includes FTS5.
+	_ "modernc.org/sqlite"
+)
+
+// batchSize is how many entries we import in each transaction.
+const batchSize = 10000
+
+const schema = `
+CREATE TABLE IF NOT EXISTS files (
+	id INTEGER PRIMARY KEY,
+	path TEXT NOT NULL,
+	checkpoint TEXT,
+	rotated INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS files_path ON files (path)
+WHERE rotated = 0;
+
+CREATE TABLE IF NOT EXISTS nicks (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS channels (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS hosts (
+	id INTEGER PRIMARY KEY,
+	user_host TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS entries (
+	id INTEGER PRIMARY KEY,
+	file_id INTEGER NOT NULL REFERENCES files(id),
+	line_number INTEGER NOT NULL,
+	offset INTEGER NOT NULL,
+	time INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	channel_id INTEGER REFERENCES channels(id),
+	nick_id INTEGER REFERENCES nicks(id),
+	nick_mode TEXT NOT NULL,
+	by_nick_id INTEGER REFERENCES nicks(id),
+	host_id INTEGER REFERENCES hosts(id),
+	text TEXT NOT NULL,
+	notice_target TEXT NOT NULL,
+	sync_seconds INTEGER NOT NULL,
+	line TEXT NOT NULL,
+	UNIQUE (file_id, offset)
+);
+
+CREATE INDEX IF NOT EXISTS entries_time ON entries (time);
+CREATE INDEX IF NOT EXISTS entries_nick ON entries (nick_id);
+CREATE INDEX IF NOT EXISTS entries_channel ON entries (channel_id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS entries_fts USING fts5 (
+	text,
+	content = 'entries',
+	content_rowid = 'id'
+);
+
+CREATE TRIGGER IF NOT EXISTS entries_fts_insert AFTER INSERT ON entries
+WHEN new.text != ''
+BEGIN
+	INSERT INTO entries_fts (rowid, text) VALUES (new.id, new.text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS entries_fts_delete AFTER DELETE ON entries
+WHEN old.text != ''
+BEGIN
+	INSERT INTO entries_fts (entries_fts, rowid, text)
+	VALUES ('delete', old.id, old.text);
+END;
+`
+
+// Archive is a database of log entries.
+type Archive struct {
+	db *sql.DB
+
+	// Ids of rows in the nicks, channels, and hosts tables, by table and
+	// value.
+	ids map[string]map[string]int64
+}
+
+// Result is an entry found in the archive.
+type Result struct {
+	// File is the path to the log the entry is from.
+	File string
+
+	Entry *irssi_log.LogEntry
+}
+
+// Open opens the archive in a database file, creating it if necessary.
+func Open(filename string) (*Archive, error) {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open database: %s: %s", filename,
+			err.Error())
+	}
+
+	// SQLite allows only one writer at a time.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(schema)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("Unable to create schema: %s", err.Error())
+	}
+
+	return &Archive{
+		db:  db,
+		ids: map[string]map[string]int64{},
+	}, nil
+}
+
+// Close closes the database.
+func (a *Archive) Close() error {
+	err := a.db.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to close database: %s", err.Error())
+	}
+	return nil
+}
+
+// Sync imports the lines in a log that are not yet in the archive. It returns
+// how many entries it imported.
+//
+// The first time we see a log we parse it in the given location. After that
+// we carry on in the location we started with. If the log was replaced, such
+// as by rotation, we keep the entries we imported from the old log and import
+// the new one from the start.
+func (a *Archive) Sync(logFilename string, location *time.Location) (int,
+	error) {
+	path, err := filepath.Abs(logFilename)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find path: %s: %s", logFilename,
+			err.Error())
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to open file: %s: %s", path, err.Error())
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	fileID, checkpoint, err := a.findFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if checkpoint != nil {
+		err := checkpoint.Check(file)
+		if err != nil {
+			if err != irssi_log.ErrStaleCheckpoint {
+				return 0, err
+			}
+
+			fileID, err = a.rotateFile(fileID, path)
+			if err != nil {
+				return 0, err
+			}
+			checkpoint = nil
+		}
+	}
+
+	if checkpoint == nil {
+		checkpoint, err = irssi_log.NewParser(location).Checkpoint(file)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	reader, parser, err := irssi_log.NewReaderFromCheckpoint(file, checkpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for {
+		count, err := a.importBatch(fileID, file, reader, parser)
+		if err != nil {
+			return imported, err
+		}
+
+		imported += count
+
+		if count < batchSize {
+			return imported, nil
+		}
+	}
+}
+
+// findFile looks up the current log at a path, adding it if necessary. It
+// gives the log's id and the checkpoint we imported up to, if any.
+func (a *Archive) findFile(path string) (int64, *irssi_log.Checkpoint,
+	error) {
+	_, err := a.db.Exec(`INSERT OR IGNORE INTO files (path) VALUES (?)`, path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Unable to add file: %s", err.Error())
+	}
+
+	var id int64
+	var checkpointJSON sql.NullString
+	err = a.db.QueryRow(
+		`SELECT id, checkpoint FROM files WHERE path = ? AND rotated = 0`,
+		path).Scan(&id, &checkpointJSON)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Unable to look up file: %s", err.Error())
+	}
+
+	if !checkpointJSON.Valid {
+		return id, nil, nil
+	}
+
+	var checkpoint irssi_log.Checkpoint
+	err = json.Unmarshal([]byte(checkpointJSON.String), &checkpoint)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Unable to decode checkpoint: %s", err.Error())
+	}
+
+	return id, &checkpoint, nil
+}
+
+// rotateFile records that the log at a path was replaced. The old log keeps
+// its entries and its final checkpoint, and a new file takes over the path.
+// It gives the new file's id.
+func (a *Archive) rotateFile(fileID int64, path string) (int64, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to begin transaction: %s", err.Error())
+	}
+
+	_, err = tx.Exec(`UPDATE files SET rotated = 1 WHERE id = ?`, fileID)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("Unable to retire file: %s", err.Error())
+	}
+
+	result, err := tx.Exec(`INSERT INTO files (path) VALUES (?)`, path)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("Unable to add file: %s", err.Error())
+	}
+
+	newFileID, err := result.LastInsertId()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("Unable to find new file: %s", err.Error())
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to commit: %s", err.Error())
+	}
+	return newFileID, nil
+}
+
+// importBatch imports up to batchSize entries in one transaction, along with
+// the checkpoint after them. This way the entries and checkpoint always
+// agree.
+func (a *Archive) importBatch(fileID int64, file *os.File,
+	reader *irssi_log.Reader, parser *irssi_log.Parser) (int, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to begin transaction: %s", err.Error())
+	}
+
+	count, err := a.insertEntries(tx, fileID, reader)
+	if err != nil {
+		a.rollback(tx)
+		return 0, err
+	}
+
+	checkpoint, err := parser.Checkpoint(file)
+	if err != nil {
+		a.rollback(tx)
+		return 0, err
+	}
+
+	checkpointJSON, err := json.Marshal(checkpoint)
+	if err != nil {
+		a.rollback(tx)
+		return 0, fmt.Errorf("Unable to encode checkpoint: %s", err.Error())
+	}
+
+	_, err = tx.Exec(`UPDATE files SET checkpoint = ? WHERE id = ?`,
+		string(checkpointJSON), fileID)
+	if err != nil {
+		a.rollback(tx)
+		return 0, fmt.Errorf("Unable to save checkpoint: %s", err.Error())
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		a.ids = map[string]map[string]int64{}
+		return 0, fmt.Errorf("Unable to commit: %s", err.Error())
+	}
+
+	return count, nil
+}
+
+// rollback abandons a transaction. Any ids we learned during it may be for
+// rows that are now gone, so we forget them.
+func (a *Archive) rollback(tx *sql.Tx) {
+	_ = tx.Rollback()
+	a.ids = map[string]map[string]int64{}
+}
+
+// insertEntries reads up to batchSize entries and inserts them.
+func (a *Archive) insertEntries(tx *sql.Tx, fileID int64,
+	reader *irssi_log.Reader) (int, error) {
+	stmt, err := tx.Prepare(`
+INSERT INTO entries
+(file_id, line_number, offset, time, type, channel_id, nick_id, nick_mode,
+by_nick_id, host_id, text, notice_target, sync_seconds, line)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to prepare statement: %s", err.Error())
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	count := 0
+	for count < batchSize {
+		entry, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+
+		channelID, err := a.lookupID(tx, "channels", "name", entry.Channel)
+		if err != nil {
+			return 0, err
+		}
+
+		nickID, err := a.lookupID(tx, "nicks", "name", entry.Nick)
+		if err != nil {
+			return 0, err
+		}
+
+		byNickID, err := a.lookupID(tx, "nicks", "name", entry.ByNick)
+		if err != nil {
+			return 0, err
+		}
+
+		hostID, err := a.lookupID(tx, "hosts", "user_host", entry.UserHost)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = stmt.Exec(fileID, entry.LineNumber, entry.Offset,
+			entry.Time.Unix(), entry.Type.String(), channelID, nickID,
+			entry.NickMode, byNickID, hostID, entry.Text, entry.NoticeTarget,
+			entry.SyncSeconds, entry.Line)
+		if err != nil {
+			return 0, fmt.Errorf("Unable to insert entry: line %d: %s",
+				entry.LineNumber, err.Error())
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// lookupID gives the id of the row with the value in a table, adding the row
+// if necessary. A blank value has no row.
+func (a *Archive) lookupID(tx *sql.Tx, table, column,
+	value string) (sql.NullInt64, error) {
+	if value == "" {
+		return sql.NullInt64{}, nil
+	}
+
+	ids, exists := a.ids[table]
+	if !exists {
+		ids = map[string]int64{}
+		a.ids[table] = ids
+	}
+
+	id, exists := ids[value]
+	if exists {
+		return sql.NullInt64{Int64: id, Valid: true}, nil
+	}
+
+	_, err := tx.Exec(fmt.Sprintf(`INSERT OR IGNORE INTO %s (%s) VALUES (?)`,
+		table, column), value)
+	if err != nil {
+		return sql.NullInt64{}, fmt.Errorf("Unable to add to %s: %s", table,
+			err.Error())
+	}
+
+	err = tx.QueryRow(fmt.Sprintf(`SELECT id FROM %s WHERE %s = ?`, table,
+		column), value).Scan(&id)
+	if err != nil {
+		return sql.NullInt64{}, fmt.Errorf("Unable to look up %s: %s", table,
+			err.Error())
+	}
+
+	ids[value] = id
+	return sql.NullInt64{Int64: id, Valid: true}, nil
+}
+
+// Search finds entries with text matching a full text query. The query uses
+// SQLite's FTS5 syntax. Results are in time order. If limit is positive we
+// return at most that many.
+func (a *Archive) Search(query string, limit int) ([]Result, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := a.db.Query(`
+SELECT f.path, f.checkpoint, e.line_number, e.offset, e.time, e.type,
+c.name, n.name, e.nick_mode, b.name, h.user_host, e.text, e.notice_target,
+e.sync_seconds, e.line
+FROM entries_fts
+JOIN entries e ON e.id = entries_fts.rowid
+JOIN files f ON f.id = e.file_id
+LEFT JOIN channels c ON c.id = e.channel_id
+LEFT JOIN nicks n ON n.id = e.nick_id
+LEFT JOIN nicks b ON b.id = e.by_nick_id
+LEFT JOIN hosts h ON h.id = e.host_id
+WHERE entries_fts MATCH ?
+ORDER BY e.time, e.file_id, e.offset
+LIMIT ?`, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to search: %s", err.Error())
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	locations := map[string]*time.Location{}
+
+	var results []Result
+	for rows.Next() {
+		var path string
+		var checkpointJSON sql.NullString
+		var unixTime int64
+		var entryType string
+		var channel, nick, byNick, userHost sql.NullString
+		entry := &irssi_log.LogEntry{}
+
+		err := rows.Scan(&path, &checkpointJSON, &entry.LineNumber,
+			&entry.Offset, &unixTime, &entryType, &channel, &nick, &entry.NickMode,
+			&byNick, &userHost, &entry.Text, &entry.NoticeTarget,
+			&entry.SyncSeconds, &entry.Line)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan row: %s", err.Error())
+		}
+
+		entry.Type, err = irssi_log.ParseEntryType(entryType)
+		if err != nil {
+			return nil, err
+		}
+
+		location, exists := locations[path]
+		if !exists {
+			location, err = fileLocation(checkpointJSON)
+			if err != nil {
+				return nil, err
+			}
+			locations[path] = location
+		}
+
+		entry.Time = time.Unix(unixTime, 0).In(location)
+		entry.Channel = channel.String
+		entry.Nick = nick.String
+		entry.ByNick = byNick.String
+		entry.UserHost = userHost.String
+
+		results = append(results, Result{File: path, Entry: entry})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read rows: %s", err.Error())
+	}
+
+	return results, nil
+}
+
+// fileLocation gives the location a log was parsed in, from its checkpoint.
+func fileLocation(checkpointJSON sql.NullString) (*time.Location, error) {
+	if !checkpointJSON.Valid {
+		return time.UTC, nil
+	}
+
+	var checkpoint irssi_log.Checkpoint
+	err := json.Unmarshal([]byte(checkpointJSON.String), &checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode checkpoint: %s", err.Error())
+	}
+
+	location, err := time.LoadLocation(checkpoint.Location)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid location: %s", err.Error())
+	}
+
+	return location, nil
+}
@@ -0,0 +1,201 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/horgh/irssi_log"
+)
+
+func TestSync(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	sample, err := ioutil.ReadFile(filepath.Join("..", "testdata", "sample.log"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	logFile := filepath.Join(dir, "test.log")
+	writeFile(t, logFile, string(sample))
+
+	dbFile := filepath.Join(dir, "test.db")
+	archive, err := Open(dbFile)
+	if err != nil {
+		t.Fatalf("Unable to open archive: %s", err.Error())
+	}
+
+	count, err := archive.Sync(logFile, location)
+	if err != nil {
+		t.Fatalf("Unable to sync: %s", err.Error())
+	}
+	if count != 38 {
+		t.Errorf("Imported %d entries, wanted 38", count)
+	}
+
+	// We only import what is new.
+
+	count, err = archive.Sync(logFile, location)
+	if err != nil {
+		t.Fatalf("Unable to sync: %s", err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Imported %d entries again, wanted 0", count)
+	}
+
+	// Even after reopening.
+
+	err = archive.Close()
+	if err != nil {
+		t.Fatalf("Unable to close archive: %s", err.Error())
+	}
+
+	archive, err = Open(dbFile)
+	if err != nil {
+		t.Fatalf("Unable to open archive: %s", err.Error())
+	}
+	defer func() {
+		_ = archive.Close()
+	}()
+
+	writeFile(t, logFile, string(sample)+
+		"--- Log opened Wed Mar 30 08:00:00 2016\n"+
+		"08:01 <@bob> the deploy is stuck\n"+
+		"08:02 < alice> again")
+
+	count, err = archive.Sync(logFile, location)
+	if err != nil {
+		t.Fatalf("Unable to sync: %s", err.Error())
+	}
+	if count != 2 {
+		t.Errorf("Imported %d new entries, wanted 2", count)
+	}
+
+	results, err := archive.Search("deploy*", 0)
+	if err != nil {
+		t.Fatalf("Unable to search: %s", err.Error())
+	}
+
+	wantedTexts := []string{
+		"hello bob, how is the deploy going?",
+		"alice: it deployed fine",
+		"Deploys on Tuesdays",
+		"check https://example.com/deploy for the log",
+		"did the deploy finish?",
+		"alice_: yes, deployed at midnight",
+		"deploy day!",
+		"deployed(ed)? or not",
+		"the deploy is stuck",
+	}
+
+	if len(results) != len(wantedTexts) {
+		t.Fatalf("Found %d results, wanted %d", len(results), len(wantedTexts))
+	}
+
+	for i, result := range results {
+		if result.Entry.Text != wantedTexts[i] {
+			t.Errorf("Result %d is %q, wanted %q", i, result.Entry.Text,
+				wantedTexts[i])
+		}
+		if result.File != logFile {
+			t.Errorf("Result %d is from %s, wanted %s", i, result.File, logFile)
+		}
+	}
+
+	last := results[len(results)-1].Entry
+	wantedTime := time.Date(2016, 3, 30, 8, 1, 0, 0, location)
+	if last.Type != irssi_log.Message || last.Nick != "bob" ||
+		last.NickMode != "@" || !last.Time.Equal(wantedTime) ||
+		last.Time.Location().String() != location.String() ||
+		last.LineNumber != 40 {
+		t.Errorf("Last result is %+v", last)
+	}
+
+	results, err = archive.Search("deploy", 1)
+	if err != nil {
+		t.Fatalf("Unable to search: %s", err.Error())
+	}
+	if len(results) != 1 {
+		t.Errorf("Found %d results with a limit of 1", len(results))
+	}
+
+	// If the log is rotated we import the new one from the start, and keep the
+	// old one's entries.
+
+	writeFile(t, logFile, "--- Log opened Thu Mar 31 08:00:00 2016\n"+
+		"08:01 <@bob> a new log\n")
+
+	count, err = archive.Sync(logFile, location)
+	if err != nil {
+		t.Fatalf("Unable to sync: %s", err.Error())
+	}
+	if count != 2 {
+		t.Errorf("Imported %d entries from the new log, wanted 2", count)
+	}
+
+	results, err = archive.Search("deploy*", 0)
+	if err != nil {
+		t.Fatalf("Unable to search: %s", err.Error())
+	}
+	if len(results) != len(wantedTexts) {
+		t.Errorf("Found %d results from the old log, wanted %d", len(results),
+			len(wantedTexts))
+	}
+	for _, result := range results {
+		if result.File != logFile ||
+			result.Entry.Time.Location().String() != location.String() {
+			t.Errorf("Result from the old log is %+v from %s", result.Entry,
+				result.File)
+		}
+	}
+
+	results, err = archive.Search("new", 0)
+	if err != nil {
+		t.Fatalf("Unable to search: %s", err.Error())
+	}
+	if len(results) != 1 || results[0].Entry.LineNumber != 2 {
+		t.Errorf("Found %+v from the new log, wanted line 2", results)
+	}
+
+	// The new log carries on from where we got to in it.
+
+	writeFile(t, logFile, "--- Log opened Thu Mar 31 08:00:00 2016\n"+
+		"08:01 <@bob> a new log\n"+
+		"08:02 <@bob> another new line\n")
+
+	count, err = archive.Sync(logFile, location)
+	if err != nil {
+		t.Fatalf("Unable to sync: %s", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Imported %d entries added to the new log, wanted 1", count)
+	}
+
+	results, err = archive.Search("new", 0)
+	if err != nil {
+		t.Fatalf("Unable to search: %s", err.Error())
+	}
+	if len(results) != 2 {
+		t.Errorf("Found %d results from the new log, wanted 2", len(results))
+	}
+}
+
+func writeFile(t *testing.T, filename, content string) {
+	err := ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Load Irssi logs into a SQLite archive, and search it.
+ *
+ * Running it again on the same logs imports only the lines added since.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/horgh/irssi_log/archive"
+)
+
+func main() {
+	dbFile := flag.String("db", "", "Path to the database file. It is created if necessary.")
+	locationString := flag.String("location", "America/Vancouver", "Time zone location of logs we have not imported before.")
+	search := flag.String("search", "", "Search the archive for entries with this text rather than importing. This uses SQLite FTS5 query syntax. Optional.")
+	limit := flag.Int("limit", 100, "Limit number of search results. 0 for all.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [log file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if len(*dbFile) == 0 {
+		log.Print("You must specify a database file.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(*search) == 0 && flag.NArg() == 0 {
+		log.Print("You must specify log files to import or a search.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *limit < 0 {
+		log.Print("You must specify a limit >= 0.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	location, err := time.LoadLocation(*locationString)
+	if err != nil {
+		log.Printf("Invalid location: %s", err.Error())
+		os.Exit(1)
+	}
+
+	db, err := archive.Open(*dbFile)
+	if err != nil {
+		log.Print(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	for _, logFile := range flag.Args() {
+		count, err := db.Sync(logFile, location)
+		if err != nil {
+			log.Printf("Unable to import %s: %s", logFile, err.Error())
+			os.Exit(1)
+		}
+
+		log.Printf("Imported %d entries from %s.", count, logFile)
+	}
+
+	if len(*search) == 0 {
+		return
+	}
+
+	results, err := db.Search(*search, *limit)
+	if err != nil {
+		log.Print(err.Error())
+		os.Exit(1)
+	}
+
+	for _, result := range results {
+		fmt.Printf("%s:%d: %s %s\n", result.File, result.Entry.LineNumber,
+			result.Entry.Time.Format("2006-01-02"), result.Entry.Line)
+	}
+}
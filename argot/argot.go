@@ -1,5 +1,28 @@
 /*
  * Markov text generation using an Irssi channel log
+ *
+ * We build a word level Markov model from the log's messages, or from text
+ * from messages_to_string. Save the model with -model and later runs can load
+ * it rather than building it again.
+ *
+ * We generate whole messages: ones that start the way messages start and end
+ * where messages end. We print each on its own line.
+ *
+ * Give -seed to generate the same messages again from the same model.
+ *
+ * With -reply or -interactive, we generate replies to a message. We learn what
+ * replies are about from the messages that followed others, so the model must
+ * be built from a log file or a file with nicks.
+ *
+ * The model also holds a model of each nick's messages, so we can generate in
+ * the style of one nick, or of a mix of several with -nicks. Nicks one person
+ * used count as one. To keep nicks when building from a file, write it with
+ * messages_to_string -nicks and use -by-nick. When building from a log file,
+ * -shared-hosts stops us treating nicks as one person because they had a
+ * user@host that different people share.
+ *
+ * -tokenize says how to split messages into words when building a model. The
+ * model remembers, and splits -start and -reply the same way.
  */
 
 package main
@@ -8,33 +31,64 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"regexp"
 	"runtime"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/horgh/irssi_log/suffixarray"
+	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/markov"
+	"github.com/horgh/irssi_log/tokenizer"
 )
 
 func main() {
-	file := flag.String("file", "", "Path to a file to read. Should be one block of text. You can process a log to get this using the messages_to_string program.")
-	sentenceLength := flag.Int("sentence-length", 12, "Numbers of words to generate.")
+	file := flag.String("file", "", "Path to a file to build the model from. Should have one message per line. You can process a log to get this using the messages_to_string program.")
+	logFile := flag.String("log-file", "", "Path to a log file to build the model from.")
+	locationString := flag.String("location", "America/Vancouver", "Time zone location of the log file.")
+	modelFile := flag.String("model", "", "Path to a model file. If we build a model, we save it here. Otherwise we load it from here.")
+	maxOrder := flag.Int("max-order", 3, "When building a model, the largest k it supports.")
+	minLength := flag.Int("min-length", 1, "Generate a message with at least this many words.")
+	maxLength := flag.Int("max-length", 30, "Generate a message with at most this many words.")
 	k := flag.Int("k", 2, "How many preceding words to take into account when picking the next.")
+	byNick := flag.Bool("by-nick", false, "The file has one message per line, prefixed by the nick who said it and a tab. You can write this using messages_to_string -nicks.")
+	seed := flag.Int64("seed", 0, "Seed for the random number generator. The same seed and model generate the same messages. 0 to use the time. We log the seed we use.")
+	start := flag.String("start", "", "Words to start messages with.")
+	temperature := flag.Float64("temperature", 1, "Above 1, pick rare words more often than they occurred. Below 1, pick common words more often.")
+	maxCopy := flag.Int("max-copy", 0, "Don't copy more than this many words in a row from the corpus. 0 for no limit.")
+	count := flag.Int("count", 1, "Number of messages to generate.")
+	reply := flag.String("reply", "", "Generate replies to this message.")
+	interactive := flag.Bool("interactive", false, "Read messages from stdin and generate a reply to each.")
+	tokenize := flag.String("tokenize", "", "When building a model, how to split messages into words. Comma separated tokenizer options: lowercase, punctuation (split punctuation off words), urls=drop, urls=keep, urls=replace, nicks (replace nicks with a placeholder), numbers (replace numbers with a placeholder), strip-address (leave out the nick a message addresses). If the file is from messages_to_string, use the same options as it did.")
+	nicks := flag.String("nicks", "", "Generate in the style of these nicks rather than everyone. Separate nicks with commas. Give a nick a weight with a colon, such as alice:2,bob. Nicks have weight 1 by default.")
+	sharedHosts := flag.String("shared-hosts", "", "When building a model from a log file, don't merge nicks by user@hosts matching this regular expression, such as those of webchat gateways or shared bouncers that more than one person uses. Use . to merge nicks only by nick changes.")
 
 	flag.Parse()
 
-	if len(*file) == 0 {
-		log.Print("You must specify a file.")
+	if len(*file) == 0 && len(*logFile) == 0 && len(*modelFile) == 0 {
+		log.Print("You must specify a file, a log file, or a model.")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	if *sentenceLength <= 0 {
-		log.Print("You must specify a sentence length >= 0.")
+	if len(*file) > 0 && len(*logFile) > 0 {
+		log.Print("You may only specify one of a file and a log file.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *minLength <= 0 {
+		log.Print("You must specify a minimum length > 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *maxLength < *minLength {
+		log.Print("You must specify a maximum length >= the minimum length.")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -45,170 +99,305 @@ func main() {
 		os.Exit(1)
 	}
 
-	logMemory()
+	if *byNick && len(*file) == 0 {
+		log.Print("You must specify a file to read by nick.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *temperature <= 0 {
+		log.Print("You must specify a temperature > 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *maxCopy < 0 {
+		log.Print("You must specify a max copy >= 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *count <= 0 {
+		log.Print("You must specify a count > 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if len(*tokenize) > 0 && len(*file) == 0 && len(*logFile) == 0 {
+		log.Print("You may only specify tokenizer options when building a model.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 
-	log.Printf("Reading file...")
-	text, err := readFile(*file)
+	tokenizerOptions, err := tokenizer.ParseOptions(*tokenize)
 	if err != nil {
 		log.Print(err.Error())
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	logMemory()
+	if len(*sharedHosts) > 0 && len(*logFile) == 0 {
+		log.Print("You may only specify shared hosts when building a model from a log file.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var sharedHostsRE *regexp.Regexp
+	if len(*sharedHosts) > 0 {
+		sharedHostsRE, err = regexp.Compile(*sharedHosts)
+		if err != nil {
+			log.Printf("Invalid shared hosts pattern: %s", err.Error())
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	if len(*reply) > 0 && *interactive {
+		log.Print("You may only specify one of reply and interactive.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 
-	log.Printf("Generating suffix array...")
-	a, err := suffixarray.Build(text)
+	weights, err := parseNicks(*nicks)
 	if err != nil {
 		log.Print(err.Error())
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	logMemory()
+	if (len(*file) > 0 || len(*logFile) > 0) && *maxOrder < *k {
+		log.Print("You must specify a max order >= k.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 
-	log.Printf("Sorting suffix array...")
-	s, err := suffixarray.Sort(a)
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	location, err := time.LoadLocation(*locationString)
 	if err != nil {
-		log.Print(err.Error())
+		log.Printf("Invalid location: %s", err.Error())
 		os.Exit(1)
 	}
 
 	logMemory()
 
-	log.Printf("Generating text...")
-	rand.Seed(time.Now().UnixNano())
-	sentence, err := generateTextFromSuffixArray(s, *sentenceLength, *k)
+	model, err := getModel(*file, *byNick, *logFile, location, *modelFile,
+		*maxOrder, tokenizerOptions, sharedHostsRE)
 	if err != nil {
 		log.Print(err.Error())
 		os.Exit(1)
 	}
-	log.Printf("Generated: %s", sentence)
-}
 
-// readFile reads in a file as a string
-func readFile(filename string) (string, error) {
-	fh, err := os.Open(filename)
-	if err != nil {
-		return "", fmt.Errorf("Unable to open file: %s: %s", filename, err.Error())
+	logMemory()
+
+	generator := markov.Generator(model)
+	if weights != nil {
+		generator, err = model.Mix(weights)
+		if err != nil {
+			log.Print(err.Error())
+			log.Printf("Nicks: %s", strings.Join(model.Nicks(), ", "))
+			os.Exit(1)
+		}
 	}
-	defer fh.Close()
 
-	reader := bufio.NewReader(fh)
-	text := ""
-	buf := make([]byte, 10*1024*1024)
-	for {
-		n, err := reader.Read(buf)
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Printf("Generating text with seed %d...", *seed)
+	rng := rand.New(rand.NewSource(*seed))
+
+	options := markov.Options{
+		K:           *k,
+		MinLength:   *minLength,
+		MaxLength:   *maxLength,
+		Start:       model.Tokenizer().Tokens(*start),
+		Temperature: *temperature,
+		MaxCopy:     *maxCopy,
+	}
+
+	if *interactive {
+		err := replyInteractively(model, generator, rng, options)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", fmt.Errorf("Read error: %s", err.Error())
+			log.Print(err.Error())
+			os.Exit(1)
 		}
-		text += string(buf[0:n])
+		return
 	}
 
-	return text, nil
+	for i := 0; i < *count; i++ {
+		var words []string
+		if len(*reply) > 0 {
+			words, err = model.Reply(rng, generator, *reply, options)
+		} else {
+			words, err = generator.Generate(rng, options)
+		}
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(strings.Join(words, " "))
+	}
 }
 
-// generateTextFromSuffixArray generates random text.
-func generateTextFromSuffixArray(suffixArray []string, length int, k int) (
-	string, error) {
-	text := ""
-	phrase := ""
+// replyInteractively reads messages from stdin and prints a reply to each.
+func replyInteractively(model *markov.Model, generator markov.Generator,
+	rng *rand.Rand, options markov.Options) error {
+	scanner := bufio.NewScanner(os.Stdin)
 
-	for i := 0; i < length; i++ {
-		if phrase == "" {
-			phrase = getRandomPhrase(suffixArray, k)
-			text += phrase + " "
-			continue
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			break
 		}
 
-		// The function must return true for elements past which they are equal
-		searchPhrase := phrase + " "
-		phraseIndex := sort.Search(
-			len(suffixArray),
-			func(i int) bool {
-				for j := 0; j < len(searchPhrase) && j < len(suffixArray[i]); j++ {
-					if searchPhrase[j] == suffixArray[i][j] {
-						continue
-					}
-					if suffixArray[i][j] > searchPhrase[j] {
-						return true
-					}
-					return false
-				}
-
-				return true
-			})
-
-		// Not found.
-		if phraseIndex == len(suffixArray) {
-			log.Printf("Phrase %s not found. Picking at random...", phrase)
-			phrase = getRandomPhrase(suffixArray, k)
-			log.Printf("Chose %s", phrase)
-			text += phrase + " "
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
 			continue
 		}
 
-		phraseFull := ""
-		// Start at zero so our random choice method works.
-		// We want to always pick something! (%1==0)
-		for j := 0; j+phraseIndex < len(suffixArray) &&
-			strings.HasPrefix(suffixArray[j+phraseIndex], phrase+" "); j++ {
-			if rand.Int()%(j+1) == 0 {
-				phraseFull = suffixArray[j+phraseIndex]
-			}
+		words, err := model.Reply(rng, generator, line, options)
+		if err != nil {
+			return err
 		}
+		fmt.Println(strings.Join(words, " "))
+	}
+	fmt.Println()
 
-		phrase = getKWords(phraseFull, k, k)
-		text += phrase + " "
+	err := scanner.Err()
+	if err != nil {
+		return fmt.Errorf("Unable to read: %s", err.Error())
 	}
 
-	return text, nil
+	return nil
 }
 
-// getRandomPhrase takes k words from a random selection in the suffix array
-func getRandomPhrase(suffixArray []string, k int) string {
-	prefix := suffixArray[rand.Intn(len(suffixArray))]
-	return getKWords(prefix, 0, k)
+// parseNicks parses the -nicks flag into the weight of each nick. It gives nil
+// if there are no nicks.
+func parseNicks(nicks string) (map[string]float64, error) {
+	if len(nicks) == 0 {
+		return nil, nil
+	}
+
+	weights := map[string]float64{}
+
+	for _, field := range strings.Split(nicks, ",") {
+		nick := strings.TrimSpace(field)
+		weight := 1.0
+
+		if i := strings.Index(nick, ":"); i != -1 {
+			var err error
+			weight, err = strconv.ParseFloat(nick[i+1:], 64)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("Invalid weight: %s", field)
+			}
+			nick = nick[:i]
+		}
+
+		if len(nick) == 0 {
+			return nil, fmt.Errorf("Invalid nick: %s", field)
+		}
+
+		weights[nick] += weight
+	}
+
+	return weights, nil
 }
 
-// getKWords extracts k words from the given string
-func getKWords(text string, skip int, count int) string {
-	words := ""
-	word := ""
-	wordCount := 0
-	skippedCount := 0
+// getModel builds a model from the text file or the log file, and saves it if
+// we have a model file. If there is neither, we load the model file.
+func getModel(file string, byNick bool, logFile string,
+	location *time.Location, modelFile string, maxOrder int,
+	tokenizerOptions tokenizer.Options,
+	sharedHosts *regexp.Regexp) (*markov.Model, error) {
+	if len(file) == 0 && len(logFile) == 0 {
+		log.Printf("Loading model...")
+		return markov.Load(modelFile)
+	}
 
-	for _, c := range text {
-		if c != ' ' {
-			word += string(c)
-			continue
+	tok := tokenizer.New(tokenizerOptions)
+	builder := markov.NewBuilderWithTokenizer(maxOrder, tok)
+
+	if len(file) > 0 {
+		log.Printf("Reading file...")
+		text, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read file: %s: %s", file, err.Error())
 		}
 
-		if len(word) == 0 {
-			continue
+		log.Printf("Building model...")
+		if byNick {
+			err = addNickMessages(builder, tok, string(text))
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read file: %s: %s", file,
+					err.Error())
+			}
+		} else {
+			builder.AddText(string(text))
+		}
+	} else {
+		fh, err := os.Open(logFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open file: %s: %s", logFile,
+				err.Error())
 		}
+		defer fh.Close()
 
-		if skip > 0 && skippedCount < skip {
-			skippedCount++
-			word = ""
-			continue
+		log.Printf("Building model...")
+		builder.SetSharedHosts(sharedHosts)
+		err = builder.AddEntries(irssi_log.NewReader(fh,
+			irssi_log.NewParser(location)))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse log: %s", err.Error())
 		}
+	}
+
+	model := builder.Model()
 
-		if len(words) > 0 {
-			words += " "
+	if len(modelFile) > 0 {
+		log.Printf("Saving model...")
+		err := model.Save(modelFile)
+		if err != nil {
+			return nil, err
 		}
-		words += word
-		word = ""
+	}
 
-		wordCount++
+	return model, nil
+}
 
-		if wordCount >= count {
-			return words
+// addNickMessages adds messages in the format messages_to_string -nicks
+// writes: one per line, as the nick, a tab, and the text. We don't know when
+// they were said, so each line counts as a reply to the one before.
+//
+// The tokenizer learns every nick before we add any messages, so each is split
+// into words the same way.
+func addNickMessages(builder *markov.Builder, tok *tokenizer.Tokenizer,
+	text string) error {
+	var nicks, messages []string
+
+	for i, line := range strings.Split(text, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+
+		tab := strings.Index(line, "\t")
+		if tab <= 0 {
+			return fmt.Errorf("Line %d has no nick", i+1)
 		}
+
+		tok.AddNick(line[:tab])
+		nicks = append(nicks, line[:tab])
+		messages = append(messages, line[tab+1:])
+	}
+
+	for i, nick := range nicks {
+		builder.AddMessage(nick, messages[i], time.Time{})
 	}
 
-	return ""
+	return nil
 }
 
 // logMemory logs the memory used.
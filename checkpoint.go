@@ -0,0 +1,245 @@
+/*
+ * Checkpoints let us stop parsing a log and pick up where we left off later.
+ */
+
+package irssi_log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// checkpointHeadSize is how much of the start of a log we hash to recognize
+// it later.
+const checkpointHeadSize = 4096
+
+// ErrStaleCheckpoint means a checkpoint does not match the log. Most likely
+// the log was rotated or rewritten after we took the checkpoint.
+var ErrStaleCheckpoint = errors.New("Checkpoint does not match the log")
+
+// Checkpoint records everything needed to resume parsing a log part way
+// through.
+type Checkpoint struct {
+	// Offset is the byte offset of the next line to parse.
+	Offset int64
+
+	// LineNumber is the number of lines parsed so far.
+	LineNumber int
+
+	// CurrentDate is the date from the most recent LogOpen or DayChange line.
+	CurrentDate time.Time
+
+	// Location is the name of the time zone location.
+	Location string
+
+	// SelfNick is our own nick, if known.
+	SelfNick string
+
+	// HeadHash is a hash of the start of the log, up to Offset. We use it to
+	// tell if the log is still the same file.
+	HeadHash string
+}
+
+// Checkpoint records the Parser's current position. file is the log the
+// Parser is reading.
+func (p *Parser) Checkpoint(file *os.File) (*Checkpoint, error) {
+	headHash, err := hashHead(file, p.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Checkpoint{
+		Offset:      p.offset,
+		LineNumber:  p.lineNumber,
+		CurrentDate: p.currentDate,
+		Location:    p.location.String(),
+		SelfNick:    p.selfNick,
+		HeadHash:    headHash,
+	}, nil
+}
+
+// NewParserFromCheckpoint creates a Parser that carries on from a checkpoint.
+func NewParserFromCheckpoint(checkpoint *Checkpoint) (*Parser, error) {
+	location, err := time.LoadLocation(checkpoint.Location)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid location: %s", err.Error())
+	}
+
+	currentDate := checkpoint.CurrentDate
+	if !currentDate.IsZero() {
+		currentDate = currentDate.In(location)
+	}
+
+	return &Parser{
+		location:    location,
+		currentDate: currentDate,
+		selfNick:    checkpoint.SelfNick,
+		lineNumber:  checkpoint.LineNumber,
+		offset:      checkpoint.Offset,
+	}, nil
+}
+
+// Check makes sure the checkpoint still matches the log. The log must be at
+// least as large as the checkpoint's offset, and start with the same bytes.
+//
+// It returns ErrStaleCheckpoint if not.
+func (c *Checkpoint) Check(file *os.File) error {
+	fi, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Unable to stat file: %s", err.Error())
+	}
+
+	if fi.Size() < c.Offset {
+		return ErrStaleCheckpoint
+	}
+
+	headHash, err := hashHead(file, c.Offset)
+	if err != nil {
+		return err
+	}
+
+	if headHash != c.HeadHash {
+		return ErrStaleCheckpoint
+	}
+
+	return nil
+}
+
+// ResumeLog parses a log starting from a checkpoint. It gives the same
+// entries a full ParseLog would from that point on.
+//
+// It returns the entries along with a checkpoint for the new position. If
+// lineLimit is positive, we stop after that many lines. As with
+// NewReaderFromCheckpoint, we leave a partial line at the end of the log for
+// later.
+func ResumeLog(file *os.File, checkpoint *Checkpoint, lineLimit int) (
+	[]*LogEntry, *Checkpoint, error) {
+	reader, parser, err := NewReaderFromCheckpoint(file, checkpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := readEntries(reader, lineLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newCheckpoint, err := parser.Checkpoint(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entries, newCheckpoint, nil
+}
+
+// NewReaderFromCheckpoint creates a Reader that carries on from a checkpoint.
+// Use the Parser it gives to take checkpoints as you read.
+//
+// The Reader reads only complete lines. If the log ends with a partial line,
+// it may still be being written, so we leave it for later.
+//
+// It returns ErrStaleCheckpoint if the checkpoint does not match the log.
+func NewReaderFromCheckpoint(file *os.File, checkpoint *Checkpoint) (*Reader,
+	*Parser, error) {
+	err := checkpoint.Check(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parser, err := NewParserFromCheckpoint(checkpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to stat file: %s", err.Error())
+	}
+
+	end, err := lastLineEnd(file, fi.Size())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if end < checkpoint.Offset {
+		end = checkpoint.Offset
+	}
+
+	reader := NewReader(
+		io.NewSectionReader(file, checkpoint.Offset, end-checkpoint.Offset), parser)
+
+	return reader, parser, nil
+}
+
+// ReadCheckpoint loads a checkpoint from a file.
+func ReadCheckpoint(filename string) (*Checkpoint, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read checkpoint: %s: %s", filename,
+			err.Error())
+	}
+
+	var checkpoint Checkpoint
+	err = json.Unmarshal(buf, &checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode checkpoint: %s: %s", filename,
+			err.Error())
+	}
+
+	return &checkpoint, nil
+}
+
+// WriteCheckpoint saves a checkpoint to a file.
+func WriteCheckpoint(filename string, checkpoint *Checkpoint) error {
+	buf, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("Unable to encode checkpoint: %s", err.Error())
+	}
+
+	return WriteFileAtomically(filename, buf)
+}
+
+// WriteFileAtomically writes to a temporary file and renames it into place.
+// This way a crash does not leave a partial file behind.
+func WriteFileAtomically(filename string, buf []byte) error {
+	tmpFilename := filename + ".tmp"
+
+	err := ioutil.WriteFile(tmpFilename, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to write file: %s: %s", tmpFilename,
+			err.Error())
+	}
+
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		return fmt.Errorf("Unable to rename file: %s: %s", tmpFilename,
+			err.Error())
+	}
+
+	return nil
+}
+
+// hashHead hashes the start of a file, up to checkpointHeadSize bytes but no
+// further than offset.
+func hashHead(file *os.File, offset int64) (string, error) {
+	size := offset
+	if size > checkpointHeadSize {
+		size = checkpointHeadSize
+	}
+
+	buf := make([]byte, size)
+	_, err := file.ReadAt(buf, 0)
+	if err != nil {
+		return "", fmt.Errorf("Read error: %s", err.Error())
+	}
+
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
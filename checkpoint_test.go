@@ -0,0 +1,379 @@
+package irssi_log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResumeLog(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	filename := filepath.Join("testdata", "sample.log")
+
+	fullEntries := parseFile(t, filename, location)
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	checkpointFile := filepath.Join(dir, "checkpoint")
+
+	// Stop after each line in turn, then resume. We should always end up with
+	// the same entries as the full parse.
+
+	for i := 0; i <= len(fullEntries); i++ {
+		fh, err := os.Open(filename)
+		if err != nil {
+			t.Fatalf("Unable to open file: %s", err.Error())
+		}
+
+		parser := NewParser(location)
+		reader := NewReader(fh, parser)
+		for j := 0; j < i; j++ {
+			_, err := reader.Next()
+			if err != nil {
+				t.Fatalf("Unable to read entry: %s", err.Error())
+			}
+		}
+
+		checkpoint, err := parser.Checkpoint(fh)
+		if err != nil {
+			t.Fatalf("Unable to create checkpoint: %s", err.Error())
+		}
+
+		err = WriteCheckpoint(checkpointFile, checkpoint)
+		if err != nil {
+			t.Fatalf("Unable to write checkpoint: %s", err.Error())
+		}
+
+		_ = fh.Close()
+
+		checkpoint, err = ReadCheckpoint(checkpointFile)
+		if err != nil {
+			t.Fatalf("Unable to read checkpoint: %s", err.Error())
+		}
+
+		fh, err = os.Open(filename)
+		if err != nil {
+			t.Fatalf("Unable to open file: %s", err.Error())
+		}
+
+		entries, newCheckpoint, err := ResumeLog(fh, checkpoint, 0)
+		_ = fh.Close()
+		if err != nil {
+			t.Fatalf("Unable to resume after %d lines: %s", i, err.Error())
+		}
+
+		if len(entries) != len(fullEntries)-i {
+			t.Errorf("Resuming after %d lines gave %d entries, wanted %d", i,
+				len(entries), len(fullEntries)-i)
+			continue
+		}
+
+		for j, entry := range entries {
+			if !entriesEqual(entry, fullEntries[i+j]) {
+				t.Errorf("Resuming after %d lines: entry %d differs: %+v, wanted %+v",
+					i, j, entry, fullEntries[i+j])
+			}
+		}
+
+		last := fullEntries[len(fullEntries)-1]
+		if newCheckpoint.LineNumber != last.LineNumber {
+			t.Errorf("Final checkpoint at line %d, wanted %d",
+				newCheckpoint.LineNumber, last.LineNumber)
+		}
+	}
+}
+
+func TestCheckpointStale(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	filename := filepath.Join(dir, "test.log")
+
+	content := "--- Log opened Sun Mar 27 15:04:05 2016\n" +
+		"15:05 <@bob> hi alice\n"
+
+	err = ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	parser := NewParser(location)
+	_, err = readEntries(NewReader(fh, parser), 0)
+	if err != nil {
+		t.Fatalf("Unable to parse log: %s", err.Error())
+	}
+
+	checkpoint, err := parser.Checkpoint(fh)
+	_ = fh.Close()
+	if err != nil {
+		t.Fatalf("Unable to create checkpoint: %s", err.Error())
+	}
+
+	type TestCase struct {
+		Content string
+		Stale   bool
+	}
+
+	cases := []TestCase{
+		// Appended to.
+		TestCase{
+			Content: content + "15:06 <@bob> still here\n",
+			Stale:   false,
+		},
+		// Truncated.
+		TestCase{
+			Content: "",
+			Stale:   true,
+		},
+		// Rewritten with something just as long.
+		TestCase{
+			Content: "--- Log opened Mon Mar 28 15:04:05 2016\n" +
+				"15:05 <@bob> hi carol\n",
+			Stale: true,
+		},
+	}
+
+	for _, testCase := range cases {
+		err := ioutil.WriteFile(filename, []byte(testCase.Content), 0644)
+		if err != nil {
+			t.Fatalf("Unable to write file: %s", err.Error())
+		}
+
+		fh, err := os.Open(filename)
+		if err != nil {
+			t.Fatalf("Unable to open file: %s", err.Error())
+		}
+
+		err = checkpoint.Check(fh)
+		_ = fh.Close()
+
+		if testCase.Stale && err != ErrStaleCheckpoint {
+			t.Errorf("Checkpoint not stale for content %q: %v", testCase.Content,
+				err)
+		}
+		if !testCase.Stale && err != nil {
+			t.Errorf("Checkpoint stale for content %q: %s", testCase.Content,
+				err.Error())
+		}
+	}
+}
+
+func TestNewReaderFromCheckpointPartialLine(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	filename := filepath.Join(dir, "test.log")
+
+	content := "--- Log opened Sun Mar 27 15:04:05 2016\n" +
+		"15:05 <@bob> hi alice\n" +
+		"15:06 <@bob> still"
+
+	err = ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+
+	checkpoint, err := NewParser(location).Checkpoint(fh)
+	if err != nil {
+		t.Fatalf("Unable to create checkpoint: %s", err.Error())
+	}
+
+	reader, parser, err := NewReaderFromCheckpoint(fh, checkpoint)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %s", err.Error())
+	}
+
+	entries, err := readEntries(reader, 0)
+	if err != nil {
+		t.Fatalf("Unable to parse log: %s", err.Error())
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("Read %d entries, wanted 2", len(entries))
+	}
+
+	checkpoint, err = parser.Checkpoint(fh)
+	_ = fh.Close()
+	if err != nil {
+		t.Fatalf("Unable to create checkpoint: %s", err.Error())
+	}
+
+	// Once the line is complete we read it.
+
+	appendToFile(t, filename, " here\n")
+
+	fh, err = os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	reader, _, err = NewReaderFromCheckpoint(fh, checkpoint)
+	if err != nil {
+		t.Fatalf("Unable to create reader: %s", err.Error())
+	}
+
+	entries, err = readEntries(reader, 0)
+	if err != nil {
+		t.Fatalf("Unable to parse log: %s", err.Error())
+	}
+
+	if len(entries) != 1 || entries[0].Text != "still here" ||
+		entries[0].LineNumber != 3 {
+		t.Errorf("Read %+v, wanted the completed line", entries)
+	}
+}
+
+func TestResumeLogPartialLine(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	filename := filepath.Join(dir, "test.log")
+
+	content := "--- Log opened Sun Mar 27 15:04:05 2016\n" +
+		"15:05 <@bob> hi alice\n" +
+		"15:06 <@bob> still"
+
+	err = ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+
+	checkpoint, err := NewParser(location).Checkpoint(fh)
+	if err != nil {
+		t.Fatalf("Unable to create checkpoint: %s", err.Error())
+	}
+
+	entries, checkpoint, err := ResumeLog(fh, checkpoint, 0)
+	_ = fh.Close()
+	if err != nil {
+		t.Fatalf("Unable to resume log: %s", err.Error())
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("Read %d entries, wanted 2", len(entries))
+	}
+
+	wantOffset := int64(strings.LastIndex(content, "\n") + 1)
+	if checkpoint.Offset != wantOffset {
+		t.Errorf("Checkpoint is at offset %d, wanted %d", checkpoint.Offset,
+			wantOffset)
+	}
+
+	// Once the line is complete we read all of it.
+
+	appendToFile(t, filename, " here\n")
+
+	fh, err = os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	entries, _, err = ResumeLog(fh, checkpoint, 0)
+	if err != nil {
+		t.Fatalf("Unable to resume log: %s", err.Error())
+	}
+
+	if len(entries) != 1 || entries[0].Text != "still here" ||
+		entries[0].LineNumber != 3 {
+		t.Errorf("Read %+v, wanted the completed line", entries)
+	}
+}
+
+// parseFile parses a whole log file.
+func parseFile(t *testing.T, filename string,
+	location *time.Location) []*LogEntry {
+	fh, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	entries, err := ParseLog(fh, 0, location)
+	if err != nil {
+		t.Fatalf("Unable to parse log: %s", err.Error())
+	}
+
+	return entries
+}
+
+// entriesEqual checks if two entries have the same content.
+func entriesEqual(a, b *LogEntry) bool {
+	return a.Line == b.Line &&
+		a.Time.Equal(b.Time) &&
+		a.Type == b.Type &&
+		a.Channel == b.Channel &&
+		a.Nick == b.Nick &&
+		a.NickMode == b.NickMode &&
+		a.ByNick == b.ByNick &&
+		a.UserHost == b.UserHost &&
+		a.Text == b.Text &&
+		a.NoticeTarget == b.NoticeTarget &&
+		a.SyncSeconds == b.SyncSeconds &&
+		a.LineNumber == b.LineNumber &&
+		a.Offset == b.Offset
+}
@@ -0,0 +1,180 @@
+/*
+ * Ways to hold many entries in less memory.
+ *
+ * In a large log the same nicks, channels, and user@hosts show up over and
+ * over. Rather than keep a copy for each entry, we can keep one and share it.
+ */
+
+package irssi_log
+
+import (
+	"math"
+	"time"
+)
+
+// noTime is the CompactEntry Time of an entry with no time. Unlike 0, no
+// entry time is this instant.
+const noTime = math.MinInt64
+
+// Interner hands out a single shared copy of each distinct string.
+type Interner struct {
+	strings map[string]string
+}
+
+// NewInterner creates an Interner.
+func NewInterner() *Interner {
+	return &Interner{strings: map[string]string{}}
+}
+
+// Intern gives the shared copy of a string.
+func (i *Interner) Intern(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	shared, exists := i.strings[s]
+	if exists {
+		return shared
+	}
+
+	// Copy it. s is often part of a larger string, such as a raw line, and we
+	// don't want to keep that around.
+	shared = copyString(s)
+	i.strings[shared] = shared
+	return shared
+}
+
+// Len gives how many distinct strings there are.
+func (i *Interner) Len() int {
+	return len(i.strings)
+}
+
+// CompactEntry is a LogEntry in less space.
+//
+// It does not have the raw line. Nicks, channels, user@hosts, and notice
+// targets are indexes into the CompactLog's string table.
+type CompactEntry struct {
+	// Unix time in seconds, or noTime if the entry has no time. Entry times
+	// never have a finer resolution.
+	Time int64
+
+	// Byte offset of the start of the line in the log
+	Offset int64
+
+	// Text, if applicable
+	Text string
+
+	// Line number in the log, starting at 1
+	LineNumber int64
+
+	// SyncSeconds is how long a join took to sync, for a JoinSync.
+	SyncSeconds int64
+
+	// Indexes into the string table
+	Nick         uint32
+	NickMode     uint32
+	ByNick       uint32
+	Channel      uint32
+	UserHost     uint32
+	NoticeTarget uint32
+
+	// Type is the EntryType.
+	Type uint8
+}
+
+// CompactLog holds entries as CompactEntrys.
+type CompactLog struct {
+	Entries []CompactEntry
+
+	location *time.Location
+
+	// strings holds each nick, channel, and user@host once. Index 0 is the
+	// blank string.
+	strings []string
+	ids     map[string]uint32
+}
+
+// NewCompactLog creates an empty CompactLog. Entry times come back in the
+// given location.
+func NewCompactLog(location *time.Location) *CompactLog {
+	return &CompactLog{
+		location: location,
+		strings:  []string{""},
+		ids:      map[string]uint32{"": 0},
+	}
+}
+
+// Add stores an entry.
+func (c *CompactLog) Add(entry *LogEntry) {
+	compact := CompactEntry{
+		Offset:       entry.Offset,
+		Text:         copyString(entry.Text),
+		LineNumber:   int64(entry.LineNumber),
+		SyncSeconds:  int64(entry.SyncSeconds),
+		Nick:         c.stringID(entry.Nick),
+		NickMode:     c.stringID(entry.NickMode),
+		ByNick:       c.stringID(entry.ByNick),
+		Channel:      c.stringID(entry.Channel),
+		UserHost:     c.stringID(entry.UserHost),
+		NoticeTarget: c.stringID(entry.NoticeTarget),
+		Type:         uint8(entry.Type),
+	}
+
+	compact.Time = noTime
+	if !entry.Time.IsZero() {
+		compact.Time = entry.Time.Unix()
+	}
+
+	c.Entries = append(c.Entries, compact)
+}
+
+// stringID gives the index of a string in the string table, adding it if
+// necessary.
+func (c *CompactLog) stringID(s string) uint32 {
+	id, exists := c.ids[s]
+	if exists {
+		return id
+	}
+
+	id = uint32(len(c.strings))
+	s = copyString(s)
+	c.strings = append(c.strings, s)
+	c.ids[s] = id
+	return id
+}
+
+// Len gives the number of entries.
+func (c *CompactLog) Len() int {
+	return len(c.Entries)
+}
+
+// Entry expands an entry back into a LogEntry. Line is blank as we do not keep
+// it.
+func (c *CompactLog) Entry(i int) *LogEntry {
+	compact := c.Entries[i]
+
+	entry := &LogEntry{
+		Type:         EntryType(compact.Type),
+		Channel:      c.strings[compact.Channel],
+		Nick:         c.strings[compact.Nick],
+		NickMode:     c.strings[compact.NickMode],
+		ByNick:       c.strings[compact.ByNick],
+		UserHost:     c.strings[compact.UserHost],
+		Text:         compact.Text,
+		LineNumber:   int(compact.LineNumber),
+		Offset:       compact.Offset,
+		NoticeTarget: c.strings[compact.NoticeTarget],
+		SyncSeconds:  int(compact.SyncSeconds),
+	}
+
+	if compact.Time != noTime {
+		entry.Time = time.Unix(compact.Time, 0).In(c.location)
+	}
+
+	return entry
+}
+
+// copyString makes a copy of a string that does not share memory with it.
+func copyString(s string) string {
+	return string([]byte(s))
+}
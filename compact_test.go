@@ -0,0 +1,256 @@
+package irssi_log
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestCompactLog(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	entries := parseFile(t, "testdata/sample.log", location)
+
+	compactLog := NewCompactLog(location)
+	for _, entry := range entries {
+		compactLog.Add(entry)
+	}
+
+	if compactLog.Len() != len(entries) {
+		t.Fatalf("Compact log has %d entries, wanted %d", compactLog.Len(),
+			len(entries))
+	}
+
+	for i, entry := range entries {
+		wanted := *entry
+		wanted.Line = ""
+
+		found := compactLog.Entry(i)
+		if !entriesEqual(found, &wanted) {
+			t.Errorf("Entry %d is %+v, wanted %+v", i, found, wanted)
+		}
+	}
+
+	// An entry at the Unix epoch still has a time, one with no time has none,
+	// and line numbers past what 32 bits hold stay as they are.
+
+	edgeEntries := []*LogEntry{
+		&LogEntry{
+			Time:       time.Unix(0, 0).In(location),
+			Type:       Message,
+			Nick:       "bob",
+			Text:       "hi",
+			LineNumber: math.MaxInt32 + 1,
+		},
+		&LogEntry{Type: IgnoreThis, Text: "no time"},
+	}
+
+	for _, entry := range edgeEntries {
+		compactLog.Add(entry)
+
+		found := compactLog.Entry(compactLog.Len() - 1)
+		if !entriesEqual(found, entry) ||
+			found.Time.IsZero() != entry.Time.IsZero() {
+			t.Errorf("Entry is %+v, wanted %+v", found, entry)
+		}
+	}
+}
+
+// TestDropLines checks that without the raw line, no field of an entry shares
+// memory with it. If one did, the line could not be freed.
+func TestDropLines(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	parser := NewParser(location)
+	parser.DropLines = true
+
+	for _, line := range readLines(t, "testdata/sample.log") {
+		entry, err := parser.Parse(line)
+		if err != nil {
+			t.Fatalf("Unable to parse line: %s: %s", line, err.Error())
+		}
+
+		if entry.Line != "" {
+			t.Errorf("Entry for line %s has line %s", line, entry.Line)
+		}
+
+		lineStart := uintptr(unsafe.Pointer(unsafe.StringData(line)))
+		lineEnd := lineStart + uintptr(len(line))
+
+		value := reflect.ValueOf(entry).Elem()
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Field(i)
+			if field.Kind() != reflect.String || field.Len() == 0 {
+				continue
+			}
+
+			start := uintptr(unsafe.Pointer(unsafe.StringData(field.String())))
+			if start >= lineStart && start < lineEnd {
+				t.Errorf("%s of line %s shares memory with the line",
+					value.Type().Field(i).Name, line)
+			}
+		}
+	}
+}
+
+// TestEntryMemory measures how much memory entries take each way we can hold
+// them. We count the entries and the bytes of the strings they refer to, each
+// byte once however many strings share it. We don't count the maps that find
+// shared strings as their size depends on the runtime.
+func TestEntryMemory(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	lines := busyChannelLog(20000)
+
+	// Parse as usual.
+
+	plainBytes := entryBytes(parseLines(t, NewParser(location), lines))
+
+	// Share strings and drop the raw lines.
+
+	parser := NewParser(location)
+	parser.Interner = NewInterner()
+	parser.DropLines = true
+	internedBytes := entryBytes(parseLines(t, parser, lines))
+
+	// Compact entries.
+
+	compactLog := NewCompactLog(location)
+	for _, entry := range parseLines(t, NewParser(location), lines) {
+		compactLog.Add(entry)
+	}
+	compactBytes := compactLogBytes(compactLog)
+
+	t.Logf("Bytes per entry: Plain: %d Interned without lines: %d Compact: %d",
+		plainBytes/len(lines), internedBytes/len(lines), compactBytes/len(lines))
+
+	if internedBytes >= plainBytes {
+		t.Errorf("Interned entries take %d bytes, plain entries %d", internedBytes,
+			plainBytes)
+	}
+
+	if compactBytes >= internedBytes {
+		t.Errorf("Compact entries take %d bytes, interned entries %d",
+			compactBytes, internedBytes)
+	}
+}
+
+// busyChannelLog makes up a log where a few people talk a lot.
+func busyChannelLog(count int) []string {
+	lines := []string{"--- Log opened Sun Mar 27 15:04:05 2016"}
+
+	for i := 1; i < count; i++ {
+		nick := fmt.Sprintf("nick%d", i%25)
+		clock := fmt.Sprintf("%02d:%02d", (i/60)%24, i%60)
+
+		switch i % 10 {
+		case 0:
+			lines = append(lines, fmt.Sprintf(
+				"%s -!- %s [%s@host%d.example.com] has joined #channel", clock, nick,
+				nick, i%25))
+		case 1:
+			lines = append(lines, fmt.Sprintf(
+				"%s -!- %s [%s@host%d.example.com] has quit [Ping timeout]", clock,
+				nick, nick, i%25))
+		default:
+			lines = append(lines, fmt.Sprintf("%s < %s> %s", clock, nick,
+				strings.Repeat("word ", i%8+1)))
+		}
+	}
+
+	return lines
+}
+
+// parseLines parses each line.
+func parseLines(t *testing.T, parser *Parser, lines []string) []*LogEntry {
+	var entries []*LogEntry
+	for _, line := range lines {
+		// Copy the line, as it would be if we read it from a file.
+		entry, err := parser.Parse(string([]byte(line)))
+		if err != nil {
+			t.Fatalf("Unable to parse line: %s", err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// entryBytes gives the bytes entries take: the entries, pointers to them, and
+// the strings they refer to.
+func entryBytes(entries []*LogEntry) int {
+	var strs []string
+	for _, entry := range entries {
+		value := reflect.ValueOf(entry).Elem()
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Field(i); field.Kind() == reflect.String {
+				strs = append(strs, field.String())
+			}
+		}
+	}
+
+	size := int(unsafe.Sizeof(LogEntry{})) + int(unsafe.Sizeof(&LogEntry{}))
+	return len(entries)*size + stringBytes(strs)
+}
+
+// compactLogBytes gives the bytes a CompactLog takes: its entries, its string
+// table, and the strings they refer to.
+func compactLogBytes(c *CompactLog) int {
+	strs := append([]string{}, c.strings...)
+	for _, entry := range c.Entries {
+		strs = append(strs, entry.Text)
+	}
+
+	return len(c.Entries)*int(unsafe.Sizeof(CompactEntry{})) +
+		len(c.strings)*int(unsafe.Sizeof("")) + stringBytes(strs)
+}
+
+// stringBytes gives how many bytes of memory the strings refer to. Where
+// strings share memory, such as a field that is part of a line, we count it
+// once.
+func stringBytes(strs []string) int {
+	type span struct {
+		start uintptr
+		end   uintptr
+	}
+
+	var spans []span
+	for _, s := range strs {
+		if len(s) == 0 {
+			continue
+		}
+		start := uintptr(unsafe.Pointer(unsafe.StringData(s)))
+		spans = append(spans, span{start, start + uintptr(len(s))})
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	total := 0
+	var end uintptr
+	for _, sp := range spans {
+		if sp.start < end {
+			if sp.end <= end {
+				continue
+			}
+			sp.start = end
+		}
+		total += int(sp.end - sp.start)
+		end = sp.end
+	}
+	return total
+}
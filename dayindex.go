@@ -0,0 +1,340 @@
+/*
+ * A day index records where each day starts in a log. This lets us read the
+ * entries for a date range without parsing the log from the beginning.
+ */
+
+package irssi_log
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DayBoundary is a line in a log that sets the date: a LogOpen or DayChange
+// line.
+type DayBoundary struct {
+	// Time from the line. For a DayChange line this is midnight.
+	Time time.Time
+
+	// Type is LogOpen or DayChange.
+	Type EntryType
+
+	// Byte offset of the start of the line in the log
+	Offset int64
+
+	// Line number of the line in the log, starting at 1
+	LineNumber int
+}
+
+// DayIndex holds the day boundaries in a log.
+//
+// We assume dates in the log only move forward.
+type DayIndex struct {
+	Boundaries []DayBoundary
+
+	// Location is the name of the time zone location we parsed the log in.
+	// Days start at midnight there.
+	Location string
+
+	// Checkpoint marks how far into the log we indexed. We use it to extend the
+	// index when the log grows, and to tell if the log was replaced.
+	Checkpoint *Checkpoint
+}
+
+// errNoDayIndexCheckpoint means a DayIndex has no checkpoint. Indexes we build
+// always have one, so the index must have been made some other way.
+var errNoDayIndexCheckpoint = errors.New("The day index has no checkpoint")
+
+// DayIndexFilename gives the name of the sidecar file we keep a log's index
+// in.
+func DayIndexFilename(logFilename string) string {
+	return logFilename + ".days"
+}
+
+// BuildDayIndex indexes a log.
+func BuildDayIndex(file *os.File, location *time.Location) (*DayIndex, error) {
+	index := &DayIndex{Location: location.String()}
+
+	err := index.scan(file, NewParser(location))
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// Update brings the index up to date with the log. If the log grew we index
+// only the new lines. If the log was replaced we index it again from the
+// start.
+func (d *DayIndex) Update(file *os.File) error {
+	if d.Checkpoint == nil {
+		return errNoDayIndexCheckpoint
+	}
+
+	err := d.Checkpoint.Check(file)
+	if err != nil && err != ErrStaleCheckpoint {
+		return err
+	}
+
+	parser, perr := NewParserFromCheckpoint(d.Checkpoint)
+	if perr != nil {
+		return perr
+	}
+
+	if err == ErrStaleCheckpoint {
+		d.Boundaries = nil
+		parser = NewParser(parser.location)
+	}
+
+	return d.scan(file, parser)
+}
+
+// scan reads the log from the parser's position and records the boundaries it
+// finds.
+//
+// We only parse lines that look like they set the date. That is much quicker
+// than parsing every line. We stop at the last complete line, as a partial
+// one may still be being written.
+func (d *DayIndex) scan(file *os.File, parser *Parser) error {
+	fi, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Unable to stat file: %s", err.Error())
+	}
+
+	reader := bufio.NewReaderSize(
+		io.NewSectionReader(file, parser.offset, fi.Size()-parser.offset),
+		64*1024)
+
+	for {
+		line, size, err := readLine(reader)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if isDateLine(line) {
+			entry, err := parser.parse(line, size)
+			if err != nil {
+				return fmt.Errorf("Unable to parse line: %s", err.Error())
+			}
+
+			d.Boundaries = append(d.Boundaries, DayBoundary{
+				Time:       entry.Time,
+				Type:       entry.Type,
+				Offset:     entry.Offset,
+				LineNumber: entry.LineNumber,
+			})
+			continue
+		}
+
+		parser.lineNumber++
+		parser.offset += size
+	}
+
+	checkpoint, err := parser.Checkpoint(file)
+	if err != nil {
+		return err
+	}
+
+	d.Checkpoint = checkpoint
+
+	return nil
+}
+
+// readLine reads a complete line. It returns the line without its line
+// ending, and how many bytes the line took.
+//
+// It returns io.EOF if there is no complete line left.
+func readLine(reader *bufio.Reader) (string, int64, error) {
+	var line []byte
+	for {
+		buf, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			line = append(line, buf...)
+			continue
+		}
+
+		if err != nil {
+			if err == io.EOF {
+				return "", 0, io.EOF
+			}
+			return "", 0, fmt.Errorf("Read error: %s", err.Error())
+		}
+
+		line = append(line, buf...)
+		size := int64(len(line))
+		return strings.TrimSuffix(string(line[:len(line)-1]), "\r"), size, nil
+	}
+}
+
+// ParseRange parses the entries in the log that have times in [start, end).
+//
+// It returns ErrStaleCheckpoint if the index does not match the log. Lines
+// added since the index was last updated are still included.
+func (d *DayIndex) ParseRange(file *os.File, start, end time.Time) (
+	[]*LogEntry, error) {
+	if d.Checkpoint == nil {
+		return nil, errNoDayIndexCheckpoint
+	}
+
+	err := d.Checkpoint.Check(file)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := time.LoadLocation(d.Checkpoint.Location)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid location: %s", err.Error())
+	}
+
+	startDay := midnight(start, location)
+
+	// Entries before the first boundary on or after the start day have earlier
+	// dates. Entries from the first boundary whose day starts at or after the
+	// end are too late.
+
+	first := sort.Search(len(d.Boundaries), func(i int) bool {
+		return !midnight(d.Boundaries[i].Time, location).Before(startDay)
+	})
+
+	if first == len(d.Boundaries) {
+		return nil, nil
+	}
+
+	stopOffset := int64(-1)
+	for i := first; i < len(d.Boundaries); i++ {
+		if !midnight(d.Boundaries[i].Time, location).Before(end) {
+			stopOffset = d.Boundaries[i].Offset
+			break
+		}
+	}
+
+	boundary := d.Boundaries[first]
+
+	_, err = file.Seek(boundary.Offset, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to seek: %s", err.Error())
+	}
+
+	parser := NewParser(location)
+	parser.lineNumber = boundary.LineNumber - 1
+	parser.offset = boundary.Offset
+
+	reader := NewReader(file, parser)
+
+	var entries []*LogEntry
+	for {
+		if stopOffset != -1 && parser.offset >= stopOffset {
+			break
+		}
+
+		entry, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if entry.Time.Before(start) || !entry.Time.Before(end) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// midnight gives the start of the day t is in.
+func midnight(t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+}
+
+// LoadDayIndex gives an up to date index for a log. It uses the index in the
+// log's sidecar file if there is one and it is for the same location, and
+// saves the index back there.
+func LoadDayIndex(logFilename string, file *os.File,
+	location *time.Location) (*DayIndex, error) {
+	indexFilename := DayIndexFilename(logFilename)
+
+	_, err := os.Stat(indexFilename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Unable to stat file: %s: %s", indexFilename,
+			err.Error())
+	}
+
+	var index *DayIndex
+	if err == nil {
+		index, err = ReadDayIndex(indexFilename)
+	}
+
+	// The index is only a cache. If we can't decode it, such as if an older
+	// version wrote it, build it again. Likewise if it is for another location,
+	// as its days start at different times.
+	if err == nil && index.Location != location.String() {
+		err = fmt.Errorf("Index is for another location: %s", index.Location)
+	}
+
+	if err == nil {
+		err = index.Update(file)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		index, err = BuildDayIndex(file, location)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = WriteDayIndex(indexFilename, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// ReadDayIndex loads an index from a file.
+func ReadDayIndex(filename string) (*DayIndex, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read index: %s: %s", filename,
+			err.Error())
+	}
+
+	var index DayIndex
+	err = json.Unmarshal(buf, &index)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode index: %s: %s", filename,
+			err.Error())
+	}
+
+	if index.Checkpoint == nil {
+		return nil, fmt.Errorf("Index has no checkpoint: %s", filename)
+	}
+
+	return &index, nil
+}
+
+// WriteDayIndex saves an index to a file.
+func WriteDayIndex(filename string, index *DayIndex) error {
+	buf, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("Unable to encode index: %s", err.Error())
+	}
+
+	return WriteFileAtomically(filename, buf)
+}
@@ -0,0 +1,207 @@
+package irssi_log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDayIndexParseRange(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	filename := filepath.Join("testdata", "sample.log")
+
+	fullEntries := parseFile(t, filename, location)
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	index, err := BuildDayIndex(fh, location)
+	if err != nil {
+		t.Fatalf("Unable to build index: %s", err.Error())
+	}
+
+	if len(index.Boundaries) != 4 {
+		t.Fatalf("Found %d boundaries, wanted 4", len(index.Boundaries))
+	}
+
+	type TestCase struct {
+		Start time.Time
+		End   time.Time
+	}
+
+	cases := []TestCase{
+		// All of one day, which spans two sessions.
+		TestCase{
+			Start: time.Date(2016, 3, 28, 0, 0, 0, 0, location),
+			End:   time.Date(2016, 3, 29, 0, 0, 0, 0, location),
+		},
+		// Part of a day.
+		TestCase{
+			Start: time.Date(2016, 3, 27, 15, 10, 0, 0, location),
+			End:   time.Date(2016, 3, 27, 15, 12, 0, 0, location),
+		},
+		// Across days.
+		TestCase{
+			Start: time.Date(2016, 3, 27, 15, 17, 0, 0, location),
+			End:   time.Date(2016, 3, 29, 10, 1, 0, 0, location),
+		},
+		// Before the log.
+		TestCase{
+			Start: time.Date(2015, 1, 1, 0, 0, 0, 0, location),
+			End:   time.Date(2015, 1, 2, 0, 0, 0, 0, location),
+		},
+		// After the log.
+		TestCase{
+			Start: time.Date(2017, 1, 1, 0, 0, 0, 0, location),
+			End:   time.Date(2017, 1, 2, 0, 0, 0, 0, location),
+		},
+	}
+
+	for _, testCase := range cases {
+		var wanted []*LogEntry
+		for _, entry := range fullEntries {
+			if !entry.Time.Before(testCase.Start) && entry.Time.Before(testCase.End) {
+				wanted = append(wanted, entry)
+			}
+		}
+
+		entries, err := index.ParseRange(fh, testCase.Start, testCase.End)
+		if err != nil {
+			t.Errorf("Unable to parse range %s to %s: %s", testCase.Start,
+				testCase.End, err.Error())
+			continue
+		}
+
+		if len(entries) != len(wanted) {
+			t.Errorf("Range %s to %s gave %d entries, wanted %d", testCase.Start,
+				testCase.End, len(entries), len(wanted))
+			continue
+		}
+
+		for i := range entries {
+			if !entriesEqual(entries[i], wanted[i]) {
+				t.Errorf("Range %s to %s: entry %d is %+v, wanted %+v",
+					testCase.Start, testCase.End, i, entries[i], wanted[i])
+			}
+		}
+	}
+}
+
+func TestDayIndexUpdate(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "sample.log"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	filename := filepath.Join(dir, "test.log")
+
+	// Index a log that ends part way through a line, then let it grow. We should
+	// end up with the same index as if we indexed it all at once.
+
+	err = ioutil.WriteFile(filename, content[:len(content)/2], 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+
+	_, err = LoadDayIndex(filename, fh, location)
+	_ = fh.Close()
+	if err != nil {
+		t.Fatalf("Unable to load index: %s", err.Error())
+	}
+
+	appendToFile(t, filename, string(content[len(content)/2:]))
+
+	fh, err = os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	index, err := LoadDayIndex(filename, fh, location)
+	if err != nil {
+		t.Fatalf("Unable to load index: %s", err.Error())
+	}
+
+	fullIndex, err := BuildDayIndex(fh, location)
+	if err != nil {
+		t.Fatalf("Unable to build index: %s", err.Error())
+	}
+
+	if len(index.Boundaries) != len(fullIndex.Boundaries) {
+		t.Fatalf("Updated index has %d boundaries, wanted %d",
+			len(index.Boundaries), len(fullIndex.Boundaries))
+	}
+
+	for i, boundary := range index.Boundaries {
+		wanted := fullIndex.Boundaries[i]
+		if !boundary.Time.Equal(wanted.Time) || boundary.Type != wanted.Type ||
+			boundary.Offset != wanted.Offset ||
+			boundary.LineNumber != wanted.LineNumber {
+			t.Errorf("Boundary %d is %+v, wanted %+v", i, boundary, wanted)
+		}
+	}
+
+	if index.Checkpoint.Offset != fullIndex.Checkpoint.Offset ||
+		index.Checkpoint.LineNumber != fullIndex.Checkpoint.LineNumber {
+		t.Errorf("Updated index checkpoint is %+v, wanted %+v", index.Checkpoint,
+			fullIndex.Checkpoint)
+	}
+
+	// An index for another location is built again for ours.
+
+	utcIndex, err := LoadDayIndex(filename, fh, time.UTC)
+	if err != nil {
+		t.Fatalf("Unable to load index: %s", err.Error())
+	}
+
+	utcFullIndex, err := BuildDayIndex(fh, time.UTC)
+	if err != nil {
+		t.Fatalf("Unable to build index: %s", err.Error())
+	}
+
+	if utcIndex.Location != "UTC" ||
+		!reflect.DeepEqual(utcIndex.Boundaries, utcFullIndex.Boundaries) {
+		t.Errorf("Index loaded for UTC is %+v, wanted %+v", utcIndex,
+			utcFullIndex)
+	}
+
+	// An index without a checkpoint, such as from a file missing one, is an
+	// error rather than a crash.
+
+	err = (&DayIndex{}).Update(fh)
+	if err == nil {
+		t.Errorf("Updated an index without a checkpoint")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Encode entries as text and JSON.
+ *
+ * We encode entry types by name rather than number. The names are the wire
+ * format, so they must not change even if the EntryType constants are
+ * reordered.
+ */
+
+package irssi_log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// entryTypeNames holds the name of each EntryType.
+var entryTypeNames = map[EntryType]string{
+	LogOpen:        "LogOpen",
+	Join:           "Join",
+	ChannelSummary: "ChannelSummary",
+	Mode:           "Mode",
+	JoinSync:       "JoinSync",
+	Message:        "Message",
+	Quit:           "Quit",
+	NickChange:     "NickChange",
+	DayChange:      "DayChange",
+	LogClosed:      "LogClosed",
+	NowTalking:     "NowTalking",
+	Emote:          "Emote",
+	Topic:          "Topic",
+	Kick:           "Kick",
+	Part:           "Part",
+	YourNickChange: "YourNickChange",
+	ServerMode:     "ServerMode",
+	ChannelNotice:  "ChannelNotice",
+	IgnoreThis:     "IgnoreThis",
+	ServerNotice:   "ServerNotice",
+	BansNone:       "BansNone",
+}
+
+// entryTypesByName maps names back to EntryTypes.
+var entryTypesByName = map[string]EntryType{}
+
+func init() {
+	for entryType, name := range entryTypeNames {
+		entryTypesByName[name] = entryType
+	}
+}
+
+// String gives the EntryType's name.
+func (t EntryType) String() string {
+	name, exists := entryTypeNames[t]
+	if !exists {
+		return fmt.Sprintf("EntryType(%d)", int(t))
+	}
+	return name
+}
+
+// ParseEntryType gives the EntryType with the given name.
+func ParseEntryType(name string) (EntryType, error) {
+	entryType, exists := entryTypesByName[name]
+	if !exists {
+		return 0, fmt.Errorf("Unknown entry type: %s", name)
+	}
+	return entryType, nil
+}
+
+// MarshalText encodes the EntryType as its name.
+func (t EntryType) MarshalText() ([]byte, error) {
+	name, exists := entryTypeNames[t]
+	if !exists {
+		return nil, fmt.Errorf("Unknown entry type: %d", int(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes an EntryType from its name.
+func (t *EntryType) UnmarshalText(text []byte) error {
+	entryType, err := ParseEntryType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = entryType
+	return nil
+}
+
+// jsonEntry is how we encode a LogEntry as JSON.
+type jsonEntry struct {
+	Line         string    `json:"line,omitempty"`
+	Time         string    `json:"time,omitempty"`
+	Type         EntryType `json:"type"`
+	Channel      string    `json:"channel,omitempty"`
+	Nick         string    `json:"nick,omitempty"`
+	NickMode     string    `json:"nick_mode,omitempty"`
+	ByNick       string    `json:"by_nick,omitempty"`
+	UserHost     string    `json:"user_host,omitempty"`
+	Text         string    `json:"text,omitempty"`
+	NoticeTarget string    `json:"notice_target,omitempty"`
+	SyncSeconds  int       `json:"sync_seconds,omitempty"`
+	LineNumber   int       `json:"line_number,omitempty"`
+	Offset       int64     `json:"offset"`
+}
+
+// MarshalJSON encodes the entry as JSON. The time is in RFC 3339 format with
+// its zone offset. We leave out a zero time.
+//
+// RFC 3339 zone offsets are in whole minutes. Some aren't, such as local mean
+// time, which entries parsed without a date get as they're in the year 1. We
+// write those times in UTC so they come back at the same instant.
+//
+// We don't escape HTML characters such as <, which are common in log lines.
+// json.Marshal still escapes them, but an Encoder with SetEscapeHTML(false)
+// leaves them be.
+func (e LogEntry) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	var entryTime string
+	if !e.Time.IsZero() {
+		t := e.Time
+		if _, offset := t.Zone(); offset%60 != 0 {
+			t = t.UTC()
+		}
+		entryTime = t.Format(time.RFC3339)
+	}
+
+	err := encoder.Encode(jsonEntry{
+		Line:         e.Line,
+		Time:         entryTime,
+		Type:         e.Type,
+		Channel:      e.Channel,
+		Nick:         e.Nick,
+		NickMode:     e.NickMode,
+		ByNick:       e.ByNick,
+		UserHost:     e.UserHost,
+		Text:         e.Text,
+		NoticeTarget: e.NoticeTarget,
+		SyncSeconds:  e.SyncSeconds,
+		LineNumber:   e.LineNumber,
+		Offset:       e.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// UnmarshalJSON decodes an entry from JSON.
+//
+// The time comes back at the same instant and zone offset, but in a fixed
+// zone rather than the location it had. Use Time.In() to get it back in a
+// location.
+func (e *LogEntry) UnmarshalJSON(buf []byte) error {
+	var decoded jsonEntry
+	err := json.Unmarshal(buf, &decoded)
+	if err != nil {
+		return err
+	}
+
+	var entryTime time.Time
+	if decoded.Time != "" {
+		entryTime, err = time.Parse(time.RFC3339, decoded.Time)
+		if err != nil {
+			return fmt.Errorf("Invalid time: %s: %s", decoded.Time, err.Error())
+		}
+	}
+
+	*e = LogEntry{
+		Line:         decoded.Line,
+		Time:         entryTime,
+		Type:         decoded.Type,
+		Channel:      decoded.Channel,
+		Nick:         decoded.Nick,
+		NickMode:     decoded.NickMode,
+		ByNick:       decoded.ByNick,
+		UserHost:     decoded.UserHost,
+		Text:         decoded.Text,
+		NoticeTarget: decoded.NoticeTarget,
+		SyncSeconds:  decoded.SyncSeconds,
+		LineNumber:   decoded.LineNumber,
+		Offset:       decoded.Offset,
+	}
+
+	return nil
+}
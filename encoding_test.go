@@ -0,0 +1,119 @@
+package irssi_log
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEntryTypeText(t *testing.T) {
+	for entryType := LogOpen; entryType <= BansNone; entryType++ {
+		text, err := entryType.MarshalText()
+		if err != nil {
+			t.Errorf("Unable to marshal %d: %s", entryType, err)
+			continue
+		}
+
+		if string(text) != entryType.String() {
+			t.Errorf("Marshalled %d to %s, but its name is %s", entryType, text,
+				entryType.String())
+		}
+
+		var decoded EntryType
+		err = decoded.UnmarshalText(text)
+		if err != nil {
+			t.Errorf("Unable to unmarshal %s: %s", text, err)
+			continue
+		}
+
+		if decoded != entryType {
+			t.Errorf("Unmarshalled %s to %d, wanted %d", text, decoded, entryType)
+		}
+	}
+
+	if Message.String() != "Message" {
+		t.Errorf("Message is named %s", Message.String())
+	}
+
+	unknown := BansNone + 1
+	if unknown.String() != "EntryType(21)" {
+		t.Errorf("Unknown type is named %s", unknown.String())
+	}
+
+	_, err := unknown.MarshalText()
+	if err == nil {
+		t.Errorf("Marshalled an unknown type")
+	}
+
+	var decoded EntryType
+	err = decoded.UnmarshalText([]byte("Nonsense"))
+	if err == nil {
+		t.Errorf("Unmarshalled an unknown name")
+	}
+}
+
+func TestLogEntryJSON(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	entries := parseFile(t, "testdata/sample.log", location)
+
+	for _, entry := range entries {
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			t.Errorf("Unable to marshal entry: %s: %s", entry.Line, err)
+			continue
+		}
+
+		var decoded LogEntry
+		err = json.Unmarshal(buf, &decoded)
+		if err != nil {
+			t.Errorf("Unable to unmarshal entry: %s: %s", buf, err)
+			continue
+		}
+
+		if !entriesEqual(&decoded, entry) {
+			t.Errorf("Entry came back as %+v, wanted %+v", decoded, *entry)
+		}
+	}
+
+	buf, err := json.Marshal(entries[8])
+	if err != nil {
+		t.Fatalf("Unable to marshal entry: %s", err)
+	}
+
+	wanted := `{"line":"15:06  * bob waves","time":"2016-03-27T15:06:00-07:00",` +
+		`"type":"Emote","nick":"bob","text":"waves","line_number":9,"offset":391}`
+	if string(buf) != wanted {
+		t.Errorf("Marshalled %s, wanted %s", buf, wanted)
+	}
+
+	// Without a date, an entry's time is in the year 1. Its zone offset then
+	// has seconds. As well, some entries have no time at all.
+
+	undated, err := ParseLine("15:04 <@nick> hi", location, time.Time{})
+	if err != nil {
+		t.Fatalf("Unable to parse line: %s", err.Error())
+	}
+
+	for _, entry := range []*LogEntry{undated, &LogEntry{Type: IgnoreThis}} {
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			t.Errorf("Unable to marshal entry: %+v: %s", *entry, err)
+			continue
+		}
+
+		var decoded LogEntry
+		err = json.Unmarshal(buf, &decoded)
+		if err != nil {
+			t.Errorf("Unable to unmarshal entry: %s: %s", buf, err)
+			continue
+		}
+
+		if !entriesEqual(&decoded, entry) {
+			t.Errorf("Entry came back as %+v, wanted %+v", decoded, *entry)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ * Evaluate argot's Markov model on messages it wasn't built from.
+ *
+ * We split the messages of Irssi logs by time. We build the model from the
+ * earlier ones and test it on the later ones, the held out messages. For each
+ * k we report:
+ *
+ *   - Perplexity per token of the held out messages. Lower is better.
+ *   - The out of vocabulary rate: the share of held out words the model never
+ *     saw.
+ *   - The verbatim copy rate: the share of messages we generate that are word
+ *     for word a message the model was built from.
+ *   - The average longest run of words a generated message copies from the
+ *     messages the model was built from, as a share of its length.
+ *
+ * Low perplexity with high copying means the model is memorizing rather than
+ * generating.
+ *
+ * Compare tokenizations by running with different -tokenize options. Perplexity
+ * is only comparable between runs that split text into the same words, so
+ * compare it between k values, and compare copying between tokenizations.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/markov"
+	"github.com/horgh/irssi_log/suffixarray"
+	"github.com/horgh/irssi_log/tokenizer"
+)
+
+func main() {
+	locationString := flag.String("location", "America/Vancouver", "Time zone location.")
+	heldOut := flag.Float64("held-out", 0.1, "Share of messages to hold out for testing. We hold out the latest.")
+	ks := flag.String("k", "1,2,3", "Values of k to evaluate, separated by commas.")
+	samples := flag.Int("samples", 100, "Number of messages to generate for each k to measure copying.")
+	seed := flag.Int64("seed", 1, "Seed for the random number generator.")
+	minLength := flag.Int("min-length", 1, "Generate messages with at least this many words.")
+	maxLength := flag.Int("max-length", 30, "Generate messages with at most this many words.")
+	tokenize := flag.String("tokenize", "", "How to split messages into words. Comma separated tokenizer options: lowercase, punctuation (split punctuation off words), urls=drop, urls=keep, urls=replace, nicks (replace nicks with a placeholder), numbers (replace numbers with a placeholder), strip-address (leave out the nick a message addresses).")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <log file> [log file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Print("You must specify at least one log file.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *heldOut <= 0 || *heldOut >= 1 {
+		log.Print("You must specify a held out share > 0 and < 1.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	kValues, err := parseKs(*ks)
+	if err != nil {
+		log.Print(err.Error())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *samples < 0 {
+		log.Print("You must specify samples >= 0.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *minLength <= 0 || *maxLength < *minLength {
+		log.Print("You must specify a minimum length > 0 and a maximum length >= it.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	tokenizerOptions, err := tokenizer.ParseOptions(*tokenize)
+	if err != nil {
+		log.Print(err.Error())
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	location, err := time.LoadLocation(*locationString)
+	if err != nil {
+		log.Printf("Invalid location: %s", err.Error())
+		os.Exit(1)
+	}
+
+	var messages []*irssi_log.LogEntry
+	for _, logFile := range flag.Args() {
+		entries, err := parseFile(logFile, location)
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+
+		messages = append(messages, irssi_log.FilterEntries(entries,
+			irssi_log.HasType(irssi_log.Message))...)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Time.Before(messages[j].Time)
+	})
+
+	split := len(messages) - int(float64(len(messages))**heldOut)
+	if split == 0 || split == len(messages) {
+		log.Printf("Too few messages to split: %d", len(messages))
+		os.Exit(1)
+	}
+	train, test := messages[:split], messages[split:]
+
+	fmt.Printf("Training on %d messages from %s to %s\n", len(train),
+		train[0].Time.Format("2006-01-02"),
+		train[len(train)-1].Time.Format("2006-01-02"))
+	fmt.Printf("Testing on %d messages from %s to %s\n", len(test),
+		test[0].Time.Format("2006-01-02"),
+		test[len(test)-1].Time.Format("2006-01-02"))
+
+	// The tokenizer only knows the nicks of the messages we train on. Nicks
+	// from the held out messages would otherwise change how we split the
+	// training messages.
+	tok := tokenizer.New(tokenizerOptions)
+	for _, entry := range train {
+		tok.AddNick(entry.Nick)
+	}
+
+	maxK := kValues[len(kValues)-1]
+
+	builder := markov.NewBuilderWithTokenizer(maxK, tok)
+	for _, entry := range train {
+		builder.AddMessage(entry.Nick, entry.Text, entry.Time)
+	}
+	model := builder.Model()
+
+	var testMessages [][]string
+	for _, entry := range test {
+		words := tok.Tokens(entry.Text)
+		if len(words) > 0 {
+			testMessages = append(testMessages, words)
+		}
+	}
+
+	copies := newCopies(train, tok)
+
+	fmt.Printf("%3s %12s %8s %10s %14s\n", "k", "perplexity", "OOV", "verbatim",
+		"longest copy")
+
+	for _, k := range kValues {
+		evaluation, err := model.Evaluate(testMessages, k)
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+
+		verbatim, longest, err := copies.measure(model, k, *samples, *seed,
+			*minLength, *maxLength)
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("%3d %12.2f %7.2f%% %9.2f%% %13.2f%%\n", k,
+			evaluation.Perplexity(), 100*evaluation.OOVRate(), 100*verbatim,
+			100*longest)
+	}
+}
+
+// parseKs parses the -k flag. It gives the values sorted.
+func parseKs(ks string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(ks, ",") {
+		k, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || k <= 0 {
+			return nil, fmt.Errorf("Invalid k: %s", field)
+		}
+		values = append(values, k)
+	}
+
+	sort.Ints(values)
+	return values, nil
+}
+
+// parseFile parses a log.
+func parseFile(logFile string, location *time.Location) (
+	[]*irssi_log.LogEntry, error) {
+	fh, err := os.Open(logFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open file: %s: %s", logFile, err.Error())
+	}
+	defer fh.Close()
+
+	entries, err := irssi_log.ParseLog(fh, 0, location)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse log: %s: %s", logFile, err.Error())
+	}
+
+	return entries, nil
+}
+
+// copies finds what generated messages copy from the messages we built the
+// model from.
+type copies struct {
+	// messages has the words of each message joined by spaces.
+	messages map[string]struct{}
+
+	// corpus has the same, with a space either side so we can search for runs
+	// of whole words.
+	corpus *suffixarray.Corpus
+}
+
+func newCopies(entries []*irssi_log.LogEntry,
+	tok *tokenizer.Tokenizer) *copies {
+	c := &copies{messages: map[string]struct{}{}}
+
+	var joined []*irssi_log.LogEntry
+	for _, entry := range entries {
+		text := strings.Join(tok.Tokens(entry.Text), " ")
+		c.messages[text] = struct{}{}
+		joined = append(joined, &irssi_log.LogEntry{Text: " " + text + " "})
+	}
+
+	c.corpus = suffixarray.NewCorpus(joined)
+
+	return c
+}
+
+// measure generates messages and gives the share that are copies of a message,
+// and the average of the longest run of words each copies as a share of its
+// length.
+func (c *copies) measure(model *markov.Model, k, samples int, seed int64,
+	minLength, maxLength int) (float64, float64, error) {
+	if samples == 0 {
+		return 0, 0, nil
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	options := markov.Options{K: k, MinLength: minLength, MaxLength: maxLength}
+
+	verbatim := 0
+	longest := 0.0
+
+	for i := 0; i < samples; i++ {
+		words, err := model.Generate(rng, options)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		if _, exists := c.messages[strings.Join(words, " ")]; exists {
+			verbatim++
+		}
+
+		longest += float64(c.longestRun(words)) / float64(len(words))
+	}
+
+	return float64(verbatim) / float64(samples), longest / float64(samples), nil
+}
+
+// longestRun gives the length of the longest run of words that occurs in the
+// corpus.
+func (c *copies) longestRun(words []string) int {
+	best := 0
+	start := 0
+	for end := range words {
+		for start <= end &&
+			c.corpus.Count(" "+strings.Join(words[start:end+1], " ")+" ") == 0 {
+			start++
+		}
+
+		if end-start+1 > best {
+			best = end - start + 1
+		}
+	}
+	return best
+}
@@ -0,0 +1,376 @@
+/*
+ * Export the entries in an Irssi log as newline delimited JSON or CSV.
+ *
+ * This is to make it easy to load logs into other tools.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/horgh/irssi_log"
+)
+
+// column is a field of an entry we can export.
+type column struct {
+	name string
+
+	// value gives the field's value. Times are in the given location.
+	value func(entry *irssi_log.LogEntry, location *time.Location) interface{}
+}
+
+// columns holds every column we know how to export. The names match the keys
+// of a LogEntry encoded as JSON.
+var columns = []column{
+	{"time", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.Time.In(l).Format(time.RFC3339)
+	}},
+	{"type", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.Type.String()
+	}},
+	{"channel", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.Channel
+	}},
+	{"nick", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.Nick
+	}},
+	{"nick_mode", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.NickMode
+	}},
+	{"by_nick", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.ByNick
+	}},
+	{"user_host", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.UserHost
+	}},
+	{"text", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.Text
+	}},
+	{"notice_target", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.NoticeTarget
+	}},
+	{"sync_seconds", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.SyncSeconds
+	}},
+	{"line_number", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.LineNumber
+	}},
+	{"offset", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.Offset
+	}},
+	{"line", func(e *irssi_log.LogEntry, l *time.Location) interface{} {
+		return e.Line
+	}},
+}
+
+const defaultColumns = "time,type,channel,nick,user_host,text,line_number"
+
+// entryWriter writes entries in one format.
+type entryWriter interface {
+	Write(entry *irssi_log.LogEntry) error
+	Flush() error
+}
+
+func main() {
+	logFile := flag.String("log-file", "", "Path to a log file to read.")
+	outFile := flag.String("out-file", "", "Path to a file to write. Optional. We write to stdout if not given.")
+	format := flag.String("format", "ndjson", "Output format: ndjson or csv.")
+	columnsString := flag.String("columns", defaultColumns, fmt.Sprintf("Comma separated columns to export. Available: %s.", columnNames()))
+	locationString := flag.String("location", "America/Vancouver", "Time zone location of the log.")
+	outLocationString := flag.String("out-location", "", "Time zone location to write times in. Optional. Defaults to -location.")
+	lineLimit := flag.Int("line-limit", 0, "Limit number of lines to read. 0 for entire log.")
+
+	flag.Parse()
+
+	if len(*logFile) == 0 {
+		log.Print("You must specify a log file.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *format != "ndjson" && *format != "csv" {
+		log.Print("You must specify a format of ndjson or csv.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *lineLimit < 0 {
+		log.Print("You must specify a line limit >= 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	selected, err := parseColumns(*columnsString)
+	if err != nil {
+		log.Print(err.Error())
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	location, err := time.LoadLocation(*locationString)
+	if err != nil {
+		log.Printf("Invalid location: %s", err.Error())
+		os.Exit(1)
+	}
+
+	outLocation := location
+	if len(*outLocationString) > 0 {
+		outLocation, err = time.LoadLocation(*outLocationString)
+		if err != nil {
+			log.Printf("Invalid output location: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	fh, err := os.Open(*logFile)
+	if err != nil {
+		log.Printf("Unable to open file: %s: %s", *logFile, err.Error())
+		os.Exit(1)
+	}
+	defer fh.Close()
+
+	out := os.Stdout
+	if len(*outFile) > 0 {
+		out, err = os.Create(*outFile)
+		if err != nil {
+			log.Printf("Unable to open output file: %s: %s", *outFile, err.Error())
+			os.Exit(1)
+		}
+		defer out.Close()
+	}
+
+	var writer entryWriter
+	if *format == "csv" {
+		writer, err = newCSVWriter(out, selected, outLocation)
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+	} else {
+		writer = newJSONWriter(out, selected, outLocation)
+	}
+
+	err = export(irssi_log.NewReader(fh, irssi_log.NewParser(location)), writer,
+		*lineLimit)
+	if err != nil {
+		log.Print(err.Error())
+		os.Exit(1)
+	}
+}
+
+// columnNames gives the names of all columns, comma separated.
+func columnNames() string {
+	var names []string
+	for _, c := range columns {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ",")
+}
+
+// parseColumns looks up the columns in a comma separated list.
+func parseColumns(s string) ([]column, error) {
+	var selected []column
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+
+		found := false
+		for _, c := range columns {
+			if c.name == name {
+				selected = append(selected, c)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("Unknown column: %s", name)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("You must specify at least one column.")
+	}
+
+	return selected, nil
+}
+
+// export reads entries and writes them out as it goes.
+func export(reader *irssi_log.Reader, writer entryWriter, lineLimit int) error {
+	count := 0
+	for lineLimit == 0 || count < lineLimit {
+		entry, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		err = writer.Write(entry)
+		if err != nil {
+			return err
+		}
+
+		count++
+	}
+
+	return writer.Flush()
+}
+
+// jsonWriter writes each entry as a JSON object on its own line. Keys are in
+// the order of the columns.
+type jsonWriter struct {
+	writer   *bufio.Writer
+	columns  []column
+	location *time.Location
+	buf      *bytes.Buffer
+	encoder  *json.Encoder
+}
+
+func newJSONWriter(w io.Writer, columns []column,
+	location *time.Location) *jsonWriter {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	return &jsonWriter{
+		writer:   bufio.NewWriter(w),
+		columns:  columns,
+		location: location,
+		buf:      buf,
+		encoder:  encoder,
+	}
+}
+
+func (j *jsonWriter) Write(entry *irssi_log.LogEntry) error {
+	j.buf.Reset()
+	j.buf.WriteByte('{')
+
+	for i, c := range j.columns {
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+
+		err := j.encode(c.name)
+		if err != nil {
+			return err
+		}
+
+		j.buf.WriteByte(':')
+
+		err = j.encode(c.value(entry, j.location))
+		if err != nil {
+			return err
+		}
+	}
+
+	j.buf.WriteString("}\n")
+
+	_, err := j.writer.Write(j.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+	return nil
+}
+
+// encode adds a JSON value to the buffer.
+func (j *jsonWriter) encode(v interface{}) error {
+	err := j.encoder.Encode(v)
+	if err != nil {
+		return fmt.Errorf("Unable to encode: %s", err.Error())
+	}
+
+	// The encoder ends each value with a newline.
+	j.buf.Truncate(j.buf.Len() - 1)
+	return nil
+}
+
+func (j *jsonWriter) Flush() error {
+	err := j.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Unable to flush: %s", err.Error())
+	}
+	return nil
+}
+
+// csvWriter writes entries as CSV records, after a header record naming the
+// columns.
+type csvWriter struct {
+	writer   *csv.Writer
+	columns  []column
+	location *time.Location
+	record   []string
+}
+
+func newCSVWriter(w io.Writer, columns []column,
+	location *time.Location) (*csvWriter, error) {
+	c := &csvWriter{
+		writer:   csv.NewWriter(w),
+		columns:  columns,
+		location: location,
+		record:   make([]string, len(columns)),
+	}
+
+	for i, col := range columns {
+		c.record[i] = col.name
+	}
+
+	err := c.writer.Write(c.record)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to write: %s", err.Error())
+	}
+
+	return c, nil
+}
+
+func (c *csvWriter) Write(entry *irssi_log.LogEntry) error {
+	for i, col := range c.columns {
+		switch v := col.value(entry, c.location).(type) {
+		case string:
+			c.record[i] = v
+		case int:
+			c.record[i] = strconv.Itoa(v)
+		case int64:
+			c.record[i] = strconv.FormatInt(v, 10)
+		default:
+			c.record[i] = fmt.Sprint(v)
+		}
+	}
+
+	err := c.writer.Write(c.record)
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+	return nil
+}
+
+func (c *csvWriter) Flush() error {
+	c.writer.Flush()
+	err := c.writer.Error()
+	if err != nil {
+		return fmt.Errorf("Unable to flush: %s", err.Error())
+	}
+	return nil
+}
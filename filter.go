@@ -0,0 +1,227 @@
+/*
+ * Filters pick out the entries we want.
+ *
+ * Filters combine with And, Or, and Not. They work on a slice of entries from
+ * ParseLog, or on entries as we read them.
+ */
+
+package irssi_log
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Filter decides whether we want an entry.
+type Filter interface {
+	Match(entry *LogEntry) bool
+}
+
+// FilterFunc lets a function be a Filter.
+type FilterFunc func(entry *LogEntry) bool
+
+// Match calls the function.
+func (f FilterFunc) Match(entry *LogEntry) bool {
+	return f(entry)
+}
+
+// HasType matches entries of any of the given types.
+func HasType(types ...EntryType) Filter {
+	wanted := map[EntryType]struct{}{}
+	for _, t := range types {
+		wanted[t] = struct{}{}
+	}
+
+	return FilterFunc(func(entry *LogEntry) bool {
+		_, exists := wanted[entry.Type]
+		return exists
+	})
+}
+
+// HasNick matches entries with any of the given nicks. Nicks are compared the
+// way IRC servers do, ignoring case.
+func HasNick(nicks ...string) Filter {
+	wanted := map[string]struct{}{}
+	for _, nick := range nicks {
+		wanted[IRCLower(nick)] = struct{}{}
+	}
+
+	return FilterFunc(func(entry *LogEntry) bool {
+		if entry.Nick == "" {
+			return false
+		}
+		_, exists := wanted[IRCLower(entry.Nick)]
+		return exists
+	})
+}
+
+// InChannel matches entries in any of the given channels. Like nicks, channel
+// names ignore case.
+func InChannel(channels ...string) Filter {
+	wanted := map[string]struct{}{}
+	for _, channel := range channels {
+		wanted[IRCLower(channel)] = struct{}{}
+	}
+
+	return FilterFunc(func(entry *LogEntry) bool {
+		if entry.Channel == "" {
+			return false
+		}
+		_, exists := wanted[IRCLower(entry.Channel)]
+		return exists
+	})
+}
+
+// UserHostMatches matches entries with a user@host matching a glob. In the
+// glob, * matches any run of characters and ? matches any one character. Case
+// is ignored, as it is in IRC hostmasks.
+func UserHostMatches(glob string) Filter {
+	var pattern strings.Builder
+	pattern.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	pattern.WriteString("$")
+
+	re := regexp.MustCompile(pattern.String())
+
+	return FilterFunc(func(entry *LogEntry) bool {
+		if entry.UserHost == "" {
+			return false
+		}
+		return re.MatchString(entry.UserHost)
+	})
+}
+
+// InTimeRange matches entries with times in [start, end). A zero start or
+// end leaves that side of the range open.
+func InTimeRange(start, end time.Time) Filter {
+	return FilterFunc(func(entry *LogEntry) bool {
+		if !start.IsZero() && entry.Time.Before(start) {
+			return false
+		}
+		if !end.IsZero() && !entry.Time.Before(end) {
+			return false
+		}
+		return true
+	})
+}
+
+// TextMatches matches entries with text matching a regexp.
+func TextMatches(re *regexp.Regexp) Filter {
+	return FilterFunc(func(entry *LogEntry) bool {
+		return re.MatchString(entry.Text)
+	})
+}
+
+// TextContains matches entries with text containing a substring.
+func TextContains(s string) Filter {
+	return FilterFunc(func(entry *LogEntry) bool {
+		return strings.Contains(entry.Text, s)
+	})
+}
+
+// And matches entries all of the filters match. With no filters it matches
+// everything.
+func And(filters ...Filter) Filter {
+	return FilterFunc(func(entry *LogEntry) bool {
+		for _, filter := range filters {
+			if !filter.Match(entry) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Or matches entries any of the filters match. With no filters it matches
+// nothing.
+func Or(filters ...Filter) Filter {
+	return FilterFunc(func(entry *LogEntry) bool {
+		for _, filter := range filters {
+			if filter.Match(entry) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Not matches entries the filter does not.
+func Not(filter Filter) Filter {
+	return FilterFunc(func(entry *LogEntry) bool {
+		return !filter.Match(entry)
+	})
+}
+
+// FilterEntries gives the entries the filter matches.
+func FilterEntries(entries []*LogEntry, filter Filter) []*LogEntry {
+	var matched []*LogEntry
+	for _, entry := range entries {
+		if filter.Match(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// EntryReader is anything that gives entries one at a time, such as a Reader
+// or a Follower.
+type EntryReader interface {
+	Next() (*LogEntry, error)
+}
+
+// FilterReader gives only the entries from an EntryReader that a filter
+// matches.
+type FilterReader struct {
+	reader EntryReader
+	filter Filter
+}
+
+// NewFilterReader creates a FilterReader.
+func NewFilterReader(reader EntryReader, filter Filter) *FilterReader {
+	return &FilterReader{reader: reader, filter: filter}
+}
+
+// Next returns the next entry the filter matches. It returns io.EOF when
+// there are no more.
+func (r *FilterReader) Next() (*LogEntry, error) {
+	for {
+		entry, err := r.reader.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if r.filter.Match(entry) {
+			return entry, nil
+		}
+	}
+}
+
+// IRCLower lowercases a nick or channel the way IRC servers do when comparing
+// them. Under RFC 1459 rules, []\~ are the uppercase forms of {}|^.
+func IRCLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~':
+			return '^'
+		}
+		return r
+	}, s)
+}
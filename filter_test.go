@@ -0,0 +1,153 @@
+package irssi_log
+
+import (
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFilters(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	entries := parseFile(t, "testdata/sample.log", location)
+
+	type TestCase struct {
+		Name   string
+		Filter Filter
+		Lines  []int
+	}
+
+	cases := []TestCase{
+		{
+			Name:   "type",
+			Filter: HasType(Emote, Kick),
+			Lines:  []int{9, 17, 33},
+		},
+		{
+			Name:   "nick ignores case",
+			Filter: And(HasNick("CAROL"), HasType(Message)),
+			Lines:  []int{8, 37},
+		},
+		{
+			Name:   "nick uses IRC case",
+			Filter: HasNick("ALICE^"),
+			Lines:  nil,
+		},
+		{
+			Name:   "channel",
+			Filter: And(InChannel("#Channel"), HasType(Topic, Mode)),
+			Lines:  []int{10, 13},
+		},
+		{
+			Name:   "user@host glob",
+			Filter: UserHostMatches("*@HOST.example.???"),
+			Lines:  []int{11, 18},
+		},
+		{
+			Name: "time range",
+			Filter: InTimeRange(time.Date(2016, 3, 28, 0, 0, 0, 0, location),
+				time.Date(2016, 3, 28, 0, 3, 0, 0, location)),
+			Lines: []int{22, 23, 24, 25},
+		},
+		{
+			Name:   "text regexp",
+			Filter: TextMatches(regexp.MustCompile(`deploy(ed)?\b`)),
+			Lines:  []int{7, 8, 21, 27, 28, 35, 36},
+		},
+		{
+			Name:   "text substring",
+			Filter: TextContains("deployed("),
+			Lines:  []int{36},
+		},
+		{
+			Name: "or and not",
+			Filter: And(
+				HasType(Message),
+				Or(HasNick("alice"), HasNick("alice_")),
+				Not(TextContains("deploy")),
+			),
+			Lines: nil,
+		},
+		{
+			Name:   "empty and",
+			Filter: And(HasType(LogOpen), And()),
+			Lines:  []int{1, 30},
+		},
+		{
+			Name:   "empty or",
+			Filter: Or(),
+			Lines:  nil,
+		},
+	}
+
+	for _, testCase := range cases {
+		matched := FilterEntries(entries, testCase.Filter)
+
+		var lines []int
+		for _, entry := range matched {
+			lines = append(lines, entry.LineNumber)
+		}
+
+		if !intsEqual(lines, testCase.Lines) {
+			t.Errorf("%s: matched lines %v, wanted %v", testCase.Name, lines,
+				testCase.Lines)
+		}
+	}
+}
+
+func TestFilterReader(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	entries := parseFile(t, "testdata/sample.log", location)
+	filter := Or(HasType(Join), HasNick("dave_"))
+	wanted := FilterEntries(entries, filter)
+
+	fh, err := os.Open("testdata/sample.log")
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	reader := NewFilterReader(NewReader(fh, NewParser(location)), filter)
+	found, err := readEntries(reader, 0)
+	if err != nil {
+		t.Fatalf("Unable to read entries: %s", err.Error())
+	}
+
+	if len(found) != len(wanted) || len(found) != 4 {
+		t.Fatalf("Read %d entries, wanted %d", len(found), len(wanted))
+	}
+
+	for i := range found {
+		if !entriesEqual(found[i], wanted[i]) {
+			t.Errorf("Entry %d is %+v, wanted %+v", i, found[i], wanted[i])
+		}
+	}
+}
+
+func TestIRCLower(t *testing.T) {
+	if IRCLower("Alice[Away]\\~") != "alice{away}|^" {
+		t.Errorf("Lowercased to %s", IRCLower("Alice[Away]\\~"))
+	}
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,484 @@
+/*
+ * Follow a log as Irssi writes to it, similar to tail -f.
+ */
+
+package irssi_log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FromEnd tells NewFollower to start at the end of the log.
+const FromEnd int64 = -1
+
+// DefaultPollInterval is how long a Follower waits before checking the log
+// for new lines.
+const DefaultPollInterval = time.Second
+
+// ErrFollowerClosed is returned by Next once the Follower is closed.
+var ErrFollowerClosed = errors.New("Follower closed")
+
+// Follower reads entries from a log as they are appended to it.
+//
+// It copes with the log being truncated, and with the log being rotated or
+// recreated at the same path.
+type Follower struct {
+	// PollInterval is how long to wait between checks for new lines.
+	PollInterval time.Duration
+
+	// Idle, if set, is called whenever Next has returned every complete line in
+	// the log and is about to wait for more. It may call Checkpoint and Close.
+	Idle func()
+
+	filename string
+	parser   *Parser
+
+	// mutex protects the file from being closed while Next uses it.
+	mutex sync.Mutex
+	file  *os.File
+
+	// readOffset is how far into the file we have read.
+	readOffset int64
+
+	// partial holds an incomplete line from the end of the file. We hold on to
+	// it until the rest of the line gets written.
+	partial []byte
+
+	// lines holds complete lines we have read but not yet parsed.
+	lines []pendingLine
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// pendingLine is a line read from the log.
+type pendingLine struct {
+	line string
+
+	// size is how many bytes the line takes, including its line ending.
+	size int64
+}
+
+// NewFollower opens a log for following.
+//
+// offset says where to start. It must be the start of a line, or FromEnd to
+// begin after the last complete line in the log. Either way we look back
+// through the log for the most recent LogOpen or DayChange line so that
+// entries get the right date.
+func NewFollower(filename string, location *time.Location, offset int64) (
+	*Follower, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open file: %s: %s", filename,
+			err.Error())
+	}
+
+	f := &Follower{
+		PollInterval: DefaultPollInterval,
+		filename:     filename,
+		parser:       NewParser(location),
+		file:         file,
+		done:         make(chan struct{}),
+	}
+
+	err = f.start(offset)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// NewFollowerFromCheckpoint opens a log for following, starting from a
+// checkpoint.
+//
+// It returns ErrStaleCheckpoint if the checkpoint does not match the log.
+func NewFollowerFromCheckpoint(filename string, checkpoint *Checkpoint) (
+	*Follower, error) {
+	parser, err := NewParserFromCheckpoint(checkpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open file: %s: %s", filename,
+			err.Error())
+	}
+
+	err = checkpoint.Check(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	_, err = file.Seek(checkpoint.Offset, io.SeekStart)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("Unable to seek: %s", err.Error())
+	}
+
+	return &Follower{
+		PollInterval: DefaultPollInterval,
+		filename:     filename,
+		parser:       parser,
+		file:         file,
+		readOffset:   checkpoint.Offset,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// start positions the Follower at offset and sets up the date context.
+func (f *Follower) start(offset int64) error {
+	fi, err := f.file.Stat()
+	if err != nil {
+		return fmt.Errorf("Unable to stat file: %s: %s", f.filename, err.Error())
+	}
+
+	if offset == FromEnd {
+		offset, err = lastLineEnd(f.file, fi.Size())
+		if err != nil {
+			return err
+		}
+	}
+
+	if offset < 0 || offset > fi.Size() {
+		return fmt.Errorf("Invalid offset: %d", offset)
+	}
+
+	dateLine, err := findDateLine(f.file, offset)
+	if err != nil {
+		return err
+	}
+
+	if dateLine != "" {
+		_, err := f.parser.Parse(dateLine)
+		if err != nil {
+			return fmt.Errorf("Unable to parse line: %s", err.Error())
+		}
+	}
+
+	// Line numbers count from the start of the log.
+	lineCount, err := countLines(f.file, offset)
+	if err != nil {
+		return err
+	}
+
+	f.parser.lineNumber = lineCount
+	f.parser.offset = offset
+
+	_, err = f.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("Unable to seek: %s", err.Error())
+	}
+
+	f.readOffset = offset
+
+	return nil
+}
+
+// Next returns the next entry in the log. If there is none yet, it blocks
+// until one is written.
+//
+// It returns ErrFollowerClosed if the Follower is closed.
+func (f *Follower) Next() (*LogEntry, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for {
+		if f.isClosed() {
+			return nil, ErrFollowerClosed
+		}
+
+		if len(f.lines) > 0 {
+			pending := f.lines[0]
+			f.lines = f.lines[1:]
+
+			entry, err := f.parser.parse(pending.line, pending.size)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse line: %s", err.Error())
+			}
+
+			return entry, nil
+		}
+
+		n, err := f.read()
+		if err != nil {
+			return nil, err
+		}
+
+		if n > 0 {
+			continue
+		}
+
+		// We are at the end of the file. Before waiting, see whether the file was
+		// truncated or replaced.
+
+		changed, err := f.checkFile()
+		if err != nil {
+			return nil, err
+		}
+
+		if changed {
+			continue
+		}
+
+		f.mutex.Unlock()
+		if f.Idle != nil {
+			f.Idle()
+		}
+		select {
+		case <-f.done:
+		case <-time.After(f.PollInterval):
+		}
+		f.mutex.Lock()
+	}
+}
+
+// read reads what is available from the file. Complete lines go into the line
+// queue.
+func (f *Follower) read() (int, error) {
+	buf := make([]byte, 64*1024)
+
+	n, err := f.file.Read(buf)
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("Read error: %s", err.Error())
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	f.readOffset += int64(n)
+
+	data := append(f.partial, buf[:n]...)
+
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			break
+		}
+
+		f.lines = append(f.lines, pendingLine{
+			line: strings.TrimSuffix(string(data[:idx]), "\r"),
+			size: int64(idx) + 1,
+		})
+		data = data[idx+1:]
+	}
+
+	f.partial = append([]byte(nil), data...)
+
+	return n, nil
+}
+
+// checkFile looks for the file being truncated, rotated, or recreated. If it
+// was, we start reading the new content from the beginning.
+//
+// It returns true if there may be more to read.
+func (f *Follower) checkFile() (bool, error) {
+	fi, err := f.file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("Unable to stat file: %s: %s", f.filename,
+			err.Error())
+	}
+
+	if fi.Size() < f.readOffset {
+		_, err := f.file.Seek(0, io.SeekStart)
+		if err != nil {
+			return false, fmt.Errorf("Unable to seek: %s", err.Error())
+		}
+
+		f.reset()
+		return true, nil
+	}
+
+	pathFi, err := os.Stat(f.filename)
+	if err != nil {
+		// The file may be in the middle of being rotated. Try again later.
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("Unable to stat file: %s: %s", f.filename,
+			err.Error())
+	}
+
+	if os.SameFile(fi, pathFi) {
+		return false, nil
+	}
+
+	// There is a new file at the path. We have read everything in the old one.
+	// Any partial line in it will never be finished, so drop it.
+
+	file, err := os.Open(f.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("Unable to open file: %s: %s", f.filename,
+			err.Error())
+	}
+
+	_ = f.file.Close()
+	f.file = file
+	f.reset()
+
+	return true, nil
+}
+
+// reset clears our position for reading a file from the start.
+func (f *Follower) reset() {
+	f.readOffset = 0
+	f.partial = nil
+	f.lines = nil
+	f.parser.rewind()
+}
+
+// Checkpoint records the position of the Follower. Following from the
+// checkpoint later picks up with the entry after the last one Next returned.
+//
+// It must not be called at the same time as Next, other than from Idle.
+func (f *Follower) Checkpoint() (*Checkpoint, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil, ErrFollowerClosed
+	}
+
+	return f.parser.Checkpoint(f.file)
+}
+
+// isClosed checks whether Close was called.
+func (f *Follower) isClosed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops following the log. It is safe to call while another goroutine
+// is blocked in Next.
+func (f *Follower) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+	})
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// lastLineEnd finds the offset just past the last newline before size.
+func lastLineEnd(file *os.File, size int64) (int64, error) {
+	const blockSize = 64 * 1024
+
+	end := size
+	for end > 0 {
+		start := end - blockSize
+		if start < 0 {
+			start = 0
+		}
+
+		buf := make([]byte, end-start)
+		_, err := file.ReadAt(buf, start)
+		if err != nil {
+			return 0, fmt.Errorf("Read error: %s", err.Error())
+		}
+
+		idx := bytes.LastIndexByte(buf, '\n')
+		if idx != -1 {
+			return start + int64(idx) + 1, nil
+		}
+
+		end = start
+	}
+
+	return 0, nil
+}
+
+// countLines counts the lines before offset.
+func countLines(file *os.File, offset int64) (int, error) {
+	reader := io.NewSectionReader(file, 0, offset)
+	buf := make([]byte, 64*1024)
+	count := 0
+
+	for {
+		n, err := reader.Read(buf)
+		count += bytes.Count(buf[:n], []byte("\n"))
+		if err != nil {
+			if err == io.EOF {
+				return count, nil
+			}
+			return 0, fmt.Errorf("Read error: %s", err.Error())
+		}
+	}
+}
+
+// findDateLine looks backwards from offset for the most recent line that
+// tells us the date, which is a LogOpen or DayChange line.
+//
+// offset must be the start of a line. If there is no such line, it returns a
+// blank string.
+func findDateLine(file *os.File, offset int64) (string, error) {
+	const blockSize = 64 * 1024
+
+	// tail holds the start of a line that began in an earlier block.
+	var tail []byte
+
+	end := offset
+	for end > 0 {
+		start := end - blockSize
+		if start < 0 {
+			start = 0
+		}
+
+		buf := make([]byte, end-start, int(end-start)+len(tail))
+		_, err := file.ReadAt(buf, start)
+		if err != nil {
+			return "", fmt.Errorf("Read error: %s", err.Error())
+		}
+		buf = append(buf, tail...)
+
+		lines := bytes.Split(buf, []byte("\n"))
+
+		// The first line is only complete if we are at the start of the file.
+		for i := len(lines) - 1; i >= 0; i-- {
+			if i == 0 && start > 0 {
+				break
+			}
+
+			line := strings.TrimSuffix(string(lines[i]), "\r")
+			if isDateLine(line) {
+				return line, nil
+			}
+		}
+
+		tail = append([]byte(nil), lines[0]...)
+		end = start
+	}
+
+	return "", nil
+}
+
+// isDateLine checks if a line is one that sets the date.
+func isDateLine(line string) bool {
+	return strings.HasPrefix(line, "--- Log opened ") ||
+		strings.HasPrefix(line, "--- Day changed ")
+}
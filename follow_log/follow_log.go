@@ -0,0 +1,221 @@
+/*
+ * Follow an Irssi log as it is written and print each new entry.
+ *
+ * With -checkpoint-file we record how far we got, so the next run carries on
+ * from there. Writing a checkpoint takes a few file operations, so we don't do
+ * it for every entry. We write one whenever we have caught up with the log,
+ * every so often while catching up, and when we are interrupted.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/horgh/irssi_log"
+)
+
+// checkpointEntries is how many entries we read at most before updating the
+// checkpoint.
+const checkpointEntries = 1000
+
+func main() {
+	logFile := flag.String("log-file", "", "Path to a log file to follow.")
+	locationString := flag.String("location", "America/Vancouver", "Time zone location.")
+	fromStart := flag.Bool("from-start", false, "Start at the beginning of the log rather than the end.")
+	pollInterval := flag.Duration("poll-interval", irssi_log.DefaultPollInterval, "How often to check for new lines.")
+	checkpointFile := flag.String("checkpoint-file", "", "Path to a file to keep a checkpoint in. If it exists, we resume from it. Optional.")
+	checkpointInterval := flag.Duration("checkpoint-interval", 10*time.Second, "While catching up with the log, how often to update the checkpoint.")
+
+	flag.Parse()
+
+	if len(*logFile) == 0 {
+		log.Print("You must specify a log file.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *pollInterval <= 0 {
+		log.Print("You must specify a poll interval > 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *checkpointInterval <= 0 {
+		log.Print("You must specify a checkpoint interval > 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	location, err := time.LoadLocation(*locationString)
+	if err != nil {
+		log.Printf("Invalid location: %s", err.Error())
+		os.Exit(1)
+	}
+
+	follower, err := openFollower(*logFile, location, *fromStart, *checkpointFile)
+	if err != nil {
+		log.Printf("Unable to follow log: %s", err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		_ = follower.Close()
+	}()
+
+	follower.PollInterval = *pollInterval
+
+	checkpointer := &checkpointer{
+		follower: follower,
+		file:     *checkpointFile,
+		interval: *checkpointInterval,
+		last:     time.Now(),
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	// Next calls Idle when we've caught up. If we're told to stop while we wait
+	// for more, this is where we find out.
+	follower.Idle = func() {
+		err := checkpointer.write()
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+
+		select {
+		case <-stop:
+			_ = follower.Close()
+		default:
+		}
+	}
+
+	for {
+		entry, err := follower.Next()
+		if err == irssi_log.ErrFollowerClosed {
+			return
+		}
+		if err != nil {
+			log.Printf("Unable to read entry: %s", err.Error())
+			os.Exit(1)
+		}
+
+		if entry.Type != irssi_log.IgnoreThis {
+			fmt.Printf("%s %s\n", entry.Time.Format("2006-01-02 15:04"), entry.Line)
+		}
+
+		err = checkpointer.add()
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+
+		select {
+		case <-stop:
+			err := checkpointer.write()
+			if err != nil {
+				log.Print(err.Error())
+				os.Exit(1)
+			}
+			return
+		default:
+		}
+	}
+}
+
+// checkpointer decides when to write checkpoints.
+type checkpointer struct {
+	follower *irssi_log.Follower
+
+	// file is where we write checkpoints. If it is blank we write none.
+	file string
+
+	// interval is how long we go at most without writing one while reading
+	// entries.
+	interval time.Duration
+
+	// pending is how many entries we've read since the last checkpoint.
+	pending int
+
+	// last is when we last wrote one.
+	last time.Time
+}
+
+// add records that we read an entry. We write a checkpoint if we've read
+// enough since the last, or if it has been long enough.
+func (c *checkpointer) add() error {
+	c.pending++
+
+	if c.pending < checkpointEntries && time.Since(c.last) < c.interval {
+		return nil
+	}
+
+	return c.write()
+}
+
+// write writes a checkpoint if we've read any entries since the last.
+func (c *checkpointer) write() error {
+	if len(c.file) == 0 || c.pending == 0 {
+		return nil
+	}
+
+	checkpoint, err := c.follower.Checkpoint()
+	if err != nil {
+		return fmt.Errorf("Unable to create checkpoint: %s", err.Error())
+	}
+
+	err = irssi_log.WriteCheckpoint(c.file, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	c.pending = 0
+	c.last = time.Now()
+	return nil
+}
+
+// openFollower starts following the log. We resume from the checkpoint file if
+// there is one. If the checkpoint no longer matches the log, the log must have
+// been rotated, so we read the new log from its start.
+func openFollower(logFile string, location *time.Location, fromStart bool,
+	checkpointFile string) (*irssi_log.Follower, error) {
+	if len(checkpointFile) > 0 {
+		_, err := os.Stat(checkpointFile)
+		if err == nil {
+			checkpoint, err := irssi_log.ReadCheckpoint(checkpointFile)
+			if err != nil {
+				return nil, err
+			}
+
+			follower, err := irssi_log.NewFollowerFromCheckpoint(logFile, checkpoint)
+			if err == nil {
+				return follower, nil
+			}
+
+			if err != irssi_log.ErrStaleCheckpoint {
+				return nil, err
+			}
+
+			log.Printf("Checkpoint is stale. Starting from the start of the log.")
+			return irssi_log.NewFollower(logFile, location, 0)
+		}
+	}
+
+	if fromStart {
+		return irssi_log.NewFollower(logFile, location, 0)
+	}
+
+	return irssi_log.NewFollower(logFile, location, irssi_log.FromEnd)
+}
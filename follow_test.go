@@ -0,0 +1,215 @@
+package irssi_log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollower(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	filename := filepath.Join(dir, "test.log")
+
+	err = ioutil.WriteFile(filename, []byte(
+		"--- Log opened Sun Mar 27 15:04:05 2016\n"+
+			"15:04 -!- nick [user@host] has joined #channel\n"+
+			"--- Day changed Mon Mar 28 2016\n"+
+			"00:01 <@nick> hi\n"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	follower, err := NewFollower(filename, location, FromEnd)
+	if err != nil {
+		t.Fatalf("Unable to follow: %s", err.Error())
+	}
+	defer func() {
+		_ = follower.Close()
+	}()
+
+	follower.PollInterval = 10 * time.Millisecond
+
+	// A partial line must wait until it is complete. Its date comes from the
+	// DayChange line we had to look back for.
+
+	appendToFile(t, filename, "00:02 <@nick> th")
+
+	entries := make(chan *LogEntry)
+	go func() {
+		for {
+			entry, err := follower.Next()
+			if err != nil {
+				close(entries)
+				return
+			}
+			entries <- entry
+		}
+	}()
+
+	select {
+	case entry := <-entries:
+		t.Fatalf("Received entry for partial line: %s", entry.Line)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	appendToFile(t, filename, "ere\n")
+
+	entry := <-entries
+	wantTime := time.Date(2016, 3, 28, 0, 2, 0, 0, location)
+	if entry.Type != Message || entry.Text != "there" ||
+		!entry.Time.Equal(wantTime) {
+		t.Fatalf("Unexpected entry: %s at %s", entry.Line, entry.Time)
+	}
+
+	// Truncate. We should start again from the beginning.
+
+	err = ioutil.WriteFile(filename, []byte(
+		"--- Log opened Tue Mar 29 10:00:00 2016\n"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	entry = <-entries
+	if entry.Type != LogOpen {
+		t.Fatalf("Unexpected entry after truncation: %s", entry.Line)
+	}
+
+	// Recreate the file, as happens when a log is rotated.
+
+	err = os.Rename(filename, filename+".1")
+	if err != nil {
+		t.Fatalf("Unable to rename file: %s", err.Error())
+	}
+
+	err = ioutil.WriteFile(filename, []byte(
+		"--- Log opened Wed Mar 30 11:00:00 2016\n"+
+			"11:01 <+other> hello\n"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	entry = <-entries
+	if entry.Type != LogOpen {
+		t.Fatalf("Unexpected entry after rotation: %s", entry.Line)
+	}
+
+	entry = <-entries
+	wantTime = time.Date(2016, 3, 30, 11, 1, 0, 0, location)
+	if entry.Type != Message || entry.Nick != "other" ||
+		!entry.Time.Equal(wantTime) {
+		t.Fatalf("Unexpected entry: %s at %s", entry.Line, entry.Time)
+	}
+
+	err = follower.Close()
+	if err != nil {
+		t.Fatalf("Unable to close: %s", err.Error())
+	}
+
+	if _, ok := <-entries; ok {
+		t.Fatalf("Received entry after close")
+	}
+}
+
+// TestFollowerIdle checks that Idle is called once we have read everything
+// there is, and that it can take a checkpoint and stop following.
+func TestFollowerIdle(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	filename := filepath.Join(dir, "test.log")
+
+	text := "--- Log opened Sun Mar 27 15:04:05 2016\n" +
+		"15:04 -!- nick [user@host] has joined #channel\n" +
+		"15:05 <@nick> hi\n"
+
+	err = ioutil.WriteFile(filename, []byte(text+"15:06 <@nick> th"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	follower, err := NewFollower(filename, location, 0)
+	if err != nil {
+		t.Fatalf("Unable to follow: %s", err.Error())
+	}
+	defer func() {
+		_ = follower.Close()
+	}()
+
+	follower.PollInterval = 10 * time.Millisecond
+
+	entries := 0
+	var checkpoint *Checkpoint
+	follower.Idle = func() {
+		if entries != 3 {
+			t.Errorf("Idle after %d entries, wanted 3", entries)
+		}
+
+		var err error
+		checkpoint, err = follower.Checkpoint()
+		if err != nil {
+			t.Errorf("Unable to create checkpoint: %s", err.Error())
+		}
+
+		err = follower.Close()
+		if err != nil {
+			t.Errorf("Unable to close: %s", err.Error())
+		}
+	}
+
+	for {
+		_, err := follower.Next()
+		if err == ErrFollowerClosed {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read entry: %s", err.Error())
+		}
+		entries++
+	}
+
+	if checkpoint == nil || checkpoint.Offset != int64(len(text)) {
+		t.Fatalf("Checkpoint is %+v, wanted offset %d", checkpoint, len(text))
+	}
+}
+
+// appendToFile writes text to the end of a file.
+func appendToFile(t *testing.T, filename, text string) {
+	fh, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+
+	_, err = fh.WriteString(text)
+	if err != nil {
+		t.Fatalf("Unable to write: %s", err.Error())
+	}
+
+	err = fh.Close()
+	if err != nil {
+		t.Fatalf("Unable to close file: %s", err.Error())
+	}
+}
@@ -0,0 +1,369 @@
+/*
+ * Search Irssi logs with a query and print the entries that match.
+ *
+ * See query.go in the irssi_log package for the query syntax.
+ *
+ * Log lines only have the time of day. We print each entry with the date the
+ * parser knows it is from.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/horgh/irssi_log"
+)
+
+// grepper finds matching entries and prints them along with the entries
+// around them.
+type grepper struct {
+	filter irssi_log.Filter
+
+	// How many entries to print before and after each match.
+	before int
+	after  int
+
+	// crossSessions is whether context may include entries from a different
+	// session. A session runs from a LogOpen line to a LogClosed line.
+	crossSessions bool
+
+	printer printer
+
+	// Where the last entry we printed was, so we know if the next one follows
+	// it.
+	printed     bool
+	lastFile    string
+	lastLine    int
+	lastSession int
+}
+
+// printer writes out entries.
+type printer interface {
+	// print writes an entry. match is whether it matched or is context.
+	print(file string, entry *irssi_log.LogEntry, match bool) error
+
+	// separator writes a marker between groups of entries that are not next
+	// to each other.
+	separator() error
+
+	flush() error
+}
+
+func main() {
+	locationString := flag.String("location", "America/Vancouver", "Time zone location.")
+	after := flag.Int("A", 0, "Print this many entries after each match.")
+	before := flag.Int("B", 0, "Print this many entries before each match.")
+	context := flag.Int("C", 0, "Print this many entries before and after each match. -A and -B override it.")
+	crossSessions := flag.Bool("cross-sessions", false, "Let context include entries from before a log was opened or after it was closed.")
+	format := flag.String("format", "pretty", "Output format: pretty (date and line), raw (line as in the log), or json.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <query> <log file> [log file...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Example query: nick:alice type:message,emote after:2016-03-01 text:/deploy(ed)?/ -nick:bot\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Print("You must specify a query and at least one log file.")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *after < 0 || *before < 0 || *context < 0 {
+		log.Print("You must specify context >= 0.")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *format != "pretty" && *format != "raw" && *format != "json" {
+		log.Print("You must specify a format of pretty, raw, or json.")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	location, err := time.LoadLocation(*locationString)
+	if err != nil {
+		log.Printf("Invalid location: %s", err.Error())
+		os.Exit(2)
+	}
+
+	filter, err := irssi_log.ParseQuery(flag.Arg(0), location)
+	if err != nil {
+		log.Print(err.Error())
+		os.Exit(2)
+	}
+
+	logFiles := flag.Args()[1:]
+
+	g := &grepper{
+		filter:        filter,
+		before:        *context,
+		after:         *context,
+		crossSessions: *crossSessions,
+	}
+
+	// -A and -B override -C if given, even if they're 0.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "A":
+			g.after = *after
+		case "B":
+			g.before = *before
+		}
+	})
+
+	showFile := len(logFiles) > 1
+	switch *format {
+	case "pretty":
+		g.printer = newTextPrinter(os.Stdout, showFile, true)
+	case "raw":
+		g.printer = newTextPrinter(os.Stdout, showFile, false)
+	case "json":
+		g.printer = newJSONPrinter(os.Stdout)
+	}
+
+	found := false
+	for _, logFile := range logFiles {
+		matched, err := g.grepFile(logFile, location)
+		if err != nil {
+			_ = g.printer.flush()
+			log.Print(err.Error())
+			os.Exit(2)
+		}
+
+		if matched {
+			found = true
+		}
+	}
+
+	err = g.printer.flush()
+	if err != nil {
+		log.Print(err.Error())
+		os.Exit(2)
+	}
+
+	// Like grep, exit with 1 if nothing matched, and 2 if there was an error.
+	if !found {
+		os.Exit(1)
+	}
+}
+
+// grepFile prints the entries in a log that match, with their context. It
+// tells whether there were any.
+func (g *grepper) grepFile(logFile string, location *time.Location) (bool,
+	error) {
+	fh, err := os.Open(logFile)
+	if err != nil {
+		return false, fmt.Errorf("Unable to open file: %s: %s", logFile,
+			err.Error())
+	}
+	defer fh.Close()
+
+	reader := irssi_log.NewReader(fh, irssi_log.NewParser(location))
+
+	// Entries we may print as context before the next match.
+	var pending []*irssi_log.LogEntry
+
+	// How many more entries to print as context after the last match.
+	afterLeft := 0
+
+	// session counts the LogOpen lines we've seen.
+	session := 0
+
+	// As grep does, with context we separate groups of entries that don't
+	// follow each other, including groups from different files.
+	emit := func(entry *irssi_log.LogEntry, match bool) error {
+		if g.printed && (g.before > 0 || g.after > 0) &&
+			(logFile != g.lastFile || entry.LineNumber != g.lastLine+1 ||
+				session != g.lastSession) {
+			err := g.printer.separator()
+			if err != nil {
+				return err
+			}
+		}
+
+		g.printed = true
+		g.lastFile = logFile
+		g.lastLine = entry.LineNumber
+		g.lastSession = session
+
+		return g.printer.print(logFile, entry, match)
+	}
+
+	found := false
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return found, nil
+			}
+			return found, fmt.Errorf("%s: %s", logFile, err.Error())
+		}
+
+		// A new session. Context from the last one stays there.
+		if entry.Type == irssi_log.LogOpen && !g.crossSessions {
+			pending = pending[:0]
+			afterLeft = 0
+			session++
+		}
+
+		if g.filter.Match(entry) {
+			found = true
+
+			for _, p := range pending {
+				err := emit(p, false)
+				if err != nil {
+					return found, err
+				}
+			}
+			pending = pending[:0]
+
+			err := emit(entry, true)
+			if err != nil {
+				return found, err
+			}
+
+			afterLeft = g.after
+			continue
+		}
+
+		if afterLeft > 0 {
+			err := emit(entry, false)
+			if err != nil {
+				return found, err
+			}
+
+			afterLeft--
+			continue
+		}
+
+		if g.before > 0 {
+			if len(pending) == g.before {
+				copy(pending, pending[1:])
+				pending = pending[:len(pending)-1]
+			}
+			pending = append(pending, entry)
+		}
+	}
+}
+
+// textPrinter prints entries as lines, like grep.
+type textPrinter struct {
+	writer *bufio.Writer
+
+	// showFile is whether to start each line with the file it is from.
+	showFile bool
+
+	// showDate is whether to start each line with its date.
+	showDate bool
+}
+
+func newTextPrinter(w io.Writer, showFile, showDate bool) *textPrinter {
+	return &textPrinter{
+		writer:   bufio.NewWriter(w),
+		showFile: showFile,
+		showDate: showDate,
+	}
+}
+
+func (p *textPrinter) print(file string, entry *irssi_log.LogEntry,
+	match bool) error {
+	line := entry.Line
+
+	if p.showDate {
+		line = entry.Time.Format("2006-01-02") + " " + line
+	}
+
+	// As grep does, mark matches with : and context with -.
+	if p.showFile {
+		if match {
+			line = file + ":" + line
+		} else {
+			line = file + "-" + line
+		}
+	}
+
+	_, err := p.writer.WriteString(line + "\n")
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+	return nil
+}
+
+func (p *textPrinter) separator() error {
+	_, err := p.writer.WriteString("--\n")
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+	return nil
+}
+
+func (p *textPrinter) flush() error {
+	err := p.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Unable to flush: %s", err.Error())
+	}
+	return nil
+}
+
+// jsonPrinter prints each entry as a JSON object on its own line.
+type jsonPrinter struct {
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// jsonResult is how we encode an entry we print.
+type jsonResult struct {
+	File  string              `json:"file"`
+	Match bool                `json:"match"`
+	Entry *irssi_log.LogEntry `json:"entry"`
+}
+
+func newJSONPrinter(w io.Writer) *jsonPrinter {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+
+	return &jsonPrinter{
+		writer:  writer,
+		encoder: encoder,
+	}
+}
+
+func (p *jsonPrinter) print(file string, entry *irssi_log.LogEntry,
+	match bool) error {
+	err := p.encoder.Encode(jsonResult{File: file, Match: match, Entry: entry})
+	if err != nil {
+		return fmt.Errorf("Unable to encode: %s", err.Error())
+	}
+	return nil
+}
+
+// separator does nothing. Each result says whether it is a match.
+func (p *jsonPrinter) separator() error {
+	return nil
+}
+
+func (p *jsonPrinter) flush() error {
+	err := p.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Unable to flush: %s", err.Error())
+	}
+	return nil
+}
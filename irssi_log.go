@@ -7,9 +7,11 @@ package irssi_log
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -55,11 +57,31 @@ type LogEntry struct {
 	// Nick, if available
 	Nick string
 
+	// Nick's channel status prefix, if available. e.g., @ for an op. Blank for
+	// someone with no status.
+	NickMode string
+
+	// Nick of who acted on Nick, if applicable. e.g., who kicked them
+	ByNick string
+
 	// user@host, if available
 	UserHost string
 
 	// Text, if applicable. e.g., message text
 	Text string
+
+	// NoticeTarget is who in the channel a ChannelNotice went to: @ for ops or
+	// + for voiced users. Blank for everyone.
+	NoticeTarget string
+
+	// SyncSeconds is how long a join took to sync, for a JoinSync.
+	SyncSeconds int
+
+	// Line number in the log, starting at 1
+	LineNumber int
+
+	// Byte offset of the start of the line in the log
+	Offset int64
 }
 
 const LogOpenTimeLayout = "Mon Jan 02 15:04:05 2006"
@@ -68,11 +90,11 @@ var logOpenPattern = regexp.MustCompile("^--- Log opened (.+)$")
 
 var joinPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- (\\S+) \\[(\\S+?)\\] has joined (\\S+)$")
 
-var summaryPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- Irssi: (\\S+): Total of \\d+ nicks \\[\\d+ ops, \\d+ halfops, \\d+ voices, \\d+ normal\\]$")
+var summaryPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- Irssi: (\\S+): (Total of \\d+ nicks \\[\\d+ ops, \\d+ halfops, \\d+ voices, \\d+ normal\\])$")
 
-var modePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- mode/(\\S+) \\[.+\\] by (\\S+)$")
+var modePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- mode/(\\S+) \\[(.+)\\] by (\\S+)$")
 
-var syncPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- Irssi: Join to (\\S+) was synced in \\d+ secs$")
+var syncPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- Irssi: Join to (\\S+) was synced in (\\d+) secs$")
 
 // Text can be totally blank
 var messagePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) <(.)(\\S+)> (.*)$")
@@ -99,458 +121,738 @@ var yourNickPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- You're now know
 
 var serverModePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- ServerMode/(\\S+) \\[(.+)\\] by (\\S+)$")
 
-var channelNoticePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -(\\S+):[+@]?(\\S+)- (.*)$")
+var channelNoticePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -(\\S+):([+@]?)(\\S+)- (.*)$")
 
-var keepnickPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- Keepnick:")
+var keepnickPattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- Keepnick:(.*)$")
 
 var serverNoticePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) !(\\S+) (.*)$")
 
 var bansNonePattern = regexp.MustCompile("^(\\d{2}):(\\d{2}) -!- Irssi: No bans in channel (\\S+)$")
 
-// ParseLog reads lines of an Irssi log and generates an ordered slice
-// of LogEntrys
-func ParseLog(file *os.File, lineLimit int, location *time.Location) (
-	[]*LogEntry, error) {
-	scanner := bufio.NewScanner(file)
+// Parser parses the lines of a log in order.
+//
+// Most lines have only a HH:MM timestamp. The Parser remembers the date from
+// the most recent LogOpen or DayChange line so it can give them a full time.
+// It also keeps track of where it is in the log, and of our own nick.
+type Parser struct {
+	// Interner, if set, shares the strings for nicks, channels, and user@hosts
+	// between entries.
+	Interner *Interner
+
+	// DropLines, if set, means entries do not keep their raw line. Their text
+	// is copied out of the line so that the line can be freed.
+	DropLines bool
+
+	location    *time.Location
+	currentDate time.Time
+	selfNick    string
+
+	// lineNumber is the number of lines parsed.
+	lineNumber int
+
+	// offset is the byte offset of the next line.
+	offset int64
+}
 
-	lineCount := 0
+// NewParser creates a Parser. Timestamps are taken to be in the given
+// location.
+func NewParser(location *time.Location) *Parser {
+	return &Parser{location: location}
+}
 
-	var entries []*LogEntry
+// Parse parses the next line of the log. The line must not include its
+// newline. We assume the line ends with a single \n.
+func (p *Parser) Parse(line string) (*LogEntry, error) {
+	return p.parse(line, int64(len(line))+1)
+}
+
+// parse parses the next line of the log. size is how many bytes the line
+// takes in the log, including its line ending.
+func (p *Parser) parse(line string, size int64) (*LogEntry, error) {
+	entry, err := ParseLine(line, p.location, p.currentDate)
+	if err != nil {
+		return nil, err
+	}
 
-	var currentDate time.Time
+	p.lineNumber++
+	entry.LineNumber = p.lineNumber
+	entry.Offset = p.offset
+	p.offset += size
 
-	for scanner.Scan() {
-		lineCount++
+	// Make sure we know what day it is!
+	if entry.Type == LogOpen || entry.Type == DayChange {
+		p.currentDate = time.Date(entry.Time.Year(), entry.Time.Month(),
+			entry.Time.Day(), 0, 0, 0, 0, p.location)
+	}
 
-		entry, err := ParseLine(scanner.Text(), location, currentDate)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to parse line: %s", err.Error())
+	if entry.Type == YourNickChange {
+		p.selfNick = entry.Nick
+	}
+
+	if p.Interner != nil {
+		entry.Nick = p.Interner.Intern(entry.Nick)
+		entry.NickMode = p.Interner.Intern(entry.NickMode)
+		entry.ByNick = p.Interner.Intern(entry.ByNick)
+		entry.Channel = p.Interner.Intern(entry.Channel)
+		entry.UserHost = p.Interner.Intern(entry.UserHost)
+		entry.NoticeTarget = p.Interner.Intern(entry.NoticeTarget)
+	}
+
+	// The fields are parts of the line. To be able to free the line we need to
+	// copy them out of it.
+	if p.DropLines {
+		entry.Line = ""
+		entry.Text = copyString(entry.Text)
+
+		if p.Interner == nil {
+			entry.Nick = copyString(entry.Nick)
+			entry.NickMode = copyString(entry.NickMode)
+			entry.ByNick = copyString(entry.ByNick)
+			entry.Channel = copyString(entry.Channel)
+			entry.UserHost = copyString(entry.UserHost)
+			entry.NoticeTarget = copyString(entry.NoticeTarget)
 		}
+	}
 
-		entries = append(entries, entry)
+	return entry, nil
+}
+
+// SelfNick returns our own nick, if the log told us it.
+func (p *Parser) SelfNick() string {
+	return p.selfNick
+}
 
-		// Make sure we know what day it is!
-		if entry.Type == LogOpen || entry.Type == DayChange {
-			currentDate = time.Date(entry.Time.Year(), entry.Time.Month(),
-				entry.Time.Day(), 0, 0, 0, 0, location)
+// rewind moves the Parser back to the start of the log. It keeps the date and
+// nick it knows.
+func (p *Parser) rewind() {
+	p.lineNumber = 0
+	p.offset = 0
+}
+
+// Reader reads entries from a log one at a time.
+type Reader struct {
+	scanner *bufio.Scanner
+	parser  *Parser
+
+	// tokenSize is the size of the last line the scanner read, including its
+	// line ending.
+	tokenSize int64
+}
+
+// NewReader creates a Reader. The parser decides where the log starts and
+// what date context it has, so it must be positioned where r is.
+func NewReader(r io.Reader, parser *Parser) *Reader {
+	reader := &Reader{
+		scanner: bufio.NewScanner(r),
+		parser:  parser,
+	}
+
+	reader.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			reader.tokenSize = int64(advance)
 		}
+		return advance, token, err
+	})
 
-		if lineLimit > 0 && lineCount >= lineLimit {
-			return entries, nil
+	return reader
+}
+
+// Next returns the next entry. It returns io.EOF when there are no more.
+func (r *Reader) Next() (*LogEntry, error) {
+	if !r.scanner.Scan() {
+		err := r.scanner.Err()
+		if err != nil {
+			return nil, fmt.Errorf("Line scan failure: %s", err.Error())
 		}
+		return nil, io.EOF
 	}
 
-	err := scanner.Err()
+	entry, err := r.parser.parse(r.scanner.Text(), r.tokenSize)
 	if err != nil {
-		return nil, fmt.Errorf("Line scan failure: %s", err.Error())
+		return nil, fmt.Errorf("Unable to parse line: %s", err.Error())
 	}
 
-	return entries, nil
+	return entry, nil
 }
 
-// ParseLine parses an Irssi log line
-func ParseLine(line string, location *time.Location, currentDate time.Time) (
-	*LogEntry, error) {
+// ParseLog reads lines of an Irssi log and generates an ordered slice
+// of LogEntrys
+func ParseLog(file *os.File, lineLimit int, location *time.Location) (
+	[]*LogEntry, error) {
+	return readEntries(NewReader(file, NewParser(location)), lineLimit)
+}
 
-	// Channel message
+// readEntries reads entries until the end of the log, or until we have
+// lineLimit of them if lineLimit is positive.
+func readEntries(reader EntryReader, lineLimit int) ([]*LogEntry, error) {
+	var entries []*LogEntry
 
-	messageMatches := messagePattern.FindStringSubmatch(line)
-	if messageMatches != nil {
-		entryTime, err := clockToTime(messageMatches[1], messageMatches[2],
-			currentDate, location)
+	for {
+		entry, err := reader.Next()
 		if err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
 			return nil, err
 		}
 
-		// TODO: Get channel
+		entries = append(entries, entry)
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: Message,
-			Nick: messageMatches[4],
-			Text: messageMatches[5],
-		}, nil
+		if lineLimit > 0 && len(entries) >= lineLimit {
+			return entries, nil
+		}
 	}
+}
 
-	// Log open type.
+// lineMatcher recognizes one kind of line and parses it.
+type lineMatcher struct {
+	// keyword is text that must be in any line the pattern matches. Checking
+	// for it is much cheaper than running the pattern. Blank to always run the
+	// pattern.
+	keyword string
 
-	logOpenMatches := logOpenPattern.FindStringSubmatch(line)
-	if logOpenMatches != nil {
-		entryTime, err := time.ParseInLocation(LogOpenTimeLayout, logOpenMatches[1],
-			location)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to parse timestamp: %s: %s",
-				logOpenMatches[1], err.Error())
-		}
+	pattern *regexp.Regexp
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: LogOpen,
-		}, nil
-	}
+	// parse creates an entry from the pattern's matches.
+	parse func(line string, matches []string, location *time.Location,
+		currentDate time.Time) (*LogEntry, error)
+}
 
-	// Join type.
+var logOpenMatcher = &lineMatcher{pattern: logOpenPattern, parse: parseLogOpen}
 
-	joinMatches := joinPattern.FindStringSubmatch(line)
-	if joinMatches != nil {
-		entryTime, err := clockToTime(joinMatches[1], joinMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+var joinMatcher = &lineMatcher{keyword: "] has joined ", pattern: joinPattern,
+	parse: parseJoin}
 
-		return &LogEntry{
-			Line:     line,
-			Time:     entryTime,
-			Type:     Join,
-			Channel:  joinMatches[5],
-			Nick:     joinMatches[3],
-			UserHost: joinMatches[4],
-		}, nil
-	}
+var summaryMatcher = &lineMatcher{keyword: " nicks [", pattern: summaryPattern,
+	parse: parseSummary}
 
-	// Channel summary
+var modeMatcher = &lineMatcher{keyword: "-!- mode/", pattern: modePattern,
+	parse: parseMode}
 
-	summaryMatches := summaryPattern.FindStringSubmatch(line)
-	if summaryMatches != nil {
-		entryTime, err := clockToTime(summaryMatches[1], summaryMatches[2],
-			currentDate, location)
-		if err != nil {
-			return nil, err
-		}
+var syncMatcher = &lineMatcher{keyword: " was synced in ", pattern: syncPattern,
+	parse: parseSync}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    ChannelSummary,
-			Channel: summaryMatches[3],
-		}, nil
-	}
+var messageMatcher = &lineMatcher{pattern: messagePattern, parse: parseMessage}
 
-	// Mode change
+var quitMatcher = &lineMatcher{keyword: "] has quit [", pattern: quitPattern,
+	parse: parseQuit}
 
-	// TODO: Parse out the modes and who/what targeted
+var nickMatcher = &lineMatcher{keyword: " is now known as ",
+	pattern: nickPattern, parse: parseNick}
 
-	modeMatches := modePattern.FindStringSubmatch(line)
-	if modeMatches != nil {
-		entryTime, err := clockToTime(modeMatches[1], modeMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+var dayMatcher = &lineMatcher{pattern: dayPattern, parse: parseDay}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    Mode,
-			Channel: modeMatches[3],
-			Nick:    modeMatches[4],
-		}, nil
-	}
+var closeMatcher = &lineMatcher{pattern: closePattern, parse: parseClose}
 
-	// Channel sync
+var nowMatcher = &lineMatcher{keyword: "You are now talking in ",
+	pattern: nowPattern, parse: parseNow}
 
-	syncMatches := syncPattern.FindStringSubmatch(line)
-	if syncMatches != nil {
-		entryTime, err := clockToTime(syncMatches[1], syncMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+var emoteMatcher = &lineMatcher{pattern: emotePattern, parse: parseEmote}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    JoinSync,
-			Channel: syncMatches[3],
-		}, nil
-	}
+var topicMatcher = &lineMatcher{keyword: " changed the topic of ",
+	pattern: topicPattern, parse: parseTopic}
 
-	// Quit
+var kickMatcher = &lineMatcher{keyword: " was kicked from ",
+	pattern: kickPattern, parse: parseKick}
 
-	quitMatches := quitPattern.FindStringSubmatch(line)
-	if quitMatches != nil {
-		entryTime, err := clockToTime(quitMatches[1], quitMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
+var partMatcher = &lineMatcher{keyword: "] has left ", pattern: partPattern,
+	parse: parsePart}
+
+var yourNickMatcher = &lineMatcher{keyword: "-!- You're now known as ",
+	pattern: yourNickPattern, parse: parseYourNick}
+
+var serverModeMatcher = &lineMatcher{keyword: "-!- ServerMode/",
+	pattern: serverModePattern, parse: parseServerMode}
+
+var channelNoticeMatcher = &lineMatcher{pattern: channelNoticePattern,
+	parse: parseChannelNotice}
+
+var keepnickMatcher = &lineMatcher{keyword: "-!- Keepnick:",
+	pattern: keepnickPattern, parse: parseKeepnick}
+
+var serverNoticeMatcher = &lineMatcher{pattern: serverNoticePattern,
+	parse: parseServerNotice}
+
+var bansNoneMatcher = &lineMatcher{keyword: "No bans in channel ",
+	pattern: bansNonePattern, parse: parseBansNone}
+
+// Matchers grouped by how their lines start. Within a group they are in the
+// order we try them.
+
+var logMatchers = []*lineMatcher{logOpenMatcher, dayMatcher, closeMatcher}
+
+var statusMatchers = []*lineMatcher{
+	joinMatcher,
+	summaryMatcher,
+	modeMatcher,
+	syncMatcher,
+	quitMatcher,
+	nickMatcher,
+	nowMatcher,
+	topicMatcher,
+	kickMatcher,
+	partMatcher,
+	yourNickMatcher,
+	serverModeMatcher,
+	keepnickMatcher,
+	bansNoneMatcher,
+}
+
+var messageMatchers = []*lineMatcher{messageMatcher}
+
+var emoteMatchers = []*lineMatcher{emoteMatcher}
+
+var serverNoticeMatchers = []*lineMatcher{serverNoticeMatcher}
+
+var channelNoticeMatchers = []*lineMatcher{channelNoticeMatcher}
+
+// ParseLine parses an Irssi log line
+//
+// We look at how the line starts to decide which patterns could match it, and
+// only try those.
+func ParseLine(line string, location *time.Location, currentDate time.Time) (
+	*LogEntry, error) {
+	for _, matcher := range candidateMatchers(line) {
+		if matcher.keyword != "" && !strings.Contains(line, matcher.keyword) {
+			continue
 		}
 
-		// TODO: Get channel
+		matches := matcher.pattern.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
 
-		return &LogEntry{
-			Line:     line,
-			Time:     entryTime,
-			Type:     Quit,
-			Nick:     quitMatches[3],
-			UserHost: quitMatches[4],
-			Text:     quitMatches[5],
-		}, nil
+		return matcher.parse(line, matches, location, currentDate)
 	}
 
-	// Nick change
+	return nil, fmt.Errorf("Unrecognized line: %s", line)
+}
 
-	nickMatches := nickPattern.FindStringSubmatch(line)
-	if nickMatches != nil {
-		entryTime, err := clockToTime(nickMatches[1], nickMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+// candidateMatchers finds the matchers that could recognize a line.
+//
+// Lines either start with "--- " or with a HH:MM timestamp. After the
+// timestamp, each kind of line has its own prefix.
+func candidateMatchers(line string) []*lineMatcher {
+	if strings.HasPrefix(line, "--- ") {
+		return logMatchers
+	}
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: NickChange,
-			Nick: nickMatches[3],
-			Text: nickMatches[4],
-		}, nil
+	if !hasClock(line) {
+		return nil
 	}
 
-	// Day change
+	rest := line[6:]
+
+	switch {
+	case strings.HasPrefix(rest, "-!- "):
+		return statusMatchers
+	case strings.HasPrefix(rest, "<"):
+		return messageMatchers
+	case strings.HasPrefix(rest, " * "):
+		return emoteMatchers
+	case strings.HasPrefix(rest, "!"):
+		return serverNoticeMatchers
+	case strings.HasPrefix(rest, "-"):
+		return channelNoticeMatchers
+	}
 
-	dayMatches := dayPattern.FindStringSubmatch(line)
-	if dayMatches != nil {
-		timeLayout := "Mon Jan 02 2006"
-		entryTime, err := time.ParseInLocation(timeLayout, dayMatches[1], location)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to parse timestamp: %s: %s", dayMatches[1],
-				err.Error())
-		}
+	return nil
+}
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: DayChange,
-		}, nil
+// hasClock checks if a line starts with a HH:MM timestamp and a space.
+func hasClock(line string) bool {
+	if len(line) < 6 {
+		return false
 	}
 
-	// Log closed
+	return isDigit(line[0]) && isDigit(line[1]) && line[2] == ':' &&
+		isDigit(line[3]) && isDigit(line[4]) && line[5] == ' '
+}
 
-	closeMatches := closePattern.FindStringSubmatch(line)
-	if closeMatches != nil {
-		timeLayout := "Mon Jan 02 15:04:05 2006"
-		entryTime, err := time.ParseInLocation(timeLayout, closeMatches[1],
-			location)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to parse timestamp: %s: %s",
-				closeMatches[1], err.Error())
-		}
+// isDigit checks if a byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: LogClosed,
-		}, nil
+// parseMessage parses a channel message.
+func parseMessage(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Now talking in
+	// TODO: Get channel
 
-	nowMatches := nowPattern.FindStringSubmatch(line)
-	if nowMatches != nil {
-		entryTime, err := clockToTime(nowMatches[1], nowMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+	return &LogEntry{
+		Line:     line,
+		Time:     entryTime,
+		Type:     Message,
+		Nick:     matches[4],
+		NickMode: strings.TrimSpace(matches[3]),
+		Text:     matches[5],
+	}, nil
+}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    NowTalking,
-			Channel: nowMatches[3],
-		}, nil
+// parseLogOpen parses a log open line.
+func parseLogOpen(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := time.ParseInLocation(LogOpenTimeLayout, matches[1],
+		location)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse timestamp: %s: %s", matches[1],
+			err.Error())
 	}
 
-	// Channel emote
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: LogOpen,
+	}, nil
+}
 
-	emoteMatches := emotePattern.FindStringSubmatch(line)
-	if emoteMatches != nil {
-		entryTime, err := clockToTime(emoteMatches[1], emoteMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+// parseJoin parses a join.
+func parseJoin(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: Emote,
-			Nick: emoteMatches[3],
-			Text: emoteMatches[4],
-		}, nil
+	return &LogEntry{
+		Line:     line,
+		Time:     entryTime,
+		Type:     Join,
+		Channel:  matches[5],
+		Nick:     matches[3],
+		UserHost: matches[4],
+	}, nil
+}
+
+// parseSummary parses a channel summary.
+func parseSummary(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Topic change
+	return &LogEntry{
+		Line:    line,
+		Time:    entryTime,
+		Type:    ChannelSummary,
+		Channel: matches[3],
+		Text:    matches[4],
+	}, nil
+}
 
-	topicMatches := topicPattern.FindStringSubmatch(line)
-	if topicMatches != nil {
-		entryTime, err := clockToTime(topicMatches[1], topicMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+// parseMode parses a mode change.
+//
+// TODO: Parse out the modes and who/what targeted. For now we keep them as
+// text.
+func parseMode(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    Topic,
-			Nick:    topicMatches[3],
-			Channel: topicMatches[4],
-			Text:    topicMatches[5],
-		}, nil
+	return &LogEntry{
+		Line:    line,
+		Time:    entryTime,
+		Type:    Mode,
+		Channel: matches[3],
+		Text:    matches[4],
+		Nick:    matches[5],
+	}, nil
+}
+
+// parseSync parses a channel sync.
+func parseSync(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Kick
+	syncSeconds, err := strconv.Atoi(matches[4])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sync time: %s", matches[4])
+	}
 
-	kickMatches := kickPattern.FindStringSubmatch(line)
-	if kickMatches != nil {
-		entryTime, err := clockToTime(kickMatches[1], kickMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+	return &LogEntry{
+		Line:        line,
+		Time:        entryTime,
+		Type:        JoinSync,
+		Channel:     matches[3],
+		SyncSeconds: syncSeconds,
+	}, nil
+}
 
-		// TODO: 2 nicks
+// parseQuit parses a quit.
+func parseQuit(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: Get channel
+
+	return &LogEntry{
+		Line:     line,
+		Time:     entryTime,
+		Type:     Quit,
+		Nick:     matches[3],
+		UserHost: matches[4],
+		Text:     matches[5],
+	}, nil
+}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    Kick,
-			Nick:    kickMatches[3],
-			Channel: kickMatches[4],
-			Text:    kickMatches[6],
-		}, nil
+// parseNick parses a nick change.
+func parseNick(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Part
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: NickChange,
+		Nick: matches[3],
+		Text: matches[4],
+	}, nil
+}
+
+// parseDay parses a day change.
+func parseDay(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := time.ParseInLocation(DayChangeTimeLayout, matches[1],
+		location)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse timestamp: %s: %s", matches[1],
+			err.Error())
+	}
 
-	partMatches := partPattern.FindStringSubmatch(line)
-	if partMatches != nil {
-		entryTime, err := clockToTime(partMatches[1], partMatches[2], currentDate,
-			location)
-		if err != nil {
-			return nil, err
-		}
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: DayChange,
+	}, nil
+}
 
-		return &LogEntry{
-			Line:     line,
-			Time:     entryTime,
-			Type:     Part,
-			Nick:     partMatches[3],
-			UserHost: partMatches[4],
-			Channel:  partMatches[5],
-			Text:     partMatches[6],
-		}, nil
+// parseClose parses a log closed line.
+func parseClose(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	timeLayout := "Mon Jan 02 15:04:05 2006"
+	entryTime, err := time.ParseInLocation(timeLayout, matches[1], location)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse timestamp: %s: %s", matches[1],
+			err.Error())
 	}
 
-	// Your nick change
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: LogClosed,
+	}, nil
+}
 
-	yourNickMatches := yourNickPattern.FindStringSubmatch(line)
-	if yourNickMatches != nil {
-		entryTime, err := clockToTime(yourNickMatches[1], yourNickMatches[2],
-			currentDate, location)
-		if err != nil {
-			return nil, err
-		}
+// parseNow parses a now talking in line.
+func parseNow(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogEntry{
+		Line:    line,
+		Time:    entryTime,
+		Type:    NowTalking,
+		Channel: matches[3],
+	}, nil
+}
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: YourNickChange,
-			Nick: yourNickMatches[3],
-		}, nil
+// parseEmote parses a channel emote.
+func parseEmote(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Server changed mode
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: Emote,
+		Nick: matches[3],
+		Text: matches[4],
+	}, nil
+}
 
-	serverModeMatches := serverModePattern.FindStringSubmatch(line)
-	if serverModeMatches != nil {
-		entryTime, err := clockToTime(serverModeMatches[1], serverModeMatches[2],
-			currentDate, location)
-		if err != nil {
-			return nil, err
-		}
+// parseTopic parses a topic change.
+func parseTopic(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
 
-		// TODO: Parse modes
+	return &LogEntry{
+		Line:    line,
+		Time:    entryTime,
+		Type:    Topic,
+		Nick:    matches[3],
+		Channel: matches[4],
+		Text:    matches[5],
+	}, nil
+}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    ServerMode,
-			Channel: serverModeMatches[3],
-			Text:    serverModeMatches[4],
-			Nick:    serverModeMatches[5],
-		}, nil
+// parseKick parses a kick.
+func parseKick(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Notice to the channel
+	return &LogEntry{
+		Line:    line,
+		Time:    entryTime,
+		Type:    Kick,
+		Nick:    matches[3],
+		ByNick:  matches[5],
+		Channel: matches[4],
+		Text:    matches[6],
+	}, nil
+}
 
-	channelNoticeMatches := channelNoticePattern.FindStringSubmatch(line)
-	if channelNoticeMatches != nil {
-		entryTime, err := clockToTime(channelNoticeMatches[1],
-			channelNoticeMatches[2], currentDate, location)
-		if err != nil {
-			return nil, err
-		}
+// parsePart parses a part.
+func parsePart(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    ChannelNotice,
-			Nick:    channelNoticeMatches[3],
-			Text:    channelNoticeMatches[5],
-			Channel: channelNoticeMatches[4],
-		}, nil
+	return &LogEntry{
+		Line:     line,
+		Time:     entryTime,
+		Type:     Part,
+		Nick:     matches[3],
+		UserHost: matches[4],
+		Channel:  matches[5],
+		Text:     matches[6],
+	}, nil
+}
+
+// parseYourNick parses a change to your own nick.
+func parseYourNick(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Keepnick plugin line.
-	// Just ignore it.
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: YourNickChange,
+		Nick: matches[3],
+	}, nil
+}
 
-	if keepnickPattern.FindStringSubmatch(line) != nil {
-		return &LogEntry{Type: IgnoreThis}, nil
+// parseServerMode parses a mode change by a server.
+func parseServerMode(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Server notice
+	// TODO: Parse modes
 
-	serverNoticeMatches := serverNoticePattern.FindStringSubmatch(line)
-	if serverNoticeMatches != nil {
-		entryTime, err := clockToTime(serverNoticeMatches[1],
-			serverNoticeMatches[2], currentDate, location)
-		if err != nil {
-			return nil, err
-		}
+	return &LogEntry{
+		Line:    line,
+		Time:    entryTime,
+		Type:    ServerMode,
+		Channel: matches[3],
+		Text:    matches[4],
+		Nick:    matches[5],
+	}, nil
+}
 
-		return &LogEntry{
-			Line: line,
-			Time: entryTime,
-			Type: ServerNotice,
-			Nick: serverNoticeMatches[3],
-			Text: serverNoticeMatches[4],
-		}, nil
+// parseChannelNotice parses a notice to the channel.
+func parseChannelNotice(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	// Ban check: None
+	return &LogEntry{
+		Line:         line,
+		Time:         entryTime,
+		Type:         ChannelNotice,
+		Nick:         matches[3],
+		Text:         matches[6],
+		Channel:      matches[5],
+		NoticeTarget: matches[4],
+	}, nil
+}
 
-	bansNoneMatches := bansNonePattern.FindStringSubmatch(line)
-	if bansNoneMatches != nil {
-		entryTime, err := clockToTime(bansNoneMatches[1], bansNoneMatches[2],
-			currentDate, location)
-		if err != nil {
-			return nil, err
-		}
+// parseKeepnick handles a Keepnick plugin line.
+// Just ignore it. We keep its text so that it can be written back out.
+func parseKeepnick(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: IgnoreThis,
+		Text: matches[3],
+	}, nil
+}
+
+// parseServerNotice parses a server notice.
+func parseServerNotice(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogEntry{
+		Line: line,
+		Time: entryTime,
+		Type: ServerNotice,
+		Nick: matches[3],
+		Text: matches[4],
+	}, nil
+}
 
-		return &LogEntry{
-			Line:    line,
-			Time:    entryTime,
-			Type:    BansNone,
-			Channel: bansNoneMatches[3],
-		}, nil
+// parseBansNone parses a ban check with no bans.
+func parseBansNone(line string, matches []string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	entryTime, err := clockToTime(matches[1], matches[2], currentDate, location)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("Unrecognized line: %s", line)
+	return &LogEntry{
+		Line:    line,
+		Time:    entryTime,
+		Type:    BansNone,
+		Channel: matches[3],
+	}, nil
 }
 
 // clockToTime takes a timestamp like HH:MM and makes a time.Time type.
@@ -563,7 +865,7 @@ func clockToTime(hour string, minutes string, currentDate time.Time, location *t
 
 	m, err := strconv.Atoi(minutes)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("Unable to parse minute from timestamp: %s: %s", minutes[1], err.Error())
+		return time.Time{}, fmt.Errorf("Unable to parse minute from timestamp: %s: %s", minutes, err.Error())
 	}
 
 	entryTime := time.Date(currentDate.Year(), currentDate.Month(), currentDate.Day(), h, m, 0, 0, location)
@@ -0,0 +1,163 @@
+/*
+ * Evaluating a model on messages it wasn't built from.
+ *
+ * We measure how well the model predicts each word of the messages, and the
+ * end of each message, as perplexity: the exponential of the average negative
+ * log probability. Lower is better. A perplexity of n means the model was as
+ * unsure as if it picked from n equally likely words each time.
+ *
+ * Generating, the model only picks words that followed a state. Evaluating,
+ * we must give every word some probability, so we mix in what the states of
+ * fewer words predict, down to how often each word occurred at all. We use
+ * Witten-Bell smoothing: the more different words followed a state, the more
+ * likely it is that something new follows it, and the more we mix in.
+ *
+ * Words the model doesn't know at all are out of vocabulary. We count them
+ * separately rather than predicting them.
+ */
+
+package markov
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Evaluation holds how well a model predicted messages.
+type Evaluation struct {
+	// Words is how many words the messages had.
+	Words int
+
+	// OOV is how many of the words were out of vocabulary.
+	OOV int
+
+	// Tokens is how many words and message ends we predicted.
+	Tokens int
+
+	// LogProbability is the sum of the natural log of the probability of each
+	// token we predicted.
+	LogProbability float64
+}
+
+// Perplexity gives the perplexity per token.
+func (e *Evaluation) Perplexity() float64 {
+	if e.Tokens == 0 {
+		return math.NaN()
+	}
+	return math.Exp(-e.LogProbability / float64(e.Tokens))
+}
+
+// OOVRate gives the share of words that were out of vocabulary.
+func (e *Evaluation) OOVRate() float64 {
+	if e.Words == 0 {
+		return math.NaN()
+	}
+	return float64(e.OOV) / float64(e.Words)
+}
+
+// Evaluate measures how well states of up to k words predict messages.
+func (m *Model) Evaluate(messages [][]string, k int) (*Evaluation, error) {
+	if k < 1 || k > len(m.orders) {
+		return nil, fmt.Errorf("The model has states of 1 to %d words, not %d",
+			len(m.orders), k)
+	}
+
+	start, ok := m.ids[startToken]
+	if !ok {
+		return nil, fmt.Errorf("The model has no message boundaries. Build it again.")
+	}
+	end := m.ids[endToken]
+
+	unigrams, total := m.unigrams()
+	if total == 0 {
+		return nil, fmt.Errorf("The model has no messages")
+	}
+
+	e := &Evaluation{}
+
+	for _, message := range messages {
+		// context holds the words before the one we're predicting. It starts as
+		// the start tokens, and never holds an out of vocabulary word.
+		context := make([]int32, k)
+		for i := range context {
+			context[i] = start
+		}
+
+		for i := 0; i <= len(message); i++ {
+			id := end
+			if i < len(message) {
+				e.Words++
+
+				var ok bool
+				id, ok = m.ids[message[i]]
+				if !ok || id == start || id == end {
+					e.OOV++
+					context = context[:0]
+					continue
+				}
+			}
+
+			p := float64(unigrams[id]) / float64(total)
+			for j := 1; j <= k && j <= len(context); j++ {
+				p = m.orders[j-1].smooth(context[len(context)-j:], id, p)
+			}
+
+			e.Tokens++
+			e.LogProbability += math.Log(p)
+
+			context = append(context, id)
+			if len(context) > k {
+				context = context[1:]
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// unigrams counts how often each word followed any state of one word. That's
+// every time it occurred, since every word comes after a start token or
+// another word. It gives the counts by id, and their total.
+func (m *Model) unigrams() ([]uint64, uint64) {
+	counts := make([]uint64, len(m.words))
+	var total uint64
+
+	o := m.orders[0]
+	for i := 0; i < o.stateCount(); i++ {
+		var previous uint64
+		for j := o.starts[i]; j < o.starts[i+1]; j++ {
+			counts[o.next[j]] += o.cumulative[j] - previous
+			total += o.cumulative[j] - previous
+			previous = o.cumulative[j]
+		}
+	}
+
+	return counts, total
+}
+
+// smooth gives the probability of a word following a state, mixed with the
+// probability lower, from shorter states.
+func (o *order) smooth(state []int32, id int32, lower float64) float64 {
+	i := o.find(state)
+	if i == -1 {
+		return lower
+	}
+
+	start, end := o.starts[i], o.starts[i+1]
+	total := float64(o.cumulative[end-1])
+	distinct := float64(end - start)
+
+	next := o.next[start:end]
+	j := sort.Search(len(next), func(j int) bool { return next[j] >= id })
+
+	var count uint64
+	if j < len(next) && next[j] == id {
+		count = o.cumulative[start+j]
+		if j > 0 {
+			count -= o.cumulative[start+j-1]
+		}
+	}
+
+	return (float64(count) + distinct*lower) / (total + distinct)
+}
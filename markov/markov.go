@@ -0,0 +1,922 @@
+/*
+ * Package markov is a word level Markov model for generating text.
+ *
+ * The model counts which words follow each sequence of k words, the state. It
+ * holds counts for every k up to a maximum, so one model can generate with
+ * any of them.
+ *
+ * We model whole messages. Each message begins with k start tokens and finishes
+ * with an end token, so the model knows which words begin messages and which
+ * finish them. To generate, we start from the state of k start tokens and
+ * repeatedly pick a next word in proportion to how often it followed the
+ * state, until we pick the end token.
+ *
+ * Generating only uses the random source it is given, so the same model and
+ * seed give the same text.
+ *
+ * Messages are split into words by a tokenizer. The model keeps it so that
+ * we split text we generate from the same way.
+ *
+ * Build a model with a Builder. Models can be saved and loaded so they only
+ * need building once.
+ */
+
+package markov
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/tokenizer"
+)
+
+// The tokens marking the start and end of a message. Words never contain
+// spaces, so these can't be words.
+const (
+	startToken = " start"
+	endToken   = " end"
+)
+
+// maxAttempts is how many messages we try generating to find one of the length
+// we want.
+const maxAttempts = 1000
+
+// Model is a built Markov model. It doesn't change once built, so it is safe
+// to generate from it concurrently.
+type Model struct {
+	// words are the vocabulary. We refer to words by their index.
+	words []string
+	ids   map[string]int32
+
+	// orders holds the states of k words at index k-1.
+	orders []*order
+
+	// nicks are models of each person's messages, by their nick in IRC lower
+	// case. They share our vocabulary. Models of one nick have none.
+	nicks map[string]*Model
+
+	// For a nick's model, its name and the other nicks it used.
+	nick    string
+	aliases []string
+
+	// replies holds which keywords replies had. Models of one nick have none.
+	replies *replies
+
+	// tokenizer split the messages we built the model from into words.
+	tokenizer *tokenizer.Tokenizer
+}
+
+// order holds the states with the same number of words.
+type order struct {
+	k int
+
+	// states holds the words of each state, k at a time. States are sorted by
+	// their words' ids so we can search them.
+	states []int32
+
+	// The next words of state i, sorted by id, are next[starts[i]:starts[i+1]].
+	// cumulative is the running total of their counts within the state.
+	starts     []int
+	next       []int32
+	cumulative []uint64
+}
+
+// Builder counts transitions to build a model.
+type Builder struct {
+	maxOrder int
+
+	words []string
+	ids   map[string]int32
+
+	// all has the counts of every sequence.
+	all counts
+
+	// nicks has the counts of each nick's sequences, by the nick in IRC lower
+	// case. nickNames has the nick as we first saw it.
+	nicks     map[string]counts
+	nickNames map[string]string
+
+	// aliases groups nicks so one person's sequences go in one model.
+	aliases *irssi_log.Aliases
+
+	// replies counts keywords of replies, and turns tracks the messages they're
+	// replies to.
+	replies *replies
+	turns   turns
+
+	tokenizer *tokenizer.Tokenizer
+}
+
+// counts holds the transition counts of states of k words at index k-1.
+// States are keyed by their word ids.
+type counts []map[string]map[int32]uint64
+
+// NewBuilder starts a model with states of up to maxOrder words. We split
+// messages into words with the default tokenizer options.
+func NewBuilder(maxOrder int) *Builder {
+	return NewBuilderWithTokenizer(maxOrder, tokenizer.New(tokenizer.Options{}))
+}
+
+// NewBuilderWithTokenizer starts a model with states of up to maxOrder words.
+// We split messages into words with the tokenizer.
+func NewBuilderWithTokenizer(maxOrder int,
+	t *tokenizer.Tokenizer) *Builder {
+	b := &Builder{
+		maxOrder:  maxOrder,
+		ids:       map[string]int32{},
+		all:       newCounts(maxOrder),
+		nicks:     map[string]counts{},
+		nickNames: map[string]string{},
+		aliases:   irssi_log.NewAliases(),
+		replies:   newReplies(),
+		turns:     turns{byNick: map[string][]string{}},
+		tokenizer: t,
+	}
+
+	// The tokens are always in the vocabulary, even if there are no messages.
+	b.wordIDs([]string{startToken, endToken})
+
+	return b
+}
+
+func newCounts(maxOrder int) counts {
+	var c counts
+	for k := 1; k <= maxOrder; k++ {
+		c = append(c, map[string]map[int32]uint64{})
+	}
+	return c
+}
+
+// Add counts the transitions in the words of a message. The words should be
+// from the builder's tokenizer.
+func (b *Builder) Add(words []string) {
+	if len(words) == 0 {
+		return
+	}
+
+	b.all.add(b.message(words), 1)
+}
+
+// AddNick counts the transitions in the words of a message from a nick. They go
+// in both the nick's model and the model of everyone.
+func (b *Builder) AddNick(nick string, words []string) {
+	if len(words) == 0 {
+		return
+	}
+
+	ids := b.message(words)
+
+	b.all.add(ids, 1)
+
+	lower := irssi_log.IRCLower(nick)
+	c, exists := b.nicks[lower]
+	if !exists {
+		c = newCounts(b.maxOrder)
+		b.nicks[lower] = c
+		b.nickNames[lower] = nick
+	}
+	c.add(ids, 1)
+}
+
+// AddText counts the transitions in text such as from messages_to_string. Each
+// line is a message.
+func (b *Builder) AddText(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		b.Add(b.tokenizer.Tokens(line))
+	}
+}
+
+// AddEntries counts each message the reader gives, as AddMessage does. The
+// tokenizer learns the nicks from every entry before we count any messages, so
+// each is split into words the same way. messages_to_string does the same.
+//
+// We learn which nicks are the same person from the entries, such as from
+// nick changes, so one person's messages go in one model.
+func (b *Builder) AddEntries(reader irssi_log.EntryReader) error {
+	var messages []*irssi_log.LogEntry
+
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		b.aliases.Add(entry)
+
+		b.tokenizer.AddNick(entry.Nick)
+		if entry.Type == irssi_log.NickChange {
+			b.tokenizer.AddNick(entry.Text)
+		}
+
+		if entry.Type == irssi_log.Message {
+			messages = append(messages, entry)
+		}
+	}
+
+	for _, entry := range messages {
+		b.AddMessage(entry.Nick, entry.Text, entry.Time)
+	}
+
+	return nil
+}
+
+// SetSharedHosts sets user@hosts that more than one person may have. We don't
+// merge nicks by them. See Aliases.SharedHosts. Call it before AddEntries.
+func (b *Builder) SetSharedHosts(pattern *regexp.Regexp) {
+	b.aliases.SharedHosts = pattern
+}
+
+// MergeNicks records that two nicks are the same person, so their sequences go
+// in one model.
+func (b *Builder) MergeNicks(nick1, nick2 string) {
+	b.aliases.Merge(nick1, nick2)
+}
+
+// message gives the ids of a message's words, between its start and end
+// tokens. There are as many start tokens as the largest k.
+func (b *Builder) message(words []string) []int32 {
+	ids := make([]int32, 0, b.maxOrder+len(words)+1)
+	for i := 0; i < b.maxOrder; i++ {
+		ids = append(ids, b.ids[startToken])
+	}
+	ids = append(ids, b.wordIDs(words)...)
+	return append(ids, b.ids[endToken])
+}
+
+// wordIDs gives the ids of words, adding them to the vocabulary if needed.
+func (b *Builder) wordIDs(words []string) []int32 {
+	ids := make([]int32, len(words))
+	for i, word := range words {
+		id, exists := b.ids[word]
+		if !exists {
+			id = int32(len(b.words))
+			b.words = append(b.words, word)
+			b.ids[word] = id
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// add counts the transitions in a message n times. The message begins with
+// start tokens for the largest k. For smaller k we skip the extra ones.
+func (c counts) add(ids []int32, n uint64) {
+	for k := 1; k <= len(c); k++ {
+		for i := len(c) - k; i+k < len(ids); i++ {
+			c.addTransition(stateKey(ids[i:i+k]), ids[i+k], n)
+		}
+	}
+}
+
+// addTransition counts a word following a state n times.
+func (c counts) addTransition(key string, id int32, n uint64) {
+	transitions := c[len(key)/4-1]
+
+	next, exists := transitions[key]
+	if !exists {
+		next = map[int32]uint64{}
+		transitions[key] = next
+	}
+
+	next[id] += n
+}
+
+// Model builds the model from the counts so far.
+func (b *Builder) Model() *Model {
+	m := &Model{
+		words:     make([]string, len(b.words)),
+		ids:       make(map[string]int32, len(b.ids)),
+		orders:    b.all.orders(),
+		nicks:     map[string]*Model{},
+		replies:   b.replies.prune(),
+		tokenizer: tokenizer.New(b.tokenizer.Options()),
+	}
+
+	for _, nick := range b.tokenizer.Nicks() {
+		m.tokenizer.AddNick(nick)
+	}
+
+	copy(m.words, b.words)
+	for word, id := range b.ids {
+		m.ids[word] = id
+	}
+
+	// Group the nicks by person.
+	groups := map[string][]string{}
+	for lower, nick := range b.nickNames {
+		name := b.aliases.Canonical(nick)
+		groups[name] = append(groups[name], lower)
+	}
+
+	for name, members := range groups {
+		sort.Strings(members)
+
+		c := b.nicks[members[0]]
+		if len(members) > 1 {
+			c = newCounts(b.maxOrder)
+			for _, lower := range members {
+				for _, transitions := range b.nicks[lower] {
+					for key, next := range transitions {
+						for id, n := range next {
+							c.addTransition(key, id, n)
+						}
+					}
+				}
+			}
+		}
+
+		nickModel := &Model{
+			words:     m.words,
+			ids:       m.ids,
+			orders:    c.orders(),
+			nick:      name,
+			tokenizer: m.tokenizer,
+		}
+
+		for _, lower := range members {
+			if lower != irssi_log.IRCLower(name) {
+				nickModel.aliases = append(nickModel.aliases, b.nickNames[lower])
+			}
+		}
+
+		m.nicks[irssi_log.IRCLower(name)] = nickModel
+	}
+
+	return m
+}
+
+// orders builds the states from the counts.
+func (c counts) orders() []*order {
+	var orders []*order
+
+	for k := 1; k <= len(c); k++ {
+		transitions := c[k-1]
+
+		var keys []string
+		for key := range transitions {
+			keys = append(keys, key)
+		}
+
+		// The keys encode ids big endian, so sorting them sorts by ids.
+		sort.Strings(keys)
+
+		o := &order{k: k}
+
+		for _, key := range keys {
+			o.states = append(o.states, keyState(key)...)
+
+			next := transitions[key]
+			var ids []int32
+			for id := range next {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+			o.starts = append(o.starts, len(o.next))
+
+			var total uint64
+			for _, id := range ids {
+				total += next[id]
+				o.next = append(o.next, id)
+				o.cumulative = append(o.cumulative, total)
+			}
+		}
+		o.starts = append(o.starts, len(o.next))
+
+		orders = append(orders, o)
+	}
+
+	return orders
+}
+
+// MaxOrder gives the largest number of words in a state.
+func (m *Model) MaxOrder() int {
+	return len(m.orders)
+}
+
+// Nicks gives the nicks we have models of, sorted. Each is the name of a
+// person, the first nick we saw them use.
+func (m *Model) Nicks() []string {
+	var nicks []string
+	for _, nickModel := range m.nicks {
+		nicks = append(nicks, nickModel.nick)
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// Nick gives the model of a nick's messages. The nick may be any of the nicks
+// the person used. It gives nil if we have no model of them.
+func (m *Model) Nick(nick string) *Model {
+	lower := irssi_log.IRCLower(nick)
+
+	if nickModel, exists := m.nicks[lower]; exists {
+		return nickModel
+	}
+
+	for _, nickModel := range m.nicks {
+		for _, alias := range nickModel.aliases {
+			if irssi_log.IRCLower(alias) == lower {
+				return nickModel
+			}
+		}
+	}
+
+	return nil
+}
+
+// Tokenizer gives the tokenizer that split the messages we built the model
+// from. Split text to generate from with it.
+func (m *Model) Tokenizer() *tokenizer.Tokenizer {
+	return m.tokenizer
+}
+
+// Aliases gives the other nicks the person a nick model is of used.
+func (m *Model) Aliases() []string {
+	return m.aliases
+}
+
+// Options control generating a message.
+type Options struct {
+	// K is how many words are in the states we use.
+	K int
+
+	// MinLength and MaxLength are how many words the message may have.
+	MinLength int
+	MaxLength int
+
+	// Start are words the message begins with. We continue from them. Split
+	// them with the model's tokenizer.
+	Start []string
+
+	// Temperature changes how we pick words. Above 1, we pick rare words more
+	// often than they occurred. Below 1, we pick common words more often. 0 is
+	// the same as 1, picking in proportion to how often words occurred.
+	Temperature float64
+
+	// Topics makes us pick words more often by their keyword, as Keywords gives.
+	// We pick a word with a keyword with weight w 1+w times as often.
+	Topics map[string]float64
+
+	// MaxCopy, if above 0, is the longest run of words we may copy from the
+	// corpus. We can only tell what is in the corpus a few words at a time, so
+	// a run counts as copied if each group of words one longer than the
+	// largest k occurred. Runs of only the start words don't count.
+	MaxCopy int
+}
+
+// Generate generates a message.
+func (m *Model) Generate(rng *rand.Rand, options Options) ([]string, error) {
+	mix := &Mix{models: []*Model{m}, weights: []float64{1}}
+	return mix.Generate(rng, options)
+}
+
+// Generator generates text. Models and mixes of models are generators.
+type Generator interface {
+	Generate(rng *rand.Rand, options Options) ([]string, error)
+}
+
+// Mix generates text in the style of several nicks.
+type Mix struct {
+	models  []*Model
+	weights []float64
+}
+
+// Mix makes a mix of the models of the given nicks. The weights say how much
+// of each nick's style to use. They are relative, so they needn't add up to 1.
+func (m *Model) Mix(weights map[string]float64) (*Mix, error) {
+	var nicks []string
+	for nick := range weights {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+
+	mix := &Mix{}
+	for _, nick := range nicks {
+		if weights[nick] <= 0 {
+			return nil, fmt.Errorf("Weight must be positive: %s", nick)
+		}
+
+		nickModel := m.Nick(nick)
+		if nickModel == nil {
+			return nil, fmt.Errorf("No model of nick: %s", nick)
+		}
+
+		mix.models = append(mix.models, nickModel)
+		mix.weights = append(mix.weights, weights[nick])
+	}
+
+	if len(mix.models) == 0 {
+		return nil, fmt.Errorf("No nicks to mix")
+	}
+
+	return mix, nil
+}
+
+// Generate generates a message, as Model's Generate does.
+//
+// For each word we pick one of the nicks whose model has the current state, in
+// proportion to their weights. Then we pick the next word from their model.
+func (x *Mix) Generate(rng *rand.Rand, options Options) ([]string, error) {
+	k := options.K
+	for _, model := range x.models {
+		if k < 1 || k > len(model.orders) {
+			return nil, fmt.Errorf("The model has states of 1 to %d words, not %d",
+				len(model.orders), k)
+		}
+	}
+
+	if options.MinLength < 1 || options.MinLength > options.MaxLength {
+		return nil, fmt.Errorf("Invalid message length: %d to %d words",
+			options.MinLength, options.MaxLength)
+	}
+
+	if len(options.Start) > options.MaxLength {
+		return nil, fmt.Errorf("The start is longer than %d words",
+			options.MaxLength)
+	}
+
+	if options.Temperature < 0 {
+		return nil, fmt.Errorf("Invalid temperature: %f", options.Temperature)
+	}
+
+	words := x.models[0].words
+	ids := x.models[0].ids
+
+	start, ok := ids[startToken]
+	if !ok {
+		return nil, fmt.Errorf("The model has no message boundaries. Build it again.")
+	}
+
+	// The message so far, after k start tokens.
+	prefix := make([]int32, k, k+len(options.Start))
+	for i := range prefix {
+		prefix[i] = start
+	}
+
+	for _, word := range options.Start {
+		id, ok := ids[word]
+		if !ok || id == start || id == ids[endToken] {
+			return nil, fmt.Errorf("Word not in the model: %s", word)
+		}
+		prefix = append(prefix, id)
+	}
+
+	found := false
+	for _, model := range x.models {
+		if model.orders[k-1].find(prefix[len(prefix)-k:]) != -1 {
+			found = true
+		}
+	}
+	if !found {
+		if len(options.Start) == 0 {
+			return nil, fmt.Errorf("The model has no messages")
+		}
+		return nil, fmt.Errorf("Nothing in the model follows: %s",
+			strings.Join(options.Start, " "))
+	}
+
+	weight := x.weight(options)
+
+	for i := 0; i < maxAttempts; i++ {
+		message, ok := x.generate(rng, prefix, options, weight)
+		if !ok {
+			continue
+		}
+
+		generated := make([]string, len(message))
+		for j, id := range message {
+			generated[j] = words[id]
+		}
+		return generated, nil
+	}
+
+	return nil, fmt.Errorf("Unable to generate a message of %d to %d words",
+		options.MinLength, options.MaxLength)
+}
+
+// generate tries to generate a message continuing from the prefix. It gives
+// false if the message wasn't one we want.
+func (x *Mix) generate(rng *rand.Rand, prefix []int32, options Options,
+	weight func(int32, uint64) float64) ([]int32, bool) {
+	k := options.K
+	end := x.models[0].ids[endToken]
+
+	message := make([]int32, len(prefix)-k, options.MaxLength)
+	copy(message, prefix[k:])
+
+	state := make([]int32, k)
+	copy(state, prefix[len(prefix)-k:])
+
+	for {
+		// Until the message is long enough, it can't end.
+		exclude := int32(-1)
+		if len(message) < options.MinLength {
+			exclude = end
+		}
+
+		o := x.pickOrder(rng, state)
+		if o == nil {
+			return nil, false
+		}
+
+		next, ok := o.pick(state, rng, exclude, weight)
+		if !ok {
+			return nil, false
+		}
+
+		if next == end {
+			break
+		}
+
+		if len(message) == options.MaxLength {
+			return nil, false
+		}
+
+		message = append(message, next)
+		state = append(state[1:], next)
+	}
+
+	if options.MaxCopy > 0 &&
+		x.copyLength(message, len(options.Start)) > options.MaxCopy {
+		return nil, false
+	}
+
+	return message, true
+}
+
+// weight gives how to weigh the words that could come next, by their id and
+// how often they followed the state. It gives nil to weigh them by how often
+// they did.
+func (x *Mix) weight(options Options) func(int32, uint64) float64 {
+	temperature := options.Temperature
+	if temperature == 0 {
+		temperature = 1
+	}
+
+	if temperature == 1 && len(options.Topics) == 0 {
+		return nil
+	}
+
+	words := x.models[0].words
+	boosts := map[int32]float64{}
+
+	return func(id int32, count uint64) float64 {
+		weight := float64(count)
+		if temperature != 1 {
+			weight = math.Pow(weight, 1/temperature)
+		}
+
+		if len(options.Topics) > 0 {
+			boost, exists := boosts[id]
+			if !exists {
+				boost = 1 + options.Topics[keyword(words[id])]
+				boosts[id] = boost
+			}
+			weight *= boost
+		}
+
+		return weight
+	}
+}
+
+// copyLength gives the longest run of words in a message that we can tell
+// occurred in the corpus. We leave out runs of only the first skip words.
+//
+// We know the groups of up to the largest k plus one words that occurred. A
+// longer run counts if each of its groups of that many words occurred.
+func (x *Mix) copyLength(message []int32, skip int) int {
+	longest := len(x.models[0].orders) + 1
+
+	// run is the length of the run ending at the current word.
+	run := 0
+	best := 0
+
+	for i := range message {
+		n := run + 1
+		if n > longest {
+			n = longest
+		}
+
+		extends := true
+		for n > 1 && !x.occurred(message[i-n+1:i+1]) {
+			n--
+			extends = false
+		}
+
+		if extends {
+			run++
+		} else {
+			run = n
+		}
+
+		if i >= skip && run > best {
+			best = run
+		}
+	}
+
+	return best
+}
+
+// occurred decides whether any of the models saw a group of words.
+func (x *Mix) occurred(ids []int32) bool {
+	state, next := ids[:len(ids)-1], ids[len(ids)-1]
+
+	for _, model := range x.models {
+		if model.orders[len(state)-1].follows(state, next) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pickOrder picks one of the models with the state in proportion to their
+// weights, and gives its states of the state's length. It gives nil if none
+// have the state.
+func (x *Mix) pickOrder(rng *rand.Rand, state []int32) *order {
+	var orders []*order
+	var weights []float64
+	total := 0.0
+
+	for i, model := range x.models {
+		o := model.orders[len(state)-1]
+		if o.find(state) == -1 {
+			continue
+		}
+
+		orders = append(orders, o)
+		weights = append(weights, x.weights[i])
+		total += x.weights[i]
+	}
+
+	if len(orders) == 0 {
+		return nil
+	}
+
+	r := rng.Float64() * total
+	for i, weight := range weights {
+		if r < weight {
+			return orders[i]
+		}
+		r -= weight
+	}
+	return orders[len(orders)-1]
+}
+
+// stateCount gives how many states there are.
+func (o *order) stateCount() int {
+	return len(o.starts) - 1
+}
+
+// find gives the index of a state, or -1 if there is no such state.
+func (o *order) find(state []int32) int {
+	n := o.stateCount()
+	i := sort.Search(n, func(i int) bool {
+		return compareIDs(o.states[i*o.k:(i+1)*o.k], state) >= 0
+	})
+
+	if i == n || compareIDs(o.states[i*o.k:(i+1)*o.k], state) != 0 {
+		return -1
+	}
+	return i
+}
+
+// follows decides whether a word followed a state.
+func (o *order) follows(state []int32, id int32) bool {
+	i := o.find(state)
+	if i == -1 {
+		return false
+	}
+
+	next := o.next[o.starts[i]:o.starts[i+1]]
+	j := sort.Search(len(next), func(j int) bool { return next[j] >= id })
+	return j < len(next) && next[j] == id
+}
+
+// pick picks a word to follow a state. We pick in proportion to how often each
+// word followed it, or to the weight if we have one. We never pick the word
+// with id exclude. It gives false if nothing else followed the state.
+func (o *order) pick(state []int32, rng *rand.Rand, exclude int32,
+	weight func(int32, uint64) float64) (int32, bool) {
+	i := o.find(state)
+	if i == -1 {
+		return 0, false
+	}
+
+	start, end := o.starts[i], o.starts[i+1]
+	next := o.next[start:end]
+	cumulative := o.cumulative[start:end]
+
+	if weight != nil {
+		return pickWeighted(next, cumulative, rng, exclude, weight)
+	}
+
+	// Leave out the excluded word's count. Its count is the gap between its
+	// running total and the one before it.
+	j := sort.Search(len(next), func(j int) bool { return next[j] >= exclude })
+	var excludeFrom, excludeCount uint64
+	if j < len(next) && next[j] == exclude {
+		if j > 0 {
+			excludeFrom = cumulative[j-1]
+		}
+		excludeCount = cumulative[j] - excludeFrom
+	}
+
+	total := cumulative[len(cumulative)-1] - excludeCount
+	if total == 0 {
+		return 0, false
+	}
+
+	r := uint64(rng.Int63n(int64(total)))
+	if excludeCount > 0 && r >= excludeFrom {
+		r += excludeCount
+	}
+
+	j = sort.Search(len(cumulative), func(j int) bool {
+		return cumulative[j] > r
+	})
+
+	return next[j], true
+}
+
+// pickWeighted picks one of the next words in proportion to their weights.
+func pickWeighted(next []int32, cumulative []uint64, rng *rand.Rand,
+	exclude int32, weight func(int32, uint64) float64) (int32, bool) {
+	weights := make([]float64, len(next))
+	total := 0.0
+	var previous uint64
+
+	for i, id := range next {
+		count := cumulative[i] - previous
+		previous = cumulative[i]
+
+		if id == exclude {
+			continue
+		}
+
+		weights[i] = weight(id, count)
+		total += weights[i]
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	r := rng.Float64() * total
+	last := -1
+	for i, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		if r < weight {
+			return next[i], true
+		}
+		r -= weight
+		last = i
+	}
+
+	// Rounding can leave a little over. It belongs to the last word.
+	return next[last], true
+}
+
+// stateKey encodes ids as a map key.
+func stateKey(ids []int32) string {
+	buf := make([]byte, 4*len(ids))
+	for i, id := range ids {
+		binary.BigEndian.PutUint32(buf[4*i:], uint32(id))
+	}
+	return string(buf)
+}
+
+// keyState decodes a key from stateKey.
+func keyState(key string) []int32 {
+	ids := make([]int32, len(key)/4)
+	for i := range ids {
+		ids[i] = int32(binary.BigEndian.Uint32([]byte(key[4*i : 4*i+4])))
+	}
+	return ids
+}
+
+// compareIDs compares two states by their ids.
+func compareIDs(a, b []int32) int {
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
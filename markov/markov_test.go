@@ -0,0 +1,733 @@
+package markov
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/tokenizer"
+)
+
+func TestGenerate(t *testing.T) {
+	b := NewBuilder(2)
+	b.AddText("x y x y x y\nz")
+	m := b.Model()
+
+	rng := rand.New(rand.NewSource(1))
+
+	for k := 1; k <= 2; k++ {
+		for i := 0; i < 100; i++ {
+			words, err := m.Generate(rng, Options{K: k, MinLength: 1,
+				MaxLength: 10})
+			if err != nil {
+				t.Fatalf("Unable to generate: %s", err.Error())
+			}
+
+			text := strings.Join(words, " ")
+			if text == "z" {
+				continue
+			}
+
+			// Other messages start with x, alternate, and end with y.
+			if !strings.HasPrefix(text, "x y") || !strings.HasSuffix(text, "x y") ||
+				strings.Contains(text, "y y") || strings.Contains(text, "x x") {
+				t.Errorf("Generated %q with k %d", text, k)
+			}
+		}
+	}
+
+	// Long enough messages can't be z, and with k 1 must end part way through
+	// some loop of x and y.
+	for i := 0; i < 100; i++ {
+		words, err := m.Generate(rng, Options{K: 1, MinLength: 3, MaxLength: 4})
+		if err != nil {
+			t.Fatalf("Unable to generate: %s", err.Error())
+		}
+
+		text := strings.Join(words, " ")
+		if text != "x y x y" {
+			t.Errorf("Generated %q with a length of 3 to 4", text)
+		}
+	}
+
+	// Messages other than z have an even number of words.
+	_, err := m.Generate(rng, Options{K: 1, MinLength: 3, MaxLength: 3})
+	if err == nil {
+		t.Errorf("Generated a message of 3 words")
+	}
+
+	_, err = m.Generate(rng, Options{K: 3, MinLength: 1, MaxLength: 10})
+	if err == nil {
+		t.Errorf("Generated with a larger k than the model has")
+	}
+
+	_, err = m.Generate(rng, Options{K: 1, MinLength: 5, MaxLength: 4})
+	if err == nil {
+		t.Errorf("Generated with a minimum length larger than the maximum")
+	}
+
+	_, err = NewBuilder(2).Model().Generate(rng, Options{K: 1, MinLength: 1,
+		MaxLength: 10})
+	if err == nil {
+		t.Errorf("Generated from an empty model")
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	b := NewBuilder(2)
+	b.AddText("the cat sat on the mat\nthe dog sat on the log\n" +
+		"a cat ate the fish\nthe dog ate a bone")
+	m := b.Model()
+
+	// The same seed gives the same messages.
+	options := Options{K: 1, MinLength: 1, MaxLength: 10}
+	var first []string
+	for i := 0; i < 2; i++ {
+		rng := rand.New(rand.NewSource(42))
+
+		var messages []string
+		for j := 0; j < 20; j++ {
+			words, err := m.Generate(rng, options)
+			if err != nil {
+				t.Fatalf("Unable to generate: %s", err.Error())
+			}
+			messages = append(messages, strings.Join(words, " "))
+		}
+
+		if first == nil {
+			first = messages
+			continue
+		}
+
+		if !reflect.DeepEqual(messages, first) {
+			t.Errorf("Generated %q, then %q with the same seed", first, messages)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+
+	// Messages begin with the start words.
+	for i := 0; i < 50; i++ {
+		words, err := m.Generate(rng, Options{K: 2, MinLength: 1, MaxLength: 10,
+			Start: []string{"the", "dog"}})
+		if err != nil {
+			t.Fatalf("Unable to generate: %s", err.Error())
+		}
+
+		text := strings.Join(words, " ")
+		if text != "the dog sat on the mat" && text != "the dog sat on the log" &&
+			text != "the dog ate the fish" && text != "the dog ate a bone" {
+			t.Errorf("Generated %q starting with the dog", text)
+		}
+	}
+
+	_, err := m.Generate(rng, Options{K: 1, MinLength: 1, MaxLength: 10,
+		Start: []string{"the", "cow"}})
+	if err == nil {
+		t.Errorf("Generated starting with a word not in the model")
+	}
+
+	_, err = m.Generate(rng, Options{K: 2, MinLength: 1, MaxLength: 10,
+		Start: []string{"bone", "the"}})
+	if err == nil {
+		t.Errorf("Generated starting with words nothing follows")
+	}
+
+	// With runs of at most 3 copied words, and k 1 letting us know about runs
+	// of 2, no message may contain 3 words in a row we saw, then one more.
+	for i := 0; i < 50; i++ {
+		words, err := m.Generate(rng, Options{K: 1, MinLength: 1, MaxLength: 10,
+			MaxCopy: 3})
+		if err != nil {
+			t.Fatalf("Unable to generate: %s", err.Error())
+		}
+
+		text := strings.Join(words, " ")
+		for _, copied := range []string{"the cat sat on", "the dog sat on",
+			"cat sat on the", "dog sat on the", "sat on the mat", "sat on the log",
+			"a cat ate the", "cat ate the fish", "the dog ate a", "dog ate a bone"} {
+			if strings.Contains(text, copied) {
+				t.Errorf("Generated %q, copying %q", text, copied)
+			}
+		}
+	}
+
+	_, err = m.Generate(rng, Options{K: 1, MinLength: 1, MaxLength: 10,
+		MaxCopy: 1})
+	if err == nil {
+		t.Errorf("Generated without copying any 2 words in a row")
+	}
+}
+
+func TestPickTemperature(t *testing.T) {
+	b := NewBuilder(1)
+	for i := 0; i < 9; i++ {
+		b.Add([]string{"say", "bee"})
+	}
+	b.Add([]string{"say", "cat"})
+	m := b.Model()
+
+	o := m.orders[0]
+	state := []int32{m.ids["say"]}
+
+	mix := &Mix{models: []*Model{m}, weights: []float64{1}}
+
+	picks := func(options Options) int {
+		weight := mix.weight(options)
+
+		rng := rand.New(rand.NewSource(1))
+		count := 0
+		for i := 0; i < 4000; i++ {
+			next, ok := o.pick(state, rng, -1, weight)
+			if !ok {
+				t.Fatalf("Nothing follows say")
+			}
+			if m.words[next] == "cat" {
+				count++
+			}
+		}
+		return count
+	}
+
+	// cat follows say 1 time in 10. With a temperature of 2, that's 1 to 3.
+	if count := picks(Options{Temperature: 1}); count < 300 || count > 500 {
+		t.Errorf("Picked cat %d times at temperature 1", count)
+	}
+	if count := picks(Options{Temperature: 2}); count < 850 || count > 1150 {
+		t.Errorf("Picked cat %d times at temperature 2", count)
+	}
+	if count := picks(Options{Temperature: 0.25}); count > 10 {
+		t.Errorf("Picked cat %d times at temperature 0.25", count)
+	}
+
+	// A weight of 8 for cat makes it 9 times as likely, as likely as bee.
+	if count := picks(Options{Topics: map[string]float64{"cat": 8}}); count < 1800 ||
+		count > 2200 {
+		t.Errorf("Picked cat %d times with cat as a topic", count)
+	}
+}
+
+func TestGenerateWeights(t *testing.T) {
+	b := NewBuilder(1)
+	for i := 0; i < 3; i++ {
+		b.Add([]string{"a", "b"})
+	}
+	b.Add([]string{"a", "c"})
+	m := b.Model()
+
+	rng := rand.New(rand.NewSource(1))
+
+	o := m.orders[0]
+	state := []int32{m.ids["a"]}
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		next, ok := o.pick(state, rng, -1, nil)
+		if !ok {
+			t.Fatalf("Nothing follows a")
+		}
+		counts[m.words[next]]++
+	}
+
+	// b follows a 3 times as often as c.
+	if counts["b"] < 2800 || counts["b"] > 3200 || counts["c"] < 800 ||
+		counts["c"] > 1200 {
+		t.Errorf("Picked %v", counts)
+	}
+
+	// Leaving out b, we only pick c.
+	for i := 0; i < 100; i++ {
+		next, ok := o.pick(state, rng, m.ids["b"], nil)
+		if !ok || m.words[next] != "c" {
+			t.Fatalf("Picked %q leaving out b", m.words[next])
+		}
+	}
+
+	// Only the end of the message followed b.
+	if _, ok := o.pick([]int32{m.ids["b"]}, rng, m.ids[endToken], nil); ok {
+		t.Errorf("Picked a word to follow b")
+	}
+}
+
+func TestAddEntries(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	fh, err := os.Open(filepath.Join("..", "testdata", "sample.log"))
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	b := NewBuilder(1)
+	err = b.AddEntries(irssi_log.NewReader(fh, irssi_log.NewParser(location)))
+	if err != nil {
+		t.Fatalf("Unable to add entries: %s", err.Error())
+	}
+	m := b.Model()
+
+	o := m.orders[0]
+
+	// "hi alice" is followed by "hello bob, ..." in the next message.
+	if next := following(m, o, "alice"); !reflect.DeepEqual(next,
+		[]string{endToken}) {
+		t.Errorf("alice is followed by %q", next)
+	}
+
+	if next := following(m, o, "hello"); !reflect.DeepEqual(next,
+		[]string{"bob,"}) {
+		t.Errorf("hello is followed by %q", next)
+	}
+
+	if next := following(m, o, startToken); len(next) == 0 ||
+		!contains(next, "hi") || contains(next, "alice") {
+		t.Errorf("Messages start with %q", next)
+	}
+
+	// URLs are dropped.
+	if _, exists := m.ids["https://example.com/deploy"]; exists {
+		t.Errorf("URL in vocabulary")
+	}
+
+	// Emotes are not messages.
+	if _, exists := m.ids["waves"]; exists {
+		t.Errorf("Emote in vocabulary")
+	}
+}
+
+func TestNicks(t *testing.T) {
+	b := NewBuilder(1)
+	b.AddNick("alice", []string{"a", "b"})
+	b.AddNick("bob", []string{"c", "d"})
+	b.AddNick("Bob_", []string{"c", "e"})
+	b.AddNick("carol", []string{"f", "g"})
+	b.MergeNicks("bob", "bob_")
+	m := b.Model()
+
+	if nicks := m.Nicks(); !reflect.DeepEqual(nicks,
+		[]string{"alice", "bob", "carol"}) {
+		t.Errorf("Nicks %q", nicks)
+	}
+
+	bob := m.Nick("BOB_")
+	if bob == nil {
+		t.Fatalf("No model of bob")
+	}
+	if bob != m.Nick("bob") {
+		t.Errorf("bob and Bob_ have different models")
+	}
+	if aliases := bob.Aliases(); !reflect.DeepEqual(aliases,
+		[]string{"Bob_"}) {
+		t.Errorf("bob's aliases %q", aliases)
+	}
+
+	// bob's model has what bob said as both nicks and nothing else.
+	o := bob.orders[0]
+	if next := following(m, o, "c"); !reflect.DeepEqual(next,
+		[]string{"d", "e"}) || o.stateCount() != 4 {
+		t.Errorf("bob's model has %d states, with c followed by %q",
+			o.stateCount(), next)
+	}
+
+	// The model of everyone has everything: the start and every word.
+	if count := m.orders[0].stateCount(); count != 8 {
+		t.Errorf("The model has %d states", count)
+	}
+
+	if m.Nick("dave") != nil {
+		t.Errorf("Model of an unknown nick")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+
+	mix, err := m.Mix(map[string]float64{"alice": 1, "Bob_": 3})
+	if err != nil {
+		t.Fatalf("Unable to mix: %s", err.Error())
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		words, err := mix.Generate(rng, Options{K: 1, MinLength: 1, MaxLength: 2})
+		if err != nil {
+			t.Fatalf("Unable to generate: %s", err.Error())
+		}
+		counts[words[0]]++
+	}
+
+	// We start with a word of bob's 3 times as often as one of alice's, and
+	// never one of carol's.
+	bobCount := counts["c"]
+	if counts["a"] < 800 || counts["a"] > 1200 || bobCount < 2800 ||
+		bobCount > 3200 || counts["f"] != 0 {
+		t.Errorf("Started with %v", counts)
+	}
+
+	_, err = m.Mix(map[string]float64{"dave": 1})
+	if err == nil {
+		t.Errorf("Mixed an unknown nick")
+	}
+
+	_, err = m.Mix(map[string]float64{"alice": 0})
+	if err == nil {
+		t.Errorf("Mixed with a weight of 0")
+	}
+}
+
+func TestAddEntriesNicks(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	log := `--- Log opened Mon Mar 05 09:00:00 2018
+09:00 -!- bob [~bob@example.com] has joined #test
+09:01 < bob> hello there
+09:02 -!- bob is now known as bobby
+09:03 < bobby> hello again
+09:04 -!- bobby [~bob@example.com] has quit [Quit: bye]
+09:05 -!- robert [~bob@example.com] has joined #test
+09:06 < robert> hello world
+09:07 < carol> hello carol
+`
+
+	b := NewBuilder(1)
+	err = b.AddEntries(irssi_log.NewReader(strings.NewReader(log),
+		irssi_log.NewParser(location)))
+	if err != nil {
+		t.Fatalf("Unable to add entries: %s", err.Error())
+	}
+	m := b.Model()
+
+	if nicks := m.Nicks(); !reflect.DeepEqual(nicks,
+		[]string{"bob", "carol"}) {
+		t.Fatalf("Nicks %q", nicks)
+	}
+
+	bob := m.Nick("robert")
+	if aliases := bob.Aliases(); !reflect.DeepEqual(aliases,
+		[]string{"bobby", "robert"}) {
+		t.Errorf("bob's aliases %q", aliases)
+	}
+
+	i := bob.orders[0].find([]int32{m.ids["hello"]})
+	if i == -1 {
+		t.Fatalf("bob never said hello")
+	}
+	if n := bob.orders[0].starts[i+1] - bob.orders[0].starts[i]; n != 3 {
+		t.Errorf("bob said %d words after hello", n)
+	}
+}
+
+func TestReplies(t *testing.T) {
+	b := NewBuilder(1)
+
+	when := time.Date(2018, 3, 5, 9, 0, 0, 0, time.UTC)
+	say := func(nick, text string) {
+		b.AddMessage(nick, text, when)
+		when = when.Add(time.Minute)
+	}
+
+	// Twice, questions about the deploy get a reply about the build.
+	say("alice", "how is the deploy going?")
+	say("bob", "the build is broken")
+	say("alice", "did the deploy finish?")
+	say("carol", "something else")
+	say("bob", "alice: build failed again")
+
+	// Lunch gets pizza.
+	say("alice", "lunch time")
+	say("bob", "pizza!")
+	say("carol", "lunch anyone?")
+	say("dave", "pizza place")
+
+	// Too long after, this isn't a reply.
+	when = when.Add(time.Hour)
+	say("bob", "build")
+
+	m := b.Model()
+
+	if m.replies.total != 8 {
+		t.Errorf("Counted %d replies", m.replies.total)
+	}
+
+	// bob's reply to alice's question addressed her.
+	if n := m.replies.pairs["deploy"]["build"]; n != 2 {
+		t.Errorf("deploy was followed by build %d times", n)
+	}
+
+	// Seen once, we drop it.
+	if _, exists := m.replies.pairs["deploy"]["broken"]; exists {
+		t.Errorf("Kept a pair seen once")
+	}
+
+	topics := m.replies.topics(Keywords(strings.Fields(
+		"how about that deploy?")))
+	if len(topics) != 1 || math.Abs(topics["build"]-topicBoost) > 1e-9 {
+		t.Errorf("Topics %v", topics)
+	}
+
+	topics = m.replies.topics(Keywords([]string{"lunch?"}))
+	if len(topics) != 1 || math.Abs(topics["pizza"]-topicBoost) > 1e-9 {
+		t.Errorf("Topics %v", topics)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	options := Options{K: 1, MinLength: 1, MaxLength: 10}
+
+	// Replies to lunch are mostly about pizza, where messages are not.
+	pizza := 0
+	for i := 0; i < 100; i++ {
+		words, err := m.Reply(rng, m, "lunch?", options)
+		if err != nil {
+			t.Fatalf("Unable to reply: %s", err.Error())
+		}
+
+		if contains(Keywords(words), "pizza") {
+			pizza++
+		}
+	}
+
+	if pizza < 90 {
+		t.Errorf("Replied about pizza %d times", pizza)
+	}
+
+	// Knowing nothing about the prompt, we still reply.
+	_, err := m.Reply(rng, m, "hmm", options)
+	if err != nil {
+		t.Fatalf("Unable to reply: %s", err.Error())
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	b := NewBuilder(2)
+	b.Add([]string{"a", "b"})
+	m := b.Model()
+
+	// Each of a, b, and the end occurred once, so each has a probability of 1/3
+	// on its own. Each state had 1 word follow it once, so we mix that half and
+	// half with the 1/3: 2/3.
+	e, err := m.Evaluate([][]string{{"a", "b"}}, 1)
+	if err != nil {
+		t.Fatalf("Unable to evaluate: %s", err.Error())
+	}
+
+	if e.Words != 2 || e.OOV != 0 || e.Tokens != 3 ||
+		math.Abs(e.Perplexity()-1.5) > 1e-9 {
+		t.Errorf("Evaluated %+v, perplexity %f", e, e.Perplexity())
+	}
+
+	// After the unknown word, we know nothing about what comes next, so b has a
+	// probability of 1/3.
+	e, err = m.Evaluate([][]string{{"a", "zzz", "b"}}, 1)
+	if err != nil {
+		t.Fatalf("Unable to evaluate: %s", err.Error())
+	}
+
+	want := math.Pow(2.0/3*1.0/3*2.0/3, -1.0/3)
+	if e.Words != 3 || e.OOV != 1 || e.Tokens != 3 ||
+		math.Abs(e.OOVRate()-1.0/3) > 1e-9 ||
+		math.Abs(e.Perplexity()-want) > 1e-9 {
+		t.Errorf("Evaluated %+v, perplexity %f, wanted %f", e, e.Perplexity(),
+			want)
+	}
+
+	// Longer states predict better.
+	b = NewBuilder(2)
+	b.AddText("the cat sat on the mat\nthe dog sat on the log\n" +
+		"the cat ate the fish")
+	m = b.Model()
+
+	messages := [][]string{{"the", "cat", "sat", "on", "the", "log"}}
+
+	e1, err := m.Evaluate(messages, 1)
+	if err != nil {
+		t.Fatalf("Unable to evaluate: %s", err.Error())
+	}
+
+	e2, err := m.Evaluate(messages, 2)
+	if err != nil {
+		t.Fatalf("Unable to evaluate: %s", err.Error())
+	}
+
+	if e2.Perplexity() >= e1.Perplexity() {
+		t.Errorf("Perplexity %f with k 2, %f with k 1", e2.Perplexity(),
+			e1.Perplexity())
+	}
+
+	_, err = m.Evaluate(messages, 3)
+	if err == nil {
+		t.Errorf("Evaluated with a larger k than the model has")
+	}
+}
+
+func TestTokenizer(t *testing.T) {
+	options, err := tokenizer.ParseOptions("lowercase,punctuation,nicks")
+	if err != nil {
+		t.Fatalf("Unable to parse options: %s", err.Error())
+	}
+
+	tok := tokenizer.New(options)
+	tok.AddNick("alice")
+	tok.AddNick("bob")
+
+	b := NewBuilderWithTokenizer(1, tok)
+	b.AddMessage("alice", "Hello, Bob!", time.Time{})
+	b.AddMessage("bob", "hi ALICE", time.Time{})
+	b.AddText("Bob says HELLO")
+	m := b.Model()
+
+	var words []string
+	for _, word := range m.words {
+		if word != startToken && word != endToken {
+			words = append(words, word)
+		}
+	}
+
+	// We knew bob was a nick before he spoke.
+	want := []string{"hello", ",", tokenizer.NickToken, "!", "hi", "says"}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("Words %q, wanted %q", words, want)
+	}
+
+	if tokens := m.Tokenizer().Tokens("BOB?"); !reflect.DeepEqual(tokens,
+		[]string{tokenizer.NickToken, "?"}) {
+		t.Errorf("Tokenized as %q", tokens)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	options, err := tokenizer.ParseOptions("lowercase,nicks,urls=replace")
+	if err != nil {
+		t.Fatalf("Unable to parse options: %s", err.Error())
+	}
+
+	tok := tokenizer.New(options)
+	tok.AddNick("carol")
+	tok.AddNick("dave")
+
+	b := NewBuilderWithTokenizer(3, tok)
+	b.AddText("The cat sat on the mat and the cat ate the rat")
+	b.Add([]string{"the", "dog", "sat"})
+	b.AddNick("alice", []string{"the", "cat", "ran"})
+	b.AddNick("alice_", []string{"a", "cat", "sat"})
+	b.AddNick("bob", []string{"the", "dog", "ran"})
+	b.MergeNicks("alice", "alice_")
+	for i := 0; i < 2; i++ {
+		b.AddMessage("carol", "where is the cat", time.Time{})
+		b.AddMessage("dave", "the cat sat on the mat", time.Time{})
+	}
+	m := b.Model()
+
+	file := filepath.Join(dir, "model")
+	err = m.Save(file)
+	if err != nil {
+		t.Fatalf("Unable to save: %s", err.Error())
+	}
+
+	loaded, err := Load(file)
+	if err != nil {
+		t.Fatalf("Unable to load: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("Loaded %+v, wanted %+v", loaded, m)
+	}
+
+	for k := 1; k <= 3; k++ {
+		options := Options{K: k, MinLength: 1, MaxLength: 20}
+
+		words, err := m.Generate(rand.New(rand.NewSource(1)), options)
+		if err != nil {
+			t.Fatalf("Unable to generate: %s", err.Error())
+		}
+
+		loadedWords, err := loaded.Generate(rand.New(rand.NewSource(1)), options)
+		if err != nil {
+			t.Fatalf("Unable to generate: %s", err.Error())
+		}
+
+		if !reflect.DeepEqual(words, loadedWords) {
+			t.Errorf("Generated %q from the loaded model, wanted %q", loadedWords,
+				words)
+		}
+	}
+
+	if loaded.Nick("alice_") == nil || loaded.Nick("bob") == nil {
+		t.Errorf("Loaded model is missing nicks")
+	}
+
+	if tokens := loaded.Tokenizer().Tokens("Carol saw The https://x"); !reflect.DeepEqual(
+		tokens, []string{tokenizer.NickToken, "saw", "the", tokenizer.URLToken}) {
+		t.Errorf("Loaded model tokenized as %q", tokens)
+	}
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	err = ioutil.WriteFile(file, buf[:len(buf)-1], 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	_, err = Load(file)
+	if err == nil {
+		t.Errorf("Loaded a truncated model")
+	}
+
+	// We only load the current version.
+	buf[len(modelMagic)] = modelVersion + 1
+
+	err = ioutil.WriteFile(file, buf, 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	_, err = Load(file)
+	if err == nil || !strings.Contains(err.Error(), "Unsupported model version") {
+		t.Errorf("Loaded a model of another version: %v", err)
+	}
+}
+
+// following gives the words that followed a one word state.
+func following(m *Model, o *order, word string) []string {
+	i := o.find([]int32{m.ids[word]})
+	if i == -1 {
+		return nil
+	}
+
+	var words []string
+	for _, id := range o.next[o.starts[i]:o.starts[i+1]] {
+		words = append(words, m.words[id])
+	}
+	return words
+}
+
+func contains(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
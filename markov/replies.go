@@ -0,0 +1,309 @@
+/*
+ * Replies: which messages follow which.
+ *
+ * We take a message to be a reply to another when it addresses the other's
+ * nick ("alice: ..."), in which case it replies to their last message, or else
+ * when it comes right after a message from someone else. For each pair we
+ * count which keywords of the reply went with which keywords of the message
+ * before it.
+ *
+ * To reply to a prompt, we score the keywords replies had, adding up over the
+ * prompt's keywords. A reply keyword's score is the share of messages with the
+ * prompt keyword whose replies had it, weighted by how rare it is in replies
+ * overall. Generating, we pick words with high scoring keywords more often.
+ */
+
+package markov
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/tokenizer"
+)
+
+// replyWindow is how soon after a message the next one must come to be a
+// reply to it, if it doesn't address anyone.
+const replyWindow = 5 * time.Minute
+
+// minPairs is how often a reply keyword must go with a keyword for us to keep
+// it. Pairs seen once are mostly chance.
+const minPairs = 2
+
+// maxTopics is how many of a prompt's reply keywords we use.
+const maxTopics = 10
+
+// topicBoost is how much more often we pick a word with the best scoring
+// keyword. Words with lower scoring keywords get less.
+const topicBoost = 20
+
+// replyCandidates is how many messages we generate to pick a reply from.
+const replyCandidates = 10
+
+// replies holds the keyword counts.
+type replies struct {
+	// total is how many pairs of a message and its reply there were.
+	total uint64
+
+	// prompts is how many messages that were replied to had each keyword.
+	prompts map[string]uint64
+
+	// documents is how many replies had each keyword.
+	documents map[string]uint64
+
+	// pairs counts, for each keyword of a message replied to, the keywords of
+	// its replies.
+	pairs map[string]map[string]uint64
+}
+
+// turns tracks the messages we've seen to find what each is a reply to.
+type turns struct {
+	// The nick, time, and keywords of the last message.
+	lastNick     string
+	lastTime     time.Time
+	lastKeywords []string
+
+	// byNick has the keywords of the last message of each nick, by the nick in
+	// IRC lower case.
+	byNick map[string][]string
+}
+
+func newReplies() *replies {
+	return &replies{
+		prompts:   map[string]uint64{},
+		documents: map[string]uint64{},
+		pairs:     map[string]map[string]uint64{},
+	}
+}
+
+// AddMessage counts a message from a nick, as AddNick does. It also counts it
+// as a reply to the message it follows, as the package comment on replies
+// describes. Add messages in the order they were said. If we don't know when
+// the message was said, pass the zero time.
+//
+// Add every nick to the tokenizer before adding messages. Otherwise messages
+// added before we knew a nick are split into words differently than later
+// ones.
+func (b *Builder) AddMessage(nick, text string, when time.Time) {
+	words := b.tokenizer.Tokens(text)
+	b.AddNick(nick, words)
+
+	lower := irssi_log.IRCLower(nick)
+
+	// If the message addresses a nick we know, it replies to their last message.
+	// Otherwise the first word is just a word.
+	address, rest := addressed(text)
+	previous, exists := b.turns.byNick[irssi_log.IRCLower(address)]
+
+	keywords := Keywords(words)
+
+	var prompt []string
+	if exists && irssi_log.IRCLower(address) != lower {
+		prompt = previous
+		keywords = Keywords(b.tokenizer.Tokens(rest))
+	} else if len(b.turns.lastNick) > 0 &&
+		irssi_log.IRCLower(b.turns.lastNick) != lower &&
+		(when.IsZero() || when.Sub(b.turns.lastTime) <= replyWindow) {
+		prompt = b.turns.lastKeywords
+	}
+
+	if len(prompt) > 0 && len(keywords) > 0 {
+		b.replies.add(prompt, keywords)
+	}
+
+	b.turns.lastNick = nick
+	b.turns.lastTime = when
+	b.turns.lastKeywords = keywords
+	b.turns.byNick[lower] = keywords
+}
+
+// add counts a pair of a message and its reply by their keywords.
+func (r *replies) add(prompt, reply []string) {
+	r.total++
+
+	for _, keyword := range reply {
+		r.documents[keyword]++
+	}
+
+	for _, keyword := range prompt {
+		r.prompts[keyword]++
+
+		counts, exists := r.pairs[keyword]
+		if !exists {
+			counts = map[string]uint64{}
+			r.pairs[keyword] = counts
+		}
+
+		for _, replyKeyword := range reply {
+			counts[replyKeyword]++
+		}
+	}
+}
+
+// prune gives a copy of the counts without pairs seen less than minPairs
+// times.
+func (r *replies) prune() *replies {
+	pruned := newReplies()
+	pruned.total = r.total
+
+	for keyword, counts := range r.pairs {
+		for replyKeyword, n := range counts {
+			if n < minPairs {
+				continue
+			}
+
+			kept, exists := pruned.pairs[keyword]
+			if !exists {
+				kept = map[string]uint64{}
+				pruned.pairs[keyword] = kept
+				pruned.prompts[keyword] = r.prompts[keyword]
+			}
+			kept[replyKeyword] = n
+			pruned.documents[replyKeyword] = r.documents[replyKeyword]
+		}
+	}
+
+	return pruned
+}
+
+// topics scores the keywords of replies to a prompt, given the prompt's
+// keywords. It gives the best maxTopics of them, with the best having a weight
+// of topicBoost.
+func (r *replies) topics(keywords []string) map[string]float64 {
+	scores := map[string]float64{}
+	for _, keyword := range keywords {
+		for replyKeyword, n := range r.pairs[keyword] {
+			idf := math.Log(float64(r.total) / float64(r.documents[replyKeyword]))
+			scores[replyKeyword] += float64(n) / float64(r.prompts[keyword]) * idf
+		}
+	}
+
+	var best []string
+	for keyword, score := range scores {
+		if score > 0 {
+			best = append(best, keyword)
+		}
+	}
+
+	sort.Slice(best, func(i, j int) bool {
+		if scores[best[i]] != scores[best[j]] {
+			return scores[best[i]] > scores[best[j]]
+		}
+		return best[i] < best[j]
+	})
+
+	if len(best) > maxTopics {
+		best = best[:maxTopics]
+	}
+
+	topics := map[string]float64{}
+	for _, keyword := range best {
+		topics[keyword] = topicBoost * (scores[keyword] / scores[best[0]])
+	}
+	return topics
+}
+
+// Reply generates a reply to a prompt using a generator of this model. We
+// generate several messages, preferring words about what replies to messages
+// like the prompt were about, and give the one most about it.
+//
+// If we know nothing about replies to the prompt, this is the same as
+// generating a message.
+func (m *Model) Reply(rng *rand.Rand, generator Generator, prompt string,
+	options Options) ([]string, error) {
+	if m.replies == nil {
+		return generator.Generate(rng, options)
+	}
+
+	// Prompts often address us. That tells us nothing.
+	_, rest := addressed(prompt)
+
+	topics := m.replies.topics(Keywords(m.tokenizer.Tokens(rest)))
+	if len(topics) == 0 {
+		return generator.Generate(rng, options)
+	}
+
+	options.Topics = topics
+
+	var best []string
+	bestScore := -1.0
+
+	for i := 0; i < replyCandidates; i++ {
+		words, err := generator.Generate(rng, options)
+		if err != nil {
+			return nil, err
+		}
+
+		score := 0.0
+		for _, keyword := range Keywords(words) {
+			score += topics[keyword]
+		}
+
+		if score > bestScore {
+			best = words
+			bestScore = score
+		}
+	}
+
+	return best, nil
+}
+
+// addressed splits a message into who it may address and the rest of its
+// text. If its first word doesn't end in a colon or comma, it addresses no one
+// and the rest is all of its text.
+func addressed(text string) (string, string) {
+	words := strings.Fields(text)
+
+	if len(words) > 0 && len(words[0]) > 1 &&
+		strings.ContainsAny(words[0][len(words[0])-1:], ":,") {
+		return words[0][:len(words[0])-1], strings.Join(words[1:], " ")
+	}
+
+	return "", text
+}
+
+// Keywords gives the distinct keywords of words: the words in lower case
+// without punctuation around them. Words shorter than 3 letters are mostly too
+// common to tell us anything, so we leave them out.
+func Keywords(words []string) []string {
+	seen := map[string]struct{}{}
+
+	var keywords []string
+	for _, word := range words {
+		k := keyword(word)
+		if len(k) == 0 {
+			continue
+		}
+
+		if _, exists := seen[k]; exists {
+			continue
+		}
+		seen[k] = struct{}{}
+
+		keywords = append(keywords, k)
+	}
+
+	return keywords
+}
+
+// keyword gives the keyword of a word, or a blank string if it has none.
+// Placeholders have none.
+func keyword(word string) string {
+	if tokenizer.IsPlaceholder(word) {
+		return ""
+	}
+
+	k := strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}))
+
+	if len([]rune(k)) < 3 {
+		return ""
+	}
+	return k
+}
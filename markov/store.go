@@ -0,0 +1,482 @@
+/*
+ * Saving a model to disk.
+ *
+ * The file is:
+ *
+ *   magic, "IRSSIMKV"
+ *   version: uvarint
+ *   word count: uvarint
+ *   for each word: length: uvarint, then the word's bytes
+ *   the orders of the model of everyone
+ *   nick count: uvarint
+ *   for each nick, sorted:
+ *     length: uvarint, then the nick's bytes
+ *     alias count: uvarint
+ *     for each alias: length: uvarint, then the alias's bytes
+ *     the orders of the nick's model
+ *   reply count: uvarint
+ *   prompt keyword count: uvarint
+ *   for each prompt keyword, sorted:
+ *     length: uvarint, then the keyword's bytes
+ *     how many messages replied to had it: uvarint
+ *     reply keyword count: uvarint
+ *     for each reply keyword, sorted:
+ *       length: uvarint, then the keyword's bytes
+ *       how many replies to messages with the prompt keyword had it: uvarint
+ *   reply keyword count: uvarint
+ *   for each reply keyword, sorted:
+ *     length: uvarint, then the keyword's bytes
+ *     how many replies had it: uvarint
+ *   tokenizer options, each a uvarint: lowercase, separate punctuation, URL
+ *     mode, replace nicks, replace numbers, strip address. Flags are 1 if set.
+ *   tokenizer nick count: uvarint
+ *   for each nick, sorted: length: uvarint, then the nick's bytes
+ *
+ * Orders are:
+ *
+ *   order count: uvarint
+ *   for each order, from 1 word states up:
+ *     state count: uvarint
+ *     for each state, in sorted order:
+ *       the id of each of its words: uvarint
+ *       next word count: uvarint
+ *       for each next word, in order of id:
+ *         id minus the previous next word's id: uvarint
+ *         count: uvarint
+ *
+ * We only read files of the current version. If the format changes, bump the
+ * version and rebuild the model.
+ */
+
+package markov
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/tokenizer"
+)
+
+const modelMagic = "IRSSIMKV"
+
+const modelVersion = 1
+
+// Save writes the model to a file.
+func (m *Model) Save(file string) error {
+	tmpFile := file + ".tmp"
+
+	fh, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("Unable to open file: %s: %s", tmpFile, err.Error())
+	}
+
+	err = m.write(fh)
+	if err != nil {
+		_ = fh.Close()
+		_ = os.Remove(tmpFile)
+		return err
+	}
+
+	err = fh.Close()
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return fmt.Errorf("Unable to close file: %s: %s", tmpFile, err.Error())
+	}
+
+	err = os.Rename(tmpFile, file)
+	if err != nil {
+		return fmt.Errorf("Unable to rename file: %s: %s", tmpFile, err.Error())
+	}
+
+	return nil
+}
+
+// write writes the file's contents.
+func (m *Model) write(fh *os.File) error {
+	w := &encoder{writer: bufio.NewWriter(fh)}
+
+	_, w.err = w.writer.WriteString(modelMagic)
+	w.uvarint(modelVersion)
+
+	w.uvarint(uint64(len(m.words)))
+	for _, word := range m.words {
+		w.string(word)
+	}
+
+	w.orders(m.orders)
+
+	w.uvarint(uint64(len(m.nicks)))
+	for _, nick := range m.Nicks() {
+		nickModel := m.Nick(nick)
+
+		w.string(nickModel.nick)
+		w.uvarint(uint64(len(nickModel.aliases)))
+		for _, alias := range nickModel.aliases {
+			w.string(alias)
+		}
+
+		w.orders(nickModel.orders)
+	}
+
+	w.replies(m.replies)
+
+	w.tokenizer(m.tokenizer)
+
+	if w.err != nil {
+		return fmt.Errorf("Unable to write: %s", w.err.Error())
+	}
+
+	err := w.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Load reads a model written by Save.
+func Load(file string) (*Model, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open file: %s: %s", file, err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	m, err := read(bufio.NewReader(fh))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load model: %s: %s", file, err.Error())
+	}
+
+	return m, nil
+}
+
+// read decodes a model.
+func read(reader *bufio.Reader) (*Model, error) {
+	magic := make([]byte, len(modelMagic))
+	_, err := io.ReadFull(reader, magic)
+	if err != nil || string(magic) != modelMagic {
+		return nil, fmt.Errorf("Not a model file")
+	}
+
+	r := &decoder{reader: reader}
+
+	version := r.uvarint()
+	if r.err == nil && version != modelVersion {
+		return nil, fmt.Errorf("Unsupported model version: %d", version)
+	}
+
+	m := &Model{ids: map[string]int32{}}
+
+	wordCount := r.uvarint()
+	if wordCount > math.MaxInt32 {
+		return nil, fmt.Errorf("Too many words: %d", wordCount)
+	}
+
+	for i := uint64(0); i < wordCount && r.err == nil; i++ {
+		word := r.bytes(r.uvarint())
+		m.ids[word] = int32(len(m.words))
+		m.words = append(m.words, word)
+	}
+
+	m.orders = r.orders(len(m.words))
+
+	m.nicks = map[string]*Model{}
+
+	nickCount := r.uvarint()
+	for i := uint64(0); i < nickCount && r.err == nil; i++ {
+		nickModel := &Model{
+			words: m.words,
+			ids:   m.ids,
+			nick:  r.bytes(r.uvarint()),
+		}
+
+		aliasCount := r.uvarint()
+		for j := uint64(0); j < aliasCount && r.err == nil; j++ {
+			nickModel.aliases = append(nickModel.aliases, r.bytes(r.uvarint()))
+		}
+
+		nickModel.orders = r.orders(len(m.words))
+
+		m.nicks[irssi_log.IRCLower(nickModel.nick)] = nickModel
+	}
+
+	m.replies = newReplies()
+	r.replies(m.replies)
+
+	m.tokenizer = r.tokenizer()
+
+	for _, nickModel := range m.nicks {
+		nickModel.tokenizer = m.tokenizer
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return m, nil
+}
+
+// encoder writes numbers. After an error it does nothing, and keeps the first
+// error.
+type encoder struct {
+	writer *bufio.Writer
+	err    error
+}
+
+func (w *encoder) uvarint(v uint64) {
+	if w.err != nil {
+		return
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	_, w.err = w.writer.Write(buf[:n])
+}
+
+func (w *encoder) string(s string) {
+	w.uvarint(uint64(len(s)))
+	if w.err == nil {
+		_, w.err = w.writer.WriteString(s)
+	}
+}
+
+// orders writes a model's orders.
+func (w *encoder) orders(orders []*order) {
+	w.uvarint(uint64(len(orders)))
+	for _, o := range orders {
+		w.uvarint(uint64(o.stateCount()))
+
+		for i := 0; i < o.stateCount(); i++ {
+			for _, id := range o.states[i*o.k : (i+1)*o.k] {
+				w.uvarint(uint64(id))
+			}
+
+			start, end := o.starts[i], o.starts[i+1]
+			w.uvarint(uint64(end - start))
+
+			var previousID int32
+			var previousTotal uint64
+			for j := start; j < end; j++ {
+				w.uvarint(uint64(o.next[j] - previousID))
+				w.uvarint(o.cumulative[j] - previousTotal)
+				previousID = o.next[j]
+				previousTotal = o.cumulative[j]
+			}
+		}
+	}
+}
+
+// replies writes the counts of reply keywords.
+func (w *encoder) replies(r *replies) {
+	w.uvarint(r.total)
+
+	w.uvarint(uint64(len(r.pairs)))
+	for _, keyword := range sortedKeys(r.prompts) {
+		w.string(keyword)
+		w.uvarint(r.prompts[keyword])
+
+		counts := r.pairs[keyword]
+		w.uvarint(uint64(len(counts)))
+		for _, replyKeyword := range sortedKeys(counts) {
+			w.string(replyKeyword)
+			w.uvarint(counts[replyKeyword])
+		}
+	}
+
+	w.uvarint(uint64(len(r.documents)))
+	for _, keyword := range sortedKeys(r.documents) {
+		w.string(keyword)
+		w.uvarint(r.documents[keyword])
+	}
+}
+
+// tokenizer writes a tokenizer's options and nicks.
+func (w *encoder) tokenizer(t *tokenizer.Tokenizer) {
+	options := t.Options()
+	w.flag(options.Lowercase)
+	w.flag(options.SeparatePunctuation)
+	w.uvarint(uint64(options.URLs))
+	w.flag(options.ReplaceNicks)
+	w.flag(options.ReplaceNumbers)
+	w.flag(options.StripAddress)
+
+	nicks := t.Nicks()
+	w.uvarint(uint64(len(nicks)))
+	for _, nick := range nicks {
+		w.string(nick)
+	}
+}
+
+func (w *encoder) flag(b bool) {
+	if b {
+		w.uvarint(1)
+	} else {
+		w.uvarint(0)
+	}
+}
+
+// sortedKeys gives a map's keys, sorted.
+func sortedKeys(m map[string]uint64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decoder reads numbers. After an error, it returns zero values and keeps the
+// first error.
+type decoder struct {
+	reader *bufio.Reader
+	err    error
+}
+
+func (r *decoder) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+		return 0
+	}
+	return v
+}
+
+func (r *decoder) bytes(length uint64) string {
+	if r.err != nil {
+		return ""
+	}
+
+	// Read a piece at a time so a corrupt length can't have us allocate a huge
+	// amount.
+	var buf []byte
+	for uint64(len(buf)) < length {
+		chunk := length - uint64(len(buf))
+		if chunk > 4096 {
+			chunk = 4096
+		}
+
+		piece := make([]byte, chunk)
+		_, err := io.ReadFull(r.reader, piece)
+		if err != nil {
+			r.err = io.ErrUnexpectedEOF
+			return ""
+		}
+		buf = append(buf, piece...)
+	}
+
+	return string(buf)
+}
+
+// orders reads a model's orders. wordCount is the size of the vocabulary.
+func (r *decoder) orders(wordCount int) []*order {
+	// Checks an id read from the file is a word.
+	wordID := func() int32 {
+		id := r.uvarint()
+		if r.err == nil && id >= uint64(wordCount) {
+			r.err = fmt.Errorf("Invalid word id: %d", id)
+		}
+		return int32(id)
+	}
+
+	var orders []*order
+
+	orderCount := r.uvarint()
+	for k := 1; uint64(k) <= orderCount && r.err == nil; k++ {
+		o := &order{k: k}
+
+		stateCount := r.uvarint()
+
+		for i := uint64(0); i < stateCount && r.err == nil; i++ {
+			for j := 0; j < k; j++ {
+				o.states = append(o.states, wordID())
+			}
+
+			nextCount := r.uvarint()
+			if r.err == nil && nextCount == 0 {
+				r.err = fmt.Errorf("State with no next words")
+			}
+
+			o.starts = append(o.starts, len(o.next))
+
+			var id int32
+			var total uint64
+			for j := uint64(0); j < nextCount && r.err == nil; j++ {
+				id += int32(r.uvarint())
+				if r.err == nil && int(id) >= wordCount {
+					r.err = fmt.Errorf("Invalid word id: %d", id)
+				}
+				total += r.uvarint()
+
+				o.next = append(o.next, id)
+				o.cumulative = append(o.cumulative, total)
+			}
+		}
+		o.starts = append(o.starts, len(o.next))
+
+		orders = append(orders, o)
+	}
+
+	return orders
+}
+
+// replies reads the counts of reply keywords.
+func (r *decoder) replies(replies *replies) {
+	replies.total = r.uvarint()
+
+	promptCount := r.uvarint()
+	for i := uint64(0); i < promptCount && r.err == nil; i++ {
+		keyword := r.bytes(r.uvarint())
+		replies.prompts[keyword] = r.uvarint()
+
+		counts := map[string]uint64{}
+		replies.pairs[keyword] = counts
+
+		replyCount := r.uvarint()
+		for j := uint64(0); j < replyCount && r.err == nil; j++ {
+			replyKeyword := r.bytes(r.uvarint())
+			counts[replyKeyword] = r.uvarint()
+		}
+	}
+
+	documentCount := r.uvarint()
+	for i := uint64(0); i < documentCount && r.err == nil; i++ {
+		keyword := r.bytes(r.uvarint())
+		replies.documents[keyword] = r.uvarint()
+	}
+}
+
+// tokenizer reads a tokenizer's options and nicks.
+func (r *decoder) tokenizer() *tokenizer.Tokenizer {
+	options := tokenizer.Options{
+		Lowercase:           r.uvarint() == 1,
+		SeparatePunctuation: r.uvarint() == 1,
+		URLs:                tokenizer.URLMode(r.uvarint()),
+		ReplaceNicks:        r.uvarint() == 1,
+		ReplaceNumbers:      r.uvarint() == 1,
+		StripAddress:        r.uvarint() == 1,
+	}
+
+	t := tokenizer.New(options)
+
+	nickCount := r.uvarint()
+	for i := uint64(0); i < nickCount && r.err == nil; i++ {
+		t.AddNick(r.bytes(r.uvarint()))
+	}
+
+	return t
+}
@@ -1,9 +1,21 @@
 /*
  * This program takes an Irssi channel log and extracts the message text.
  *
- * It appends them all together and writes them to a file.
+ * It writes them to a file, one message per line, so that where each message
+ * starts and ends is kept.
  *
  * This is to make generating random text from the messages quicker.
+ *
+ * We split each message into words with a tokenizer. By default we split on
+ * white space and leave out URLs. -tokenize changes this. Build argot's model
+ * with the same options.
+ *
+ * With -nicks, each line is instead the nick who said the message, a tab, and
+ * the text. This is so argot can imitate particular nicks. A person
+ * who used several nicks has all of their messages under one, the first we saw
+ * them use. We tell which nicks go together by nick changes and by nicks having
+ * the same user@host. Some user@hosts are shared by different people, such as
+ * those of webchat gateways. -shared-hosts leaves those out.
  */
 
 package main
@@ -18,15 +30,17 @@ import (
 	"time"
 
 	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/tokenizer"
 )
 
-var urlPattern = regexp.MustCompile("https?:")
-
 func main() {
 	logFile := flag.String("log-file", "", "Path to a log file to read.")
 	outFile := flag.String("out-file", "", "Path to file to write.")
 	lineLimit := flag.Int("line-limit", 0, "Limit number of lines to read. 0 for entire log.")
 	locationString := flag.String("location", "America/Vancouver", "Time zone location.")
+	nicks := flag.Bool("nicks", false, "Write one message per line with the nick who said it.")
+	sharedHosts := flag.String("shared-hosts", "", "With -nicks, don't merge nicks by user@hosts matching this regular expression, such as those of webchat gateways or shared bouncers that more than one person uses. Use . to merge nicks only by nick changes.")
+	tokenize := flag.String("tokenize", "", "How to split messages into words. Comma separated tokenizer options: lowercase, punctuation (split punctuation off words), urls=drop, urls=keep, urls=replace, nicks (replace nicks with a placeholder), numbers (replace numbers with a placeholder), strip-address (leave out the nick a message addresses).")
 
 	flag.Parse()
 
@@ -48,6 +62,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	tokenizerOptions, err := tokenizer.ParseOptions(*tokenize)
+	if err != nil {
+		log.Print(err.Error())
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var sharedHostsRE *regexp.Regexp
+	if len(*sharedHosts) > 0 {
+		sharedHostsRE, err = regexp.Compile(*sharedHosts)
+		if err != nil {
+			log.Printf("Invalid shared hosts pattern: %s", err.Error())
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
 	if len(*locationString) == 0 {
 		log.Print("You must specify a location.")
 		flag.PrintDefaults()
@@ -82,58 +113,69 @@ func main() {
 	}
 	defer ofh.Close()
 
-	err = writeMessages(ofh, entries)
+	messages := irssi_log.FilterEntries(entries,
+		irssi_log.HasType(irssi_log.Message))
+
+	tok := tokenizer.New(tokenizerOptions)
+	for _, entry := range entries {
+		tok.AddNick(entry.Nick)
+		if entry.Type == irssi_log.NickChange {
+			tok.AddNick(entry.Text)
+		}
+	}
+
+	var aliases *irssi_log.Aliases
+	if *nicks {
+		aliases = irssi_log.NewAliases()
+		aliases.SharedHosts = sharedHostsRE
+		for _, entry := range entries {
+			aliases.Add(entry)
+		}
+	}
+
+	err = writeMessages(ofh, messages, tok, aliases)
 	if err != nil {
-		log.Printf(err.Error())
+		log.Print(err.Error())
 		os.Exit(1)
 	}
 
 	log.Printf("Done!")
 }
 
-// writeMessages takes the message text and writes them all out to a file.
-func writeMessages(fh *os.File, entries []*irssi_log.LogEntry) error {
+// writeMessages writes the text of each message on its own line. If we have
+// aliases, we prefix each line with the name of the person who said it and a
+// tab.
+func writeMessages(fh *os.File, entries []*irssi_log.LogEntry,
+	tok *tokenizer.Tokenizer, aliases *irssi_log.Aliases) error {
 	writer := bufio.NewWriter(fh)
 	defer writer.Flush()
 
-	first := true
-
 	for _, entry := range entries {
-		if entry.Type != irssi_log.Message {
+		words := messageWords(entry, tok)
+		if len(words) == 0 {
 			continue
 		}
 
-		if strings.HasPrefix(entry.Text, " ") {
-			continue
+		line := strings.Join(words, " ") + "\n"
+		if aliases != nil {
+			line = aliases.Canonical(entry.Nick) + "\t" + line
 		}
 
-		words := strings.Split(entry.Text, " ")
-
-		for _, word := range words {
-			wordTrim := strings.TrimSpace(word)
-			if len(wordTrim) == 0 {
-				continue
-			}
-
-			if urlPattern.MatchString(word) {
-				continue
-			}
-
-			if !first {
-				_, err := writer.WriteString(" ")
-				if err != nil {
-					return err
-				}
-			}
-
-			_, err := writer.WriteString(word)
-			if err != nil {
-				return err
-			}
-
-			first = false
+		_, err := writer.WriteString(line)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// messageWords gives the words of a message we want to keep. We drop messages
+// starting with a space.
+func messageWords(entry *irssi_log.LogEntry, tok *tokenizer.Tokenizer) []string {
+	if strings.HasPrefix(entry.Text, " ") {
+		return nil
+	}
+
+	return tok.Tokens(entry.Text)
+}
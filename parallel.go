@@ -0,0 +1,136 @@
+/*
+ * Parse large logs using several goroutines.
+ */
+
+package irssi_log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// chunksPerWorker is how many pieces we aim to split the log into for each
+// worker. Having more pieces than workers evens out the work when some days
+// are much busier than others.
+const chunksPerWorker = 4
+
+// logChunk is a piece of a log. It starts at a line that sets the date (or at
+// the start of the log), so it can be parsed without looking at any earlier
+// lines.
+type logChunk struct {
+	offset     int64
+	size       int64
+	lineNumber int
+
+	entries []*LogEntry
+	err     error
+}
+
+// ParseLogParallel parses a log using several goroutines. It gives the same
+// result as ParseLog with no line limit.
+//
+// We split the log at LogOpen and DayChange lines since each piece then has
+// what it needs to know the date. If workers is not positive we use one
+// worker per CPU.
+func ParseLogParallel(file *os.File, location *time.Location, workers int) (
+	[]*LogEntry, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to stat file: %s", err.Error())
+	}
+
+	index, err := BuildDayIndex(file, location)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := splitLog(index.Boundaries, fi.Size(),
+		fi.Size()/int64(workers*chunksPerWorker))
+
+	chunkChan := make(chan *logChunk)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunkChan {
+				chunk.entries, chunk.err = parseChunk(file, location, chunk)
+			}
+		}()
+	}
+
+	for _, chunk := range chunks {
+		chunkChan <- chunk
+	}
+	close(chunkChan)
+
+	wg.Wait()
+
+	count := 0
+	for _, chunk := range chunks {
+		if chunk.err != nil {
+			return nil, chunk.err
+		}
+		count += len(chunk.entries)
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	entries := make([]*LogEntry, 0, count)
+	for _, chunk := range chunks {
+		entries = append(entries, chunk.entries...)
+	}
+
+	return entries, nil
+}
+
+// splitLog divides a log into chunks of at least chunkSize bytes, where it
+// can. Chunks start at boundaries. The first starts at the start of the log
+// and the last goes to the end of it.
+func splitLog(boundaries []DayBoundary, logSize, chunkSize int64) []*logChunk {
+	chunks := []*logChunk{&logChunk{}}
+
+	for _, boundary := range boundaries {
+		current := chunks[len(chunks)-1]
+		if boundary.Offset-current.offset < chunkSize ||
+			boundary.Offset == current.offset {
+			continue
+		}
+
+		current.size = boundary.Offset - current.offset
+
+		chunks = append(chunks, &logChunk{
+			offset:     boundary.Offset,
+			lineNumber: boundary.LineNumber - 1,
+		})
+	}
+
+	last := chunks[len(chunks)-1]
+	last.size = logSize - last.offset
+
+	return chunks
+}
+
+// parseChunk parses the lines in one chunk of a log.
+func parseChunk(file *os.File, location *time.Location,
+	chunk *logChunk) ([]*LogEntry, error) {
+	parser := NewParser(location)
+	parser.lineNumber = chunk.lineNumber
+	parser.offset = chunk.offset
+
+	reader := NewReader(io.NewSectionReader(file, chunk.offset, chunk.size),
+		parser)
+
+	return readEntries(reader, 0)
+}
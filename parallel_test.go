@@ -0,0 +1,77 @@
+package irssi_log
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogParallel(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "sample.log"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// Lines before the first boundary have no date. The last line has no
+	// newline.
+	bigContent := "15:04 <@bob> before we knew the date\n" +
+		strings.Repeat(string(content), 50) +
+		"10:04 <@bob> no newline"
+
+	logs := []string{"", string(content), bigContent}
+
+	for i, logContent := range logs {
+		filename := filepath.Join(dir, "test.log")
+		err := ioutil.WriteFile(filename, []byte(logContent), 0644)
+		if err != nil {
+			t.Fatalf("Unable to write file: %s", err.Error())
+		}
+
+		wanted := parseFile(t, filename, location)
+
+		for workers := 1; workers <= 8; workers++ {
+			fh, err := os.Open(filename)
+			if err != nil {
+				t.Fatalf("Unable to open file: %s", err.Error())
+			}
+
+			entries, err := ParseLogParallel(fh, location, workers)
+			_ = fh.Close()
+			if err != nil {
+				t.Errorf("Log %d with %d workers: Unable to parse: %s", i, workers,
+					err.Error())
+				continue
+			}
+
+			if len(entries) != len(wanted) {
+				t.Errorf("Log %d with %d workers: Got %d entries, wanted %d", i,
+					workers, len(entries), len(wanted))
+				continue
+			}
+
+			for j := range entries {
+				if !entriesEqual(entries[j], wanted[j]) {
+					t.Errorf("Log %d with %d workers: Entry %d is %+v, wanted %+v", i,
+						workers, j, entries[j], wanted[j])
+					break
+				}
+			}
+		}
+	}
+}
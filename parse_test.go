@@ -2,11 +2,45 @@ package irssi_log
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
 
+// TestCase is a line and what it should parse to.
+type TestCase struct {
+	Line  string
+	Entry LogEntry
+	Error error
+}
+
 func TestParseLine(t *testing.T) {
+	location, currentDate, cases := parseLineTestCases(t)
+
+	for _, testCase := range cases {
+		entry, err := ParseLine(testCase.Line, location, currentDate)
+		if err != nil {
+			if testCase.Error != nil {
+				continue
+			}
+			t.Errorf("Test case with line [%s] failed: %s", testCase.Line, err.Error())
+			continue
+		}
+
+		if !entryMatches(t, entry, testCase.Entry) {
+			continue
+		}
+	}
+}
+
+// parseLineTestCases gives lines of each type along with how they should
+// parse. It also gives the location and date to parse them with.
+func parseLineTestCases(t *testing.T) (*time.Location, time.Time,
+	[]TestCase) {
 	location, err := time.LoadLocation("America/Vancouver")
 	if err != nil {
 		t.Fatalf("Invalid location: %s", err.Error())
@@ -20,12 +54,6 @@ func TestParseLine(t *testing.T) {
 
 	currentDateZeroSecs := currentDate.Truncate(time.Minute)
 
-	type TestCase struct {
-		Line  string
-		Entry LogEntry
-		Error error
-	}
-
 	cases := []TestCase{
 		TestCase{
 			Line:  "test",
@@ -57,6 +85,7 @@ func TestParseLine(t *testing.T) {
 				Time:    currentDateZeroSecs,
 				Type:    ChannelSummary,
 				Channel: "#channel",
+				Text:    "Total of 5 nicks [4 ops, 0 halfops, 0 voices, 1 normal]",
 			},
 			Error: nil,
 		},
@@ -67,43 +96,186 @@ func TestParseLine(t *testing.T) {
 				Type:    Mode,
 				Channel: "#channel",
 				Nick:    "nick2",
+				Text:    "+o nick1",
 			},
 			Error: nil,
 		},
 
-		// Channel sync
-		// Channel message
-		// Quit
-		// Nick change
-		// Day change
-		// Log closed
-		// Now talking in
-		// Channel emote
-		// Topic change
-		// Kick
-		// Part
-		// Your nick change
-		// Server changed mode
-		// Channel notice
-		// Keepnick
-		// Server notice
-		// Ban check none
+		TestCase{
+			Line: "15:04 -!- Irssi: Join to #channel was synced in 1 secs",
+			Entry: LogEntry{
+				Time:        currentDateZeroSecs,
+				Type:        JoinSync,
+				Channel:     "#channel",
+				SyncSeconds: 1,
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 <@nick> hi there",
+			Entry: LogEntry{
+				Time:     currentDateZeroSecs,
+				Type:     Message,
+				Nick:     "nick",
+				NickMode: "@",
+				Text:     "hi there",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- nick [user@host] has quit [Quit: leaving]",
+			Entry: LogEntry{
+				Time:     currentDateZeroSecs,
+				Type:     Quit,
+				Nick:     "nick",
+				UserHost: "user@host",
+				Text:     "Quit: leaving",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- nick1 is now known as nick2",
+			Entry: LogEntry{
+				Time: currentDateZeroSecs,
+				Type: NickChange,
+				Nick: "nick1",
+				Text: "nick2",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "--- Day changed Mon Mar 28 2016",
+			Entry: LogEntry{
+				Time: time.Date(2016, 3, 28, 0, 0, 0, 0, location),
+				Type: DayChange,
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "--- Log closed Sun Mar 27 15:04:05 2016",
+			Entry: LogEntry{
+				Time: currentDate,
+				Type: LogClosed,
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- Irssi: You are now talking in #channel",
+			Entry: LogEntry{
+				Time:    currentDateZeroSecs,
+				Type:    NowTalking,
+				Channel: "#channel",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04  * nick waves",
+			Entry: LogEntry{
+				Time: currentDateZeroSecs,
+				Type: Emote,
+				Nick: "nick",
+				Text: "waves",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- nick changed the topic of #channel to: new topic",
+			Entry: LogEntry{
+				Time:    currentDateZeroSecs,
+				Type:    Topic,
+				Nick:    "nick",
+				Channel: "#channel",
+				Text:    "new topic",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- nick1 was kicked from #channel by nick2 [bye]",
+			Entry: LogEntry{
+				Time:    currentDateZeroSecs,
+				Type:    Kick,
+				Nick:    "nick1",
+				ByNick:  "nick2",
+				Channel: "#channel",
+				Text:    "bye",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- nick [user@host] has left #channel [bye]",
+			Entry: LogEntry{
+				Time:     currentDateZeroSecs,
+				Type:     Part,
+				Nick:     "nick",
+				UserHost: "user@host",
+				Channel:  "#channel",
+				Text:     "bye",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- You're now known as nick",
+			Entry: LogEntry{
+				Time: currentDateZeroSecs,
+				Type: YourNickChange,
+				Nick: "nick",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- ServerMode/#channel [+b *!*@host] by irc.example.net",
+			Entry: LogEntry{
+				Time:    currentDateZeroSecs,
+				Type:    ServerMode,
+				Channel: "#channel",
+				Nick:    "irc.example.net",
+				Text:    "+b *!*@host",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -nick:@#channel- hello channel",
+			Entry: LogEntry{
+				Time:         currentDateZeroSecs,
+				Type:         ChannelNotice,
+				Nick:         "nick",
+				Channel:      "#channel",
+				Text:         "hello channel",
+				NoticeTarget: "@",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- Keepnick: Nickname nick in use, trying nick_",
+			Entry: LogEntry{
+				Time: currentDateZeroSecs,
+				Type: IgnoreThis,
+				Text: " Nickname nick in use, trying nick_",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 !irc.example.net *** Notice",
+			Entry: LogEntry{
+				Time: currentDateZeroSecs,
+				Type: ServerNotice,
+				Nick: "irc.example.net",
+				Text: "*** Notice",
+			},
+			Error: nil,
+		},
+		TestCase{
+			Line: "15:04 -!- Irssi: No bans in channel #channel",
+			Entry: LogEntry{
+				Time:    currentDateZeroSecs,
+				Type:    BansNone,
+				Channel: "#channel",
+			},
+			Error: nil,
+		},
 	}
 
-	for _, testCase := range cases {
-		entry, err := ParseLine(testCase.Line, location, currentDate)
-		if err != nil {
-			if testCase.Error != nil {
-				continue
-			}
-			t.Errorf("Test case with line [%s] failed: %s", testCase.Line, err.Error())
-			continue
-		}
-
-		if !entryMatches(t, entry, testCase.Entry) {
-			continue
-		}
-	}
+	return location, currentDate, cases
 }
 
 // entryMatches compares two log entries.
@@ -111,8 +283,8 @@ func TestParseLine(t *testing.T) {
 // It triggers a test fail if no match.
 func entryMatches(t *testing.T, found *LogEntry, wanted LogEntry) bool {
 	if found.Type != wanted.Type {
-		t.Errorf("Type does not match: Line: %s Found: %d Wanted %d", found.Type,
-			wanted.Type)
+		t.Errorf("Type does not match: Line: %s Found: %d Wanted %d", found.Line,
+			found.Type, wanted.Type)
 		return false
 	}
 
@@ -130,6 +302,18 @@ func entryMatches(t *testing.T, found *LogEntry, wanted LogEntry) bool {
 		return false
 	}
 
+	if wanted.NickMode != found.NickMode {
+		t.Errorf("NickMode mismatch: Line: %s Wanted %s, have %s", found.Line,
+			wanted.NickMode, found.NickMode)
+		return false
+	}
+
+	if wanted.ByNick != found.ByNick {
+		t.Errorf("ByNick mismatch: Line: %s Wanted %s, have %s", found.Line,
+			wanted.ByNick, found.ByNick)
+		return false
+	}
+
 	if wanted.UserHost != found.UserHost {
 		t.Errorf("UserHost mismatch: Line: %s Wanted %s, have %s", found.Line,
 			wanted.UserHost, found.UserHost)
@@ -142,5 +326,291 @@ func entryMatches(t *testing.T, found *LogEntry, wanted LogEntry) bool {
 		return false
 	}
 
+	if wanted.Text != found.Text {
+		t.Errorf("Text mismatch: Line: %s Wanted %s, have %s", found.Line,
+			wanted.Text, found.Text)
+		return false
+	}
+
+	if wanted.NoticeTarget != found.NoticeTarget {
+		t.Errorf("NoticeTarget mismatch: Line: %s Wanted %s, have %s", found.Line,
+			wanted.NoticeTarget, found.NoticeTarget)
+		return false
+	}
+
+	if wanted.SyncSeconds != found.SyncSeconds {
+		t.Errorf("SyncSeconds mismatch: Line: %s Wanted %d, have %d", found.Line,
+			wanted.SyncSeconds, found.SyncSeconds)
+		return false
+	}
+
 	return true
 }
+
+// cascadeMatchers holds every matcher in the order ParseLine used to try them,
+// one after the other, before it looked at how lines start.
+var cascadeMatchers = []*lineMatcher{
+	messageMatcher,
+	logOpenMatcher,
+	joinMatcher,
+	summaryMatcher,
+	modeMatcher,
+	syncMatcher,
+	quitMatcher,
+	nickMatcher,
+	dayMatcher,
+	closeMatcher,
+	nowMatcher,
+	emoteMatcher,
+	topicMatcher,
+	kickMatcher,
+	partMatcher,
+	yourNickMatcher,
+	serverModeMatcher,
+	channelNoticeMatcher,
+	keepnickMatcher,
+	serverNoticeMatcher,
+	bansNoneMatcher,
+}
+
+// parseLineCascade parses a line by trying every pattern in turn.
+func parseLineCascade(line string, location *time.Location,
+	currentDate time.Time) (*LogEntry, error) {
+	for _, matcher := range cascadeMatchers {
+		matches := matcher.pattern.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		return matcher.parse(line, matches, location, currentDate)
+	}
+
+	return nil, fmt.Errorf("Unrecognized line: %s", line)
+}
+
+func TestParseLineMatchesCascade(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	currentDate := time.Date(2016, 3, 27, 0, 0, 0, 0, location)
+
+	lines := readLines(t, filepath.Join("testdata", "sample.log"))
+
+	// Lines that are close to being recognized.
+	lines = append(lines,
+		"",
+		"15:04",
+		"15:04 ",
+		"1a:04 <@nick> hi",
+		"15:04 <> hi",
+		"15:04 <@nick>",
+		"15:04 * nick waves",
+		"15:04  * nick",
+		"--- ",
+		"--- Log opened yesterday",
+		"--- Day changed",
+		"15:04 -!- ",
+		"15:04 -!- nick",
+		"15:04 -!- Irssi: ",
+		"15:04 -!- Irssi: #channel: Total of many nicks",
+		"15:04 -!- mode/#channel [] by nick",
+		"15:04 -!- nick [user@host] has joined",
+		"15:04 -!- nick [user@host] has left #channel",
+		"15:04 -!- nick1 was kicked from #channel by nick2",
+		"15:04 -!- Keepnick",
+		"15:04 -nick- hi",
+		"15:04 -nick:#channel-",
+		"15:04 !",
+		"15:04 !server",
+		"99:99 <@nick> late",
+	)
+
+	// A large made up log with every kind of line, text that looks like other
+	// kinds of lines, and lines damaged in the ways that happen in real logs.
+	lines = append(lines, mixedLog(rand.New(rand.NewSource(1)), 200000)...)
+
+	for _, line := range lines {
+		entry, err := ParseLine(line, location, currentDate)
+		wantedEntry, wantedErr := parseLineCascade(line, location, currentDate)
+
+		if (err == nil) != (wantedErr == nil) {
+			t.Errorf("Line [%s]: error %v, wanted %v", line, err, wantedErr)
+			continue
+		}
+
+		if err != nil {
+			if err.Error() != wantedErr.Error() {
+				t.Errorf("Line [%s]: error %s, wanted %s", line, err.Error(),
+					wantedErr.Error())
+			}
+			continue
+		}
+
+		if !entriesEqual(entry, wantedEntry) {
+			t.Errorf("Line [%s]: entry %+v, wanted %+v", line, entry, wantedEntry)
+		}
+	}
+}
+
+func BenchmarkParseLine(b *testing.B) {
+	benchmarkParseLine(b, ParseLine)
+}
+
+func BenchmarkParseLineCascade(b *testing.B) {
+	benchmarkParseLine(b, parseLineCascade)
+}
+
+// benchmarkParseLine runs a line parsing function over a large made up log.
+func benchmarkParseLine(b *testing.B, parseLine func(string, *time.Location,
+	time.Time) (*LogEntry, error)) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		b.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	currentDate := time.Date(2016, 3, 27, 0, 0, 0, 0, location)
+
+	lines := mixedLog(rand.New(rand.NewSource(1)), 100000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			_, _ = parseLine(line, location, currentDate)
+		}
+	}
+
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(lines)),
+		"ns/line")
+}
+
+// readLines reads the lines of a file.
+func readLines(t testing.TB, filename string) []string {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+}
+
+// mixedLog makes up a log of count lines. Most are messages, as in a real
+// channel, but there is every kind of line. Some lines have text that looks
+// like another kind of line, and some are damaged: cut short, or with a byte
+// changed, as happens when Irssi is killed part way through writing a line or
+// a log is mangled.
+func mixedLog(rng *rand.Rand, count int) []string {
+	nicks := []string{"alice", "bob", "carol", "dave_", "[eve]", "f|o", "g^h",
+		"nick-1", "ünï", "x"}
+	channels := []string{"#channel", "#go-nuts", "##weird", "&local", "#a.b"}
+	modes := []string{"", "@", "+", " ", "%", "~"}
+
+	words := []string{"hi", "there", "the", "a", "deploy", "http://example.com/x",
+		"-!-", "<@bob>", "[user@host]", "has", "joined", "quit", "left", "by",
+		"*", "!", "--- ", "Log", "opened", "Irssi:", "mode/#channel", "[+o", "x]",
+		"is", "now", "known", "as", "secs", "Total", ":)", "héllo", "🙂", "",
+		"15:04", "-nick:#channel-", "\t", "kicked", "from", "to:"}
+
+	pick := func(values []string) string {
+		return values[rng.Intn(len(values))]
+	}
+
+	text := func() string {
+		n := rng.Intn(12)
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = pick(words)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	host := func() string {
+		return fmt.Sprintf("~%s@%d.example.com", pick(nicks), rng.Intn(100))
+	}
+
+	lines := make([]string, 0, count)
+
+	for len(lines) < count {
+		clock := fmt.Sprintf("%02d:%02d", rng.Intn(24), rng.Intn(60))
+
+		var line string
+		switch n := rng.Intn(100); {
+		case n < 60:
+			line = fmt.Sprintf("%s <%s%s> %s", clock, pick(modes), pick(nicks),
+				text())
+		case n < 65:
+			line = fmt.Sprintf("%s  * %s %s", clock, pick(nicks), text())
+		case n < 70:
+			line = fmt.Sprintf("%s -!- %s [%s] has joined %s", clock, pick(nicks),
+				host(), pick(channels))
+		case n < 74:
+			line = fmt.Sprintf("%s -!- %s [%s] has quit [%s]", clock, pick(nicks),
+				host(), text())
+		case n < 77:
+			line = fmt.Sprintf("%s -!- %s [%s] has left %s [%s]", clock,
+				pick(nicks), host(), pick(channels), text())
+		case n < 79:
+			line = fmt.Sprintf("%s -!- %s is now known as %s", clock, pick(nicks),
+				pick(nicks))
+		case n < 81:
+			line = fmt.Sprintf("%s -!- mode/%s [+o %s] by %s", clock,
+				pick(channels), pick(nicks), pick(nicks))
+		case n < 82:
+			line = fmt.Sprintf("%s -!- ServerMode/%s [+b %s!*@*] by irc.example.com",
+				clock, pick(channels), pick(nicks))
+		case n < 83:
+			line = fmt.Sprintf("%s -!- %s changed the topic of %s to: %s", clock,
+				pick(nicks), pick(channels), text())
+		case n < 84:
+			line = fmt.Sprintf("%s -!- %s was kicked from %s by %s [%s]", clock,
+				pick(nicks), pick(channels), pick(nicks), text())
+		case n < 85:
+			line = fmt.Sprintf("%s -%s:%s%s- %s", clock, pick(nicks),
+				pick([]string{"", "@", "+"}), pick(channels), text())
+		case n < 86:
+			line = fmt.Sprintf("%s !irc.example.com %s", clock, text())
+		case n < 87:
+			line = fmt.Sprintf("%s -!- Irssi: Join to %s was synced in %d secs",
+				clock, pick(channels), rng.Intn(10))
+		case n < 88:
+			line = fmt.Sprintf(
+				"%s -!- Irssi: %s: Total of %d nicks [%d ops, 0 halfops, %d voices, %d normal]",
+				clock, pick(channels), rng.Intn(500), rng.Intn(10), rng.Intn(10),
+				rng.Intn(500))
+		case n < 89:
+			line = fmt.Sprintf("%s -!- Irssi: You are now talking in %s", clock,
+				pick(channels))
+		case n < 90:
+			line = fmt.Sprintf("%s -!- Irssi: No bans in channel %s", clock,
+				pick(channels))
+		case n < 91:
+			line = fmt.Sprintf("%s -!- You're now known as %s", clock, pick(nicks))
+		case n < 92:
+			line = fmt.Sprintf("%s -!- Keepnick: %s", clock, text())
+		case n < 93:
+			line = "--- Log opened Sun Mar 27 15:04:05 2016"
+		case n < 94:
+			line = "--- Log closed Sun Mar 27 15:04:05 2016"
+		case n < 95:
+			line = "--- Day changed Mon Mar 28 2016"
+		default:
+			line = fmt.Sprintf("%s %s", clock, text())
+		}
+
+		// Damage some lines.
+		switch n := rng.Intn(100); {
+		case n < 3 && len(line) > 0:
+			line = line[:rng.Intn(len(line))]
+		case n < 6 && len(line) > 0:
+			b := []byte(line)
+			b[rng.Intn(len(b))] = " -!*<>[]:#@+0"[rng.Intn(13)]
+			line = string(b)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
@@ -0,0 +1,393 @@
+/*
+ * A small query language for filters.
+ *
+ * A query is a list of terms separated by spaces. An entry must match every
+ * term. A term looks like field:value, such as:
+ *
+ *   nick:alice type:message,emote after:2016-03-01 text:/deploy(ed)?/ -nick:bot
+ *
+ * Fields:
+ *
+ *   type:    Entry type name, such as message or emote. Case is ignored.
+ *   nick:    Nick. Compared the way IRC servers do.
+ *   channel: Channel. Compared the way IRC servers do.
+ *   host:    user@host glob. * matches anything and ? matches one character.
+ *   after:   Entries at or after this time.
+ *   before:  Entries before this time.
+ *   text:    Text containing this, ignoring case. Or, if in slashes, text
+ *            matching the regular expression. /.../i ignores case.
+ *
+ * Times are YYYY-MM-DD, YYYY-MM-DDTHH:MM, or RFC 3339.
+ *
+ * A term with no field is the same as text:. Values separated by commas match
+ * if any one matches. A - before a term matches entries the term does not.
+ * Values may be in double quotes to include spaces or commas.
+ */
+
+package irssi_log
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// QueryError describes a problem with a query.
+type QueryError struct {
+	// Column is where in the query the problem is, starting at 1.
+	Column int
+
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid query at column %d: %s", e.Column, e.Message)
+}
+
+// queryTerm is one term of a query.
+type queryTerm struct {
+	// column is where the term starts, starting at 1.
+	column int
+
+	negate bool
+
+	// field is blank for a bare value.
+	field string
+
+	values []queryValue
+}
+
+// queryValue is a value in a term.
+type queryValue struct {
+	column int
+	text   string
+
+	// regexp is set if the value was in slashes.
+	regexp bool
+
+	// ignoreCase is set if the value was a regexp with the i flag.
+	ignoreCase bool
+}
+
+// queryFields holds how to build a filter for each field.
+var queryFields = map[string]func(value queryValue,
+	location *time.Location) (Filter, error){
+	"type":    typeQueryFilter,
+	"nick":    nickQueryFilter,
+	"channel": channelQueryFilter,
+	"host":    hostQueryFilter,
+	"after":   afterQueryFilter,
+	"before":  beforeQueryFilter,
+	"text":    textQueryFilter,
+}
+
+// queryTimeLayouts are the time formats we accept, tried in order.
+var queryTimeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	time.RFC3339,
+}
+
+// ParseQuery parses a query into a Filter. Times in the query without a zone
+// are in the given location.
+//
+// An empty query matches everything.
+func ParseQuery(query string, location *time.Location) (Filter, error) {
+	terms, err := scanQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []Filter
+	for _, term := range terms {
+		field := term.field
+		if field == "" {
+			field = "text"
+		}
+
+		makeFilter, exists := queryFields[field]
+		if !exists {
+			return nil, &QueryError{
+				Column: term.column,
+				Message: fmt.Sprintf("Unknown field %q. Fields are: %s. To search for text with a colon, put it in quotes",
+					term.field, strings.Join(queryFieldNames(), ", ")),
+			}
+		}
+
+		var alternatives []Filter
+		for _, value := range term.values {
+			if value.regexp && field != "text" {
+				return nil, &QueryError{
+					Column:  value.column,
+					Message: fmt.Sprintf("Field %s does not take a regular expression", field),
+				}
+			}
+
+			filter, err := makeFilter(value, location)
+			if err != nil {
+				return nil, &QueryError{Column: value.column, Message: err.Error()}
+			}
+
+			alternatives = append(alternatives, filter)
+		}
+
+		filter := alternatives[0]
+		if len(alternatives) > 1 {
+			filter = Or(alternatives...)
+		}
+
+		if term.negate {
+			filter = Not(filter)
+		}
+
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+
+	return And(filters...), nil
+}
+
+// queryFieldNames gives the names of all fields, sorted.
+func queryFieldNames() []string {
+	var names []string
+	for name := range queryFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scanQuery splits a query into terms.
+func scanQuery(query string) ([]queryTerm, error) {
+	runes := []rune(query)
+	var terms []queryTerm
+
+	i := 0
+	for {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+
+		if i == len(runes) {
+			return terms, nil
+		}
+
+		term := queryTerm{column: i + 1}
+
+		if runes[i] == '-' {
+			term.negate = true
+			i++
+		}
+
+		// A field is letters up to a colon. Anything else is a bare value.
+		j := i
+		for j < len(runes) && unicode.IsLetter(runes[j]) {
+			j++
+		}
+
+		if j > i && j < len(runes) && runes[j] == ':' {
+			term.field = strings.ToLower(string(runes[i:j]))
+			i = j + 1
+		}
+
+		values, next, err := scanQueryValues(runes, i)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(values) == 0 {
+			return nil, &QueryError{
+				Column:  i + 1,
+				Message: "Missing value",
+			}
+		}
+
+		term.values = values
+		terms = append(terms, term)
+		i = next
+	}
+}
+
+// scanQueryValues reads comma separated values starting at runes[i]. It
+// returns the values and the index after them.
+func scanQueryValues(runes []rune, i int) ([]queryValue, int, error) {
+	var values []queryValue
+
+	for i < len(runes) && !unicode.IsSpace(runes[i]) {
+		value := queryValue{column: i + 1}
+
+		switch runes[i] {
+		case '/':
+			text, next, err := scanQueryQuoted(runes, i, '/')
+			if err != nil {
+				return nil, 0, err
+			}
+			value.text = text
+			value.regexp = true
+			i = next
+
+			if i < len(runes) && runes[i] == 'i' {
+				value.ignoreCase = true
+				i++
+			}
+		case '"':
+			text, next, err := scanQueryQuoted(runes, i, '"')
+			if err != nil {
+				return nil, 0, err
+			}
+			value.text = text
+			i = next
+		default:
+			j := i
+			for j < len(runes) && runes[j] != ',' && !unicode.IsSpace(runes[j]) {
+				j++
+			}
+			value.text = string(runes[i:j])
+			i = j
+		}
+
+		if value.text == "" {
+			return nil, 0, &QueryError{Column: value.column, Message: "Empty value"}
+		}
+
+		values = append(values, value)
+
+		if i == len(runes) || unicode.IsSpace(runes[i]) {
+			break
+		}
+
+		if runes[i] != ',' {
+			return nil, 0, &QueryError{
+				Column: i + 1,
+				Message: fmt.Sprintf("Unexpected %q after value. Separate values with commas",
+					string(runes[i])),
+			}
+		}
+
+		i++
+		if i == len(runes) || unicode.IsSpace(runes[i]) {
+			return nil, 0, &QueryError{Column: i + 1, Message: "Missing value after comma"}
+		}
+	}
+
+	return values, i, nil
+}
+
+// scanQueryQuoted reads a value between two delimiters, starting at the
+// opening one at runes[i]. A backslash escapes the delimiter. It returns the
+// value and the index after the closing delimiter.
+func scanQueryQuoted(runes []rune, i int, delimiter rune) (string, int,
+	error) {
+	start := i
+	i++
+
+	var text []rune
+	for i < len(runes) {
+		if runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == delimiter {
+			text = append(text, delimiter)
+			i += 2
+			continue
+		}
+
+		if runes[i] == delimiter {
+			return string(text), i + 1, nil
+		}
+
+		text = append(text, runes[i])
+		i++
+	}
+
+	return "", 0, &QueryError{
+		Column:  start + 1,
+		Message: fmt.Sprintf("Missing closing %c", delimiter),
+	}
+}
+
+func typeQueryFilter(value queryValue, location *time.Location) (Filter,
+	error) {
+	for entryType, name := range entryTypeNames {
+		if strings.EqualFold(name, value.text) {
+			return HasType(entryType), nil
+		}
+	}
+
+	var names []string
+	for _, name := range entryTypeNames {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	return nil, fmt.Errorf("Unknown type %q. Types are: %s", value.text,
+		strings.Join(names, ", "))
+}
+
+func nickQueryFilter(value queryValue, location *time.Location) (Filter,
+	error) {
+	return HasNick(value.text), nil
+}
+
+func channelQueryFilter(value queryValue, location *time.Location) (Filter,
+	error) {
+	return InChannel(value.text), nil
+}
+
+func hostQueryFilter(value queryValue, location *time.Location) (Filter,
+	error) {
+	return UserHostMatches(value.text), nil
+}
+
+func afterQueryFilter(value queryValue, location *time.Location) (Filter,
+	error) {
+	t, err := parseQueryTime(value.text, location)
+	if err != nil {
+		return nil, err
+	}
+	return InTimeRange(t, time.Time{}), nil
+}
+
+func beforeQueryFilter(value queryValue, location *time.Location) (Filter,
+	error) {
+	t, err := parseQueryTime(value.text, location)
+	if err != nil {
+		return nil, err
+	}
+	return InTimeRange(time.Time{}, t), nil
+}
+
+func textQueryFilter(value queryValue, location *time.Location) (Filter,
+	error) {
+	if !value.regexp {
+		return TextMatches(regexp.MustCompile("(?i)" +
+			regexp.QuoteMeta(value.text))), nil
+	}
+
+	pattern := value.text
+	if value.ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid regular expression: %s", err.Error())
+	}
+
+	return TextMatches(re), nil
+}
+
+// parseQueryTime parses a time in any of the layouts we accept.
+func parseQueryTime(s string, location *time.Location) (time.Time, error) {
+	for _, layout := range queryTimeLayouts {
+		t, err := time.ParseInLocation(layout, s, location)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid time %q. Use YYYY-MM-DD, YYYY-MM-DDTHH:MM, or RFC 3339", s)
+}
@@ -0,0 +1,106 @@
+package irssi_log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	entries := parseFile(t, "testdata/sample.log", location)
+
+	type TestCase struct {
+		Query string
+		Lines []int
+	}
+
+	cases := []TestCase{
+		{`type:emote`, []int{9, 33}},
+		{`nick:Carol type:MESSAGE,emote`, []int{8, 37}},
+		{`nick:bob -type:message`, []int{9, 10, 13, 14}},
+		{`channel:#CHANNEL type:topic`, []int{13}},
+		{`host:*@host.example.org`, []int{11, 18}},
+		{`after:2016-03-29 type:message`, []int{35, 36, 37}},
+		{`before:2016-03-27T15:05 type:join`, []int{2}},
+		{`after:2016-03-28T00:00:00-07:00 before:2016-03-28T09:00:00-07:00 nick:bob`,
+			[]int{23, 28}},
+		{`text:/deploy(ed)?\b/ -nick:alice,alice_`, []int{8, 28, 35}},
+		{`text:/^DEPLOY/i`, []int{13, 35, 36}},
+		{`DEPLOYED`, []int{8, 28, 36}},
+		{`"deploy going"`, []int{7}},
+		{`text:"is, how"`, nil},
+		{`text:/a\/b/`, nil},
+		{`nick:bob "deploy day"`, []int{35}},
+		{`  `, nil},
+	}
+
+	for _, testCase := range cases {
+		filter, err := ParseQuery(testCase.Query, location)
+		if err != nil {
+			t.Errorf("Unable to parse query %q: %s", testCase.Query, err)
+			continue
+		}
+
+		if testCase.Query == "  " {
+			if len(FilterEntries(entries, filter)) != len(entries) {
+				t.Errorf("Empty query does not match everything")
+			}
+			continue
+		}
+
+		var lines []int
+		for _, entry := range FilterEntries(entries, filter) {
+			lines = append(lines, entry.LineNumber)
+		}
+
+		if !intsEqual(lines, testCase.Lines) {
+			t.Errorf("Query %q matched lines %v, wanted %v", testCase.Query, lines,
+				testCase.Lines)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	type TestCase struct {
+		Query  string
+		Column int
+	}
+
+	cases := []TestCase{
+		{`nick:`, 6},
+		{`type:message  colour:red`, 15},
+		{`type:messages`, 6},
+		{`nick:bob,`, 10},
+		{`after:yesterday`, 7},
+		{`text:/deploy(/`, 6},
+		{`text:/deploy`, 6},
+		{`text:"deploy`, 6},
+		{`nick:/bob/`, 6},
+		{`text:"a"b`, 9},
+		{`-`, 2},
+	}
+
+	for _, testCase := range cases {
+		_, err := ParseQuery(testCase.Query, time.UTC)
+		if err == nil {
+			t.Errorf("Query %q parsed", testCase.Query)
+			continue
+		}
+
+		queryErr, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("Query %q gave error %s, not a QueryError", testCase.Query,
+				err)
+			continue
+		}
+
+		if queryErr.Column != testCase.Column {
+			t.Errorf("Query %q gave error at column %d, wanted %d: %s",
+				testCase.Query, queryErr.Column, testCase.Column, err)
+		}
+	}
+}
@@ -6,8 +6,11 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"runtime"
 	"time"
 
 	"github.com/horgh/irssi_log"
@@ -17,6 +20,10 @@ func main() {
 	logFile := flag.String("log-file", "", "Path to a log file to read.")
 	lineLimit := flag.Int("line-limit", 0, "Limit number of lines to read. 0 for entire log.")
 	locationString := flag.String("location", "America/Vancouver", "Time zone location.")
+	startDate := flag.String("start-date", "", "Only read entries from this date on (YYYY-MM-DD). This uses a day index kept next to the log. Optional.")
+	endDate := flag.String("end-date", "", "Only read entries up to and including this date (YYYY-MM-DD). Requires -start-date.")
+	workers := flag.Int("workers", 0, "Parse using this many goroutines. 0 to parse in one.")
+	compact := flag.Bool("compact", false, "Hold entries in compact form to use less memory.")
 
 	flag.Parse()
 
@@ -32,6 +39,30 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *workers < 0 {
+		log.Print("You must specify workers >= 0.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *workers > 0 && (*lineLimit > 0 || len(*startDate) > 0 || *compact) {
+		log.Print("You cannot use workers with a line limit, dates, or compact.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *compact && len(*startDate) > 0 {
+		log.Print("You cannot use compact with dates.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if len(*endDate) > 0 && len(*startDate) == 0 {
+		log.Print("You must specify a start date if you specify an end date.")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
 	if len(*locationString) == 0 {
 		log.Print("You must specify a location.")
 		flag.PrintDefaults()
@@ -51,13 +82,91 @@ func main() {
 	}
 	defer fh.Close()
 
-	entries, err := irssi_log.ParseLog(fh, *lineLimit, location)
+	if *compact {
+		compactLog, err := readCompact(fh, location, *lineLimit)
+		if err != nil {
+			log.Printf("Unable to parse log: %s", err.Error())
+			os.Exit(1)
+		}
+
+		log.Printf("Parsed %d entries.", compactLog.Len())
+		logMemory()
+
+		log.Print("Done!")
+		return
+	}
+
+	var entries []*irssi_log.LogEntry
+	if len(*startDate) > 0 {
+		entries, err = readDateRange(*logFile, fh, location, *startDate, *endDate)
+	} else if *workers > 0 {
+		entries, err = irssi_log.ParseLogParallel(fh, location, *workers)
+	} else {
+		entries, err = irssi_log.ParseLog(fh, *lineLimit, location)
+	}
 	if err != nil {
 		log.Printf("Unable to parse log: %s", err.Error())
 		os.Exit(1)
 	}
 
 	log.Printf("Parsed %d entries.", len(entries))
+	logMemory()
 
 	log.Print("Done!")
 }
+
+// readDateRange reads the entries between two dates. If there is no end date
+// we read only the start date.
+func readDateRange(logFile string, fh *os.File, location *time.Location,
+	startDate, endDate string) ([]*irssi_log.LogEntry, error) {
+	start, err := time.ParseInLocation("2006-01-02", startDate, location)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid start date: %s: %s", startDate,
+			err.Error())
+	}
+
+	end := start
+	if len(endDate) > 0 {
+		end, err = time.ParseInLocation("2006-01-02", endDate, location)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid end date: %s: %s", endDate, err.Error())
+		}
+	}
+
+	index, err := irssi_log.LoadDayIndex(logFile, fh, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.ParseRange(fh, start, end.AddDate(0, 0, 1))
+}
+
+// readCompact reads the log into a CompactLog.
+func readCompact(fh *os.File, location *time.Location,
+	lineLimit int) (*irssi_log.CompactLog, error) {
+	compactLog := irssi_log.NewCompactLog(location)
+	reader := irssi_log.NewReader(fh, irssi_log.NewParser(location))
+
+	for lineLimit == 0 || compactLog.Len() < lineLimit {
+		entry, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		compactLog.Add(entry)
+	}
+
+	return compactLog, nil
+}
+
+// logMemory logs the memory used.
+func logMemory() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	var allocMiB float64 = float64(mem.Alloc) / 1024.0 / 1024.0
+	var sysMiB float64 = float64(mem.Sys) / 1024.0 / 1024.0
+	log.Printf("Alloc: %.2f MiB Sys: %.2f MiB", allocMiB, sysMiB)
+}
@@ -0,0 +1,136 @@
+/*
+ * Find phrases said over and over in Irssi logs.
+ *
+ * This finds the channel's recurring memes and copypasta: text in messages
+ * that occurs more than once. For each we print how many times it was said,
+ * by how many nicks, and when it was first and last said.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/horgh/irssi_log"
+	"github.com/horgh/irssi_log/suffixarray"
+)
+
+func main() {
+	locationString := flag.String("location", "America/Vancouver", "Time zone location.")
+	minLength := flag.Int("min-length", 20, "Only find phrases at least this many bytes long.")
+	minCount := flag.Int("min-count", 2, "Only find phrases said at least this many times.")
+	minNicks := flag.Int("min-nicks", 1, "Only find phrases said by at least this many nicks.")
+	top := flag.Int("top", 20, "Print this many phrases. 0 for all.")
+	sortBy := flag.String("sort", "count", "Order to print phrases in: count (most said first), nicks (said by the most nicks first), or length (longest first).")
+	anywhere := flag.Bool("anywhere", false, "Let phrases start and end part way through words.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <log file> [log file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Print("You must specify at least one log file.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *minLength <= 0 {
+		log.Print("You must specify a minimum length > 0.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *top < 0 {
+		log.Print("You must specify top >= 0.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *sortBy != "count" && *sortBy != "nicks" && *sortBy != "length" {
+		log.Print("You must sort by count, nicks, or length.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	location, err := time.LoadLocation(*locationString)
+	if err != nil {
+		log.Printf("Invalid location: %s", err.Error())
+		os.Exit(1)
+	}
+
+	var messages []*irssi_log.LogEntry
+	for _, logFile := range flag.Args() {
+		entries, err := parseFile(logFile, location)
+		if err != nil {
+			log.Print(err.Error())
+			os.Exit(1)
+		}
+
+		messages = append(messages, irssi_log.FilterEntries(entries,
+			irssi_log.HasType(irssi_log.Message))...)
+	}
+
+	corpus := suffixarray.NewCorpus(messages)
+
+	var repeats []suffixarray.Repeat
+	for _, r := range corpus.Repeats(*minLength, !*anywhere) {
+		if r.Count >= *minCount && len(r.Nicks) >= *minNicks {
+			repeats = append(repeats, r)
+		}
+	}
+
+	// Repeats come most said first. Keep that order for ties.
+	switch *sortBy {
+	case "nicks":
+		sort.SliceStable(repeats, func(i, j int) bool {
+			return len(repeats[i].Nicks) > len(repeats[j].Nicks)
+		})
+	case "length":
+		sort.SliceStable(repeats, func(i, j int) bool {
+			return len(repeats[i].Text) > len(repeats[j].Text)
+		})
+	}
+
+	if *top > 0 && len(repeats) > *top {
+		repeats = repeats[:*top]
+	}
+
+	for _, r := range repeats {
+		fmt.Printf("%d times by %d nicks from %s to %s: %s\n", r.Count,
+			len(r.Nicks), r.First.Format("2006-01-02"), r.Last.Format("2006-01-02"),
+			r.Text)
+		fmt.Printf("    %s\n", strings.Join(r.Nicks, ", "))
+	}
+}
+
+// parseFile parses a log.
+func parseFile(logFile string, location *time.Location) (
+	[]*irssi_log.LogEntry, error) {
+	fh, err := os.Open(logFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open file: %s: %s", logFile, err.Error())
+	}
+	defer fh.Close()
+
+	entries, err := irssi_log.ParseLog(fh, 0, location)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse log: %s: %s", logFile, err.Error())
+	}
+
+	return entries, nil
+}
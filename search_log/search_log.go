@@ -0,0 +1,104 @@
+/*
+ * Search Irssi logs using an inverted index.
+ *
+ * Add logs to the index first with -add. Searching brings the index up to date
+ * with the logs before it searches.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/horgh/irssi_log/textindex"
+)
+
+func main() {
+	indexDir := flag.String("index-dir", "", "Path to the index directory. It is created if necessary.")
+	add := flag.Bool("add", false, "Add the given log files to the index rather than searching.")
+	locationString := flag.String("location", "America/Vancouver", "Time zone location of logs being added.")
+	limit := flag.Int("limit", 100, "Limit number of results. 0 for all.")
+	noUpdate := flag.Bool("no-update", false, "Search without first indexing what was added to the logs.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] -add <log file> [log file...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] <query>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Example query: deploy* \"is stuck\"\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if len(*indexDir) == 0 {
+		log.Print("You must specify an index directory.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if flag.NArg() == 0 {
+		log.Print("You must specify log files to add or a query.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *limit < 0 {
+		log.Print("You must specify a limit >= 0.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(*locationString) == 0 {
+		log.Print("You must specify a location.")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	location, err := time.LoadLocation(*locationString)
+	if err != nil {
+		log.Printf("Invalid location: %s", err.Error())
+		os.Exit(1)
+	}
+
+	index, err := textindex.Open(*indexDir)
+	if err != nil {
+		log.Print(err.Error())
+		os.Exit(1)
+	}
+
+	if *add {
+		for _, logFile := range flag.Args() {
+			count, err := index.Add(logFile, location)
+			if err != nil {
+				log.Printf("Unable to index %s: %s", logFile, err.Error())
+				os.Exit(1)
+			}
+
+			log.Printf("Indexed %d entries from %s.", count, logFile)
+		}
+		return
+	}
+
+	if !*noUpdate {
+		_, err := index.Update()
+		if err != nil {
+			log.Printf("Unable to update index: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	results, err := index.Search(strings.Join(flag.Args(), " "), *limit)
+	if err != nil {
+		log.Print(err.Error())
+		os.Exit(1)
+	}
+
+	for _, result := range results {
+		fmt.Printf("%s:%d: %s %s\n", result.File, result.Entry.LineNumber,
+			result.Entry.Time.Format("2006-01-02"), result.Entry.Line)
+	}
+}
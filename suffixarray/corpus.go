@@ -0,0 +1,101 @@
+package suffixarray
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/horgh/irssi_log"
+)
+
+// entrySeparator goes between the text of each entry in a corpus. IRC
+// messages can't contain it, so a match never spans two entries.
+const entrySeparator = '\n'
+
+// Corpus indexes the text of log entries so we can find any substring in them.
+type Corpus struct {
+	index   *Index
+	entries []*irssi_log.LogEntry
+
+	// starts are where each entry's text starts in the indexed text.
+	starts []int
+}
+
+// Hit is where a substring occurs.
+type Hit struct {
+	Entry *irssi_log.LogEntry
+
+	// Position is the byte offset of the match in the entry's Text.
+	Position int
+}
+
+// NewCorpus indexes the text of the entries. Entries without text add
+// nothing, so you may want to filter them first, such as to only messages.
+func NewCorpus(entries []*irssi_log.LogEntry) *Corpus {
+	var buf bytes.Buffer
+	var kept []*irssi_log.LogEntry
+	var starts []int
+
+	for _, entry := range entries {
+		if len(entry.Text) == 0 {
+			continue
+		}
+
+		kept = append(kept, entry)
+		starts = append(starts, buf.Len())
+
+		buf.WriteString(entry.Text)
+		buf.WriteByte(entrySeparator)
+	}
+
+	return &Corpus{
+		index:   New(buf.Bytes()),
+		entries: kept,
+		starts:  starts,
+	}
+}
+
+// Count gives how many times s occurs in the entries' text.
+func (c *Corpus) Count(s string) int {
+	if !validPattern(s) {
+		return 0
+	}
+	return c.index.Count([]byte(s))
+}
+
+// Search finds where s occurs in the entries' text, in log order. If limit is
+// positive we give at most that many, the earliest ones.
+func (c *Corpus) Search(s string, limit int) []Hit {
+	if !validPattern(s) {
+		return nil
+	}
+
+	var hits []Hit
+	for _, offset := range c.index.Lookup([]byte(s), 0) {
+		if limit > 0 && len(hits) == limit {
+			break
+		}
+
+		hits = append(hits, c.hitAt(offset))
+	}
+
+	return hits
+}
+
+// hitAt finds the entry with the text at an offset in the indexed text.
+func (c *Corpus) hitAt(offset int) Hit {
+	i := sort.Search(len(c.starts), func(i int) bool {
+		return c.starts[i] > offset
+	}) - 1
+
+	return Hit{
+		Entry:    c.entries[i],
+		Position: offset - c.starts[i],
+	}
+}
+
+// validPattern checks whether we can find s. s must not be empty or have the
+// separator in it.
+func validPattern(s string) bool {
+	return len(s) > 0 && !strings.ContainsRune(s, entrySeparator)
+}
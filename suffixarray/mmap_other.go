@@ -0,0 +1,22 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package suffixarray
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// mapFile reads a file. We don't map files on this platform.
+func mapFile(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read file: %s: %s", file, err.Error())
+	}
+	return data, nil
+}
+
+// unmapFile does nothing, as mapFile only reads files on this platform.
+func unmapFile(data []byte) {
+}
@@ -0,0 +1,50 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package suffixarray
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mapFile maps a file into memory read only.
+func mapFile(file string) ([]byte, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open file: %s: %s", file, err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to stat file: %s: %s", file, err.Error())
+	}
+
+	// Mapping nothing is an error.
+	if fi.Size() == 0 {
+		return nil, nil
+	}
+
+	if int64(int(fi.Size())) != fi.Size() {
+		return nil, fmt.Errorf("File is too large to map: %s", file)
+	}
+
+	data, err := syscall.Mmap(int(fh.Fd()), 0, int(fi.Size()), syscall.PROT_READ,
+		syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to map file: %s: %s", file, err.Error())
+	}
+
+	return data, nil
+}
+
+// unmapFile releases a mapping from mapFile.
+func unmapFile(data []byte) {
+	if len(data) > 0 {
+		_ = syscall.Munmap(data)
+	}
+}
@@ -0,0 +1,333 @@
+/*
+ * Finding text said more than once.
+ *
+ * Suffixes sharing a prefix are next to each other in the suffix array, and
+ * the LCP array says how long the shared prefix is. A run of suffixes sharing
+ * at least some number of bytes is an LCP interval. Each interval is a
+ * repeated substring, and the suffixes in it are where it occurs.
+ */
+
+package suffixarray
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/horgh/irssi_log"
+)
+
+// Repeat is text that occurs more than once in a corpus.
+type Repeat struct {
+	Text string
+
+	// Count is how many times it occurs.
+	Count int
+
+	// Nicks are the distinct nicks of the entries it occurs in, sorted.
+	Nicks []string
+
+	// Times of the first and last entries it occurs in.
+	First time.Time
+	Last  time.Time
+}
+
+// interval is an LCP interval: positions start to end inclusive of the suffix
+// array share a prefix of length lcp.
+//
+// parent is the lcp of the interval enclosing it. Each prefix longer than
+// parent, up to lcp, occurs exactly at the positions of this interval.
+type interval struct {
+	lcp    int
+	parent int
+	start  int
+	end    int
+}
+
+// Repeats finds text at least minLength bytes long that occurs more than
+// once. We give the most frequent first.
+//
+// We only give maximal repeats. A repeat is maximal if making it longer at
+// either end would change where it occurs. For example, if "deploy the" only
+// occurs as part of "please deploy the", we give only the latter.
+//
+// If wholeWords is true, repeats start and end at word boundaries, and we
+// count only occurrences that do. Otherwise they may start and end anywhere.
+func (c *Corpus) Repeats(minLength int, wholeWords bool) []Repeat {
+	if minLength < 1 {
+		minLength = 1
+	}
+
+	c.index.BuildLCP()
+
+	var repeats []Repeat
+
+	c.eachInterval(minLength, func(iv interval) {
+		if wholeWords {
+			repeats = append(repeats, c.wordRepeats(iv, minLength)...)
+			return
+		}
+
+		if !c.leftMaximal(iv) {
+			return
+		}
+
+		var offsets []int
+		for i := iv.start; i <= iv.end; i++ {
+			offsets = append(offsets, c.index.Offset(i))
+		}
+
+		offset := offsets[0]
+		text := c.index.Bytes()[offset : offset+iv.lcp]
+		repeats = append(repeats, c.repeat(string(text), offsets))
+	})
+
+	sort.Slice(repeats, func(i, j int) bool {
+		if repeats[i].Count != repeats[j].Count {
+			return repeats[i].Count > repeats[j].Count
+		}
+		if len(repeats[i].Text) != len(repeats[j].Text) {
+			return len(repeats[i].Text) > len(repeats[j].Text)
+		}
+		return repeats[i].Text < repeats[j].Text
+	})
+
+	return repeats
+}
+
+// eachInterval calls f with each LCP interval with a prefix at least
+// minLength long.
+//
+// We don't let a prefix run past the end of an entry. This way text repeated
+// as a whole message is its own interval rather than being part of one that
+// includes the separator.
+func (c *Corpus) eachInterval(minLength int, f func(interval)) {
+	n := c.index.Len()
+
+	stack := []interval{{lcp: 0, start: 0}}
+
+	for i := 1; i <= n; i++ {
+		lcp := 0
+		if i < n {
+			lcp = c.entryLCP(i)
+		}
+
+		start := i - 1
+		for lcp < stack[len(stack)-1].lcp {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			top.end = i - 1
+			top.parent = lcp
+			if stack[len(stack)-1].lcp > top.parent {
+				top.parent = stack[len(stack)-1].lcp
+			}
+			if top.lcp >= minLength {
+				f(top)
+			}
+
+			start = top.start
+		}
+
+		if lcp > stack[len(stack)-1].lcp {
+			stack = append(stack, interval{lcp: lcp, start: start})
+		}
+	}
+}
+
+// entryLCP gives the LCP of the suffix at position i of the suffix array and
+// the one before it, stopping at the end of an entry.
+func (c *Corpus) entryLCP(i int) int {
+	lcp := c.index.LCP(i)
+	if lcp == 0 {
+		return 0
+	}
+
+	offset := c.index.Offset(i)
+	end := bytes.IndexByte(c.index.Bytes()[offset:offset+lcp], entrySeparator)
+	if end != -1 {
+		return end
+	}
+	return lcp
+}
+
+// leftMaximal checks whether the text of an interval occurs after more than
+// one thing. If it doesn't, it is part of a longer repeat.
+//
+// The start of an entry counts as different from everything, as though each
+// entry had its own separator.
+func (c *Corpus) leftMaximal(iv interval) bool {
+	text := c.index.Bytes()
+
+	first := c.index.Offset(iv.start)
+	if first == 0 || text[first-1] == entrySeparator {
+		return true
+	}
+
+	for i := iv.start + 1; i <= iv.end; i++ {
+		offset := c.index.Offset(i)
+		if offset == 0 || text[offset-1] != text[first-1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wordRepeats gives the whole word repeats whose occurrences are the
+// positions of an interval.
+//
+// A phrase occurs exactly at an interval's positions if it is longer than the
+// interval's parent prefix and no longer than its own. Of those, only the
+// longest ending at a word boundary can be maximal: a shorter one is always
+// followed by the same word. That is either the whole prefix, where it ends at
+// a word boundary, or the prefix up to its last space.
+func (c *Corpus) wordRepeats(iv interval, minLength int) []Repeat {
+	text := c.index.Bytes()
+
+	// Occurrences that start a word. A prefix starting with a space starts
+	// no word.
+	first := c.index.Offset(iv.start)
+	if isBoundary(text[first]) {
+		return nil
+	}
+
+	var offsets []int
+	for i := iv.start; i <= iv.end; i++ {
+		offset := c.index.Offset(i)
+		if offset == 0 || isBoundary(text[offset-1]) {
+			offsets = append(offsets, offset)
+		}
+	}
+
+	if len(offsets) < 2 {
+		return nil
+	}
+
+	var repeats []Repeat
+
+	prefix := text[first : first+iv.lcp]
+
+	// The whole prefix, at the occurrences where a word ends after it.
+	if prefix[len(prefix)-1] != ' ' {
+		var ending []int
+		for _, offset := range offsets {
+			end := offset + iv.lcp
+			if end == len(text) || isBoundary(text[end]) {
+				ending = append(ending, offset)
+			}
+		}
+
+		if iv.lcp >= minLength && len(ending) >= 2 &&
+			c.wordsMaximal(ending, iv.lcp) {
+			repeats = append(repeats, c.repeat(string(prefix), ending))
+		}
+	}
+
+	// The prefix up to its last space. Each occurrence has a space after it.
+	if i := bytes.LastIndexByte(prefix, ' '); i != -1 {
+		length := len(bytes.TrimRight(prefix[:i], " "))
+		if length > iv.parent && length >= minLength &&
+			c.wordsMaximal(offsets, length) {
+			repeats = append(repeats, c.repeat(string(prefix[:length]), offsets))
+		}
+	}
+
+	return repeats
+}
+
+// wordsMaximal checks whether a phrase of the given length at the offsets
+// occurs after more than one word, and before more than one word. If it
+// doesn't, it is part of a longer phrase. As with leftMaximal, the start and
+// end of an entry count as different from everything.
+func (c *Corpus) wordsMaximal(offsets []int, length int) bool {
+	var befores, afters [][]byte
+	for _, offset := range offsets {
+		before, after := c.wordsAround(offset, offset+length)
+		befores = append(befores, before)
+		afters = append(afters, after)
+	}
+
+	return varies(befores) && varies(afters)
+}
+
+// wordsAround gives the words before and after the text from start to end.
+// Words are separated by runs of spaces, as wordRepeats finds them. At the
+// start or end of an entry, it gives nil.
+func (c *Corpus) wordsAround(start, end int) ([]byte, []byte) {
+	text := c.index.Bytes()
+
+	var before, after []byte
+
+	i := start
+	for i > 0 && text[i-1] == ' ' {
+		i--
+	}
+	if i > 0 && text[i-1] != entrySeparator {
+		j := i
+		for j > 0 && !isBoundary(text[j-1]) {
+			j--
+		}
+		before = text[j:i]
+	}
+
+	i = end
+	for i < len(text) && text[i] == ' ' {
+		i++
+	}
+	if i < len(text) && text[i] != entrySeparator {
+		j := i
+		for j < len(text) && !isBoundary(text[j]) {
+			j++
+		}
+		after = text[i:j]
+	}
+
+	return before, after
+}
+
+// varies checks whether the words are not all the same. A nil word is the
+// start or end of an entry, which is different from every other.
+func varies(words [][]byte) bool {
+	for _, word := range words {
+		if word == nil || !bytes.Equal(word, words[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBoundary checks whether a byte separates words.
+func isBoundary(c byte) bool {
+	return c == ' ' || c == entrySeparator
+}
+
+// repeat describes text occurring at the given offsets.
+func (c *Corpus) repeat(text string, offsets []int) Repeat {
+	r := Repeat{Text: text, Count: len(offsets)}
+
+	nicks := map[string]struct{}{}
+
+	for _, offset := range offsets {
+		entry := c.hitAt(offset).Entry
+
+		if len(entry.Nick) > 0 {
+			nick := irssi_log.IRCLower(entry.Nick)
+			if _, exists := nicks[nick]; !exists {
+				nicks[nick] = struct{}{}
+				r.Nicks = append(r.Nicks, entry.Nick)
+			}
+		}
+
+		if r.First.IsZero() || entry.Time.Before(r.First) {
+			r.First = entry.Time
+		}
+		if entry.Time.After(r.Last) {
+			r.Last = entry.Time
+		}
+	}
+
+	sort.Strings(r.Nicks)
+
+	return r
+}
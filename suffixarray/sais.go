@@ -0,0 +1,253 @@
+/*
+ * Suffix array construction using SA-IS.
+ *
+ * This is the induced sorting algorithm from Nong, Zhang, and Chan, "Two
+ * Efficient Algorithms for Linear Time Suffix Array Construction". It runs in
+ * linear time, and beyond the text and the array it needs a byte per character
+ * for the types, plus the buckets.
+ *
+ * We don't add a sentinel to the text. Instead we act as though there is one
+ * after the end, smaller than every character.
+ */
+
+package suffixarray
+
+// saInt is the type of the offsets in a suffix array.
+type saInt interface {
+	~int32 | ~int64
+}
+
+// textChar is the type of the characters of a text. The original text is
+// bytes. When we recurse, the text is names of substrings.
+type textChar interface {
+	~byte | ~int32 | ~int64
+}
+
+// sais builds the suffix array of text into sa. Characters must be less than
+// k. sa must be the same length as text.
+func sais[C textChar, T saInt](text []C, sa []T, k int) {
+	n := len(text)
+	if n == 0 {
+		return
+	}
+	if n == 1 {
+		sa[0] = 0
+		return
+	}
+
+	// A suffix is S type if it is smaller than the one after it, and L type if
+	// it is larger. The last suffix is larger than the sentinel.
+	sType := make([]bool, n)
+	for i := n - 2; i >= 0; i-- {
+		sType[i] = text[i] < text[i+1] || (text[i] == text[i+1] && sType[i+1])
+	}
+
+	// A leftmost S type suffix, or LMS, is an S type suffix after an L type one.
+	isLMS := func(i int) bool {
+		return i > 0 && sType[i] && !sType[i-1]
+	}
+
+	bucket := make([]T, k)
+
+	// Sort the LMS substrings. Put the LMS suffixes at the ends of their
+	// buckets and induce the rest.
+	for i := range sa {
+		sa[i] = -1
+	}
+
+	getBuckets(text, bucket, true)
+	for i := 1; i < n; i++ {
+		if isLMS(i) {
+			bucket[text[i]]--
+			sa[bucket[text[i]]] = T(i)
+		}
+	}
+
+	induce(text, sa, sType, bucket)
+
+	// Move the sorted LMS suffixes to the front.
+	m := 0
+	for i := 0; i < n; i++ {
+		if isLMS(int(sa[i])) {
+			sa[m] = sa[i]
+			m++
+		}
+	}
+
+	for i := m; i < n; i++ {
+		sa[i] = -1
+	}
+
+	// Name the LMS substrings by their order. Equal substrings get the same
+	// name. LMS suffixes are at least two apart, so i/2 gives each a place in
+	// the second half of the array.
+	names := 0
+	previous := -1
+	for i := 0; i < m; i++ {
+		position := int(sa[i])
+		if previous == -1 || !lmsSubstringsEqual(text, sType, position, previous) {
+			names++
+			previous = position
+		}
+		sa[m+position/2] = T(names - 1)
+	}
+
+	j := n - 1
+	for i := n - 1; i >= m; i-- {
+		if sa[i] >= 0 {
+			sa[j] = sa[i]
+			j--
+		}
+	}
+
+	// The names in text order are a smaller text. Sorting its suffixes sorts
+	// the LMS suffixes. If the names are unique, they are already the order.
+	reduced := sa[n-m:]
+	reducedSA := sa[:m]
+
+	if names < m {
+		sais(reduced, reducedSA, names)
+	} else {
+		for i := 0; i < m; i++ {
+			reducedSA[reduced[i]] = T(i)
+		}
+	}
+
+	// Put the sorted LMS suffixes at the ends of their buckets and induce the
+	// rest from them.
+	j = 0
+	for i := 1; i < n; i++ {
+		if isLMS(i) {
+			reduced[j] = T(i)
+			j++
+		}
+	}
+
+	for i := 0; i < m; i++ {
+		reducedSA[i] = reduced[reducedSA[i]]
+	}
+
+	for i := m; i < n; i++ {
+		sa[i] = -1
+	}
+
+	getBuckets(text, bucket, true)
+	for i := m - 1; i >= 0; i-- {
+		position := sa[i]
+		sa[i] = -1
+		bucket[text[position]]--
+		sa[bucket[text[position]]] = position
+	}
+
+	induce(text, sa, sType, bucket)
+}
+
+// getBuckets sets where each character's bucket starts, or if end is true,
+// where it ends.
+func getBuckets[C textChar, T saInt](text []C, bucket []T, end bool) {
+	for i := range bucket {
+		bucket[i] = 0
+	}
+
+	for _, c := range text {
+		bucket[c]++
+	}
+
+	var sum T
+	for i, count := range bucket {
+		sum += count
+		if end {
+			bucket[i] = sum
+		} else {
+			bucket[i] = sum - count
+		}
+	}
+}
+
+// induce sorts the L type suffixes from the LMS ones in sa, then the S type
+// ones from the L type ones.
+func induce[C textChar, T saInt](text []C, sa []T, sType []bool, bucket []T) {
+	n := len(text)
+
+	getBuckets(text, bucket, false)
+
+	// The suffix before the sentinel comes first in its bucket.
+	bucket[text[n-1]]++
+	sa[bucket[text[n-1]]-1] = T(n - 1)
+
+	for i := 0; i < n; i++ {
+		j := int(sa[i]) - 1
+		if j >= 0 && !sType[j] {
+			sa[bucket[text[j]]] = T(j)
+			bucket[text[j]]++
+		}
+	}
+
+	getBuckets(text, bucket, true)
+
+	for i := n - 1; i >= 0; i-- {
+		j := int(sa[i]) - 1
+		if j >= 0 && sType[j] {
+			bucket[text[j]]--
+			sa[bucket[text[j]]] = T(j)
+		}
+	}
+}
+
+// lmsSubstringsEqual checks whether the LMS substrings starting at a and b are
+// the same. An LMS substring runs to the next LMS suffix, or the sentinel.
+func lmsSubstringsEqual[C textChar](text []C, sType []bool, a, b int) bool {
+	n := len(text)
+	for d := 0; ; d++ {
+		// Only one substring has the sentinel.
+		if a+d == n || b+d == n {
+			return false
+		}
+
+		if text[a+d] != text[b+d] || sType[a+d] != sType[b+d] {
+			return false
+		}
+
+		// The characters and types so far are the same, so if one is at the
+		// next LMS suffix, both are.
+		if d > 0 && !sType[a+d-1] && sType[a+d] {
+			return true
+		}
+	}
+}
+
+// kasai builds the LCP array of a text from its suffix array using Kasai et
+// al.'s algorithm.
+func kasai[T saInt](text []byte, sa []T) []T {
+	n := len(text)
+
+	rank := make([]T, n)
+	for i, offset := range sa {
+		rank[offset] = T(i)
+	}
+
+	lcp := make([]T, n)
+
+	h := 0
+	for i := 0; i < n; i++ {
+		r := rank[i]
+		if r == 0 {
+			h = 0
+			continue
+		}
+
+		j := int(sa[r-1])
+		for i+h < n && j+h < n && text[i+h] == text[j+h] {
+			h++
+		}
+		lcp[r] = T(h)
+
+		// The next suffix shares at least one fewer character with its
+		// neighbour.
+		if h > 0 {
+			h--
+		}
+	}
+
+	return lcp
+}
@@ -0,0 +1,249 @@
+/*
+ * Storing a suffix array on disk.
+ *
+ * The file is:
+ *
+ *   magic, "IRSSISA\x00"
+ *   version: uint32
+ *   size of each offset in bytes, 4 or 8: uint32
+ *   length of the text: uint64
+ *   length of the LCP array, 0 if we didn't store it: uint64
+ *   the text
+ *   padding with zeros to a multiple of 8 bytes
+ *   the suffix array: an offset for each suffix, in sorted order
+ *   the LCP array, if we have it, with offsets the same size
+ *
+ * Numbers are little endian. Offsets are fixed size and aligned so the file
+ * can be mapped into memory and used as is.
+ */
+
+package suffixarray
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+const storeMagic = "IRSSISA\x00"
+
+// Version 1 held the suffixes of words from Build, always with 8 byte
+// offsets.
+const storeVersion = 2
+
+const headerSize = len(storeMagic) + 4 + 4 + 8 + 8
+
+// nativeLittleEndian is whether this machine stores numbers the way the file
+// does. If so we can use the arrays in the file without decoding them.
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// Store writes the index to disk. This is so it can be restored later without
+// building it again. If we built the LCP array, we store that too.
+func (ix *Index) Store(file string) error {
+	tmpFile := file + ".tmp"
+
+	fh, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("Unable to open file: %s: %s", tmpFile, err.Error())
+	}
+
+	err = ix.write(fh)
+	if err != nil {
+		_ = fh.Close()
+		_ = os.Remove(tmpFile)
+		return err
+	}
+
+	err = fh.Close()
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return fmt.Errorf("Unable to close file: %s: %s", tmpFile, err.Error())
+	}
+
+	err = os.Rename(tmpFile, file)
+	if err != nil {
+		return fmt.Errorf("Unable to rename file: %s: %s", tmpFile, err.Error())
+	}
+
+	return nil
+}
+
+// write writes the file's contents.
+func (ix *Index) write(fh *os.File) error {
+	writer := bufio.NewWriter(fh)
+
+	offsetSize := 4
+	if ix.sa.int64 != nil {
+		offsetSize = 8
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, storeMagic)
+	pos := len(storeMagic)
+	binary.LittleEndian.PutUint32(header[pos:], storeVersion)
+	binary.LittleEndian.PutUint32(header[pos+4:], uint32(offsetSize))
+	binary.LittleEndian.PutUint64(header[pos+8:], uint64(len(ix.text)))
+	binary.LittleEndian.PutUint64(header[pos+16:], uint64(ix.lcp.len()))
+
+	_, err := writer.Write(header)
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+
+	_, err = writer.Write(ix.text)
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+
+	_, err = writer.Write(make([]byte, padding(len(ix.text))))
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+
+	err = writeInts(writer, &ix.sa, offsetSize)
+	if err != nil {
+		return err
+	}
+
+	err = writeInts(writer, &ix.lcp, offsetSize)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+
+	return nil
+}
+
+// writeInts writes each of an array's offsets.
+func writeInts(writer *bufio.Writer, a *ints, offsetSize int) error {
+	buf := make([]byte, offsetSize)
+	for i := 0; i < a.len(); i++ {
+		if offsetSize == 8 {
+			binary.LittleEndian.PutUint64(buf, uint64(a.get(i)))
+		} else {
+			binary.LittleEndian.PutUint32(buf, uint32(a.get(i)))
+		}
+
+		_, err := writer.Write(buf)
+		if err != nil {
+			return fmt.Errorf("Unable to write: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Load restores an index written by Store.
+//
+// Where we can, we map the file into memory rather than reading it. The
+// mapping lasts until the program exits, since the index uses it.
+func Load(file string) (*Index, error) {
+	data, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ix, err := decode(data, file)
+	if err != nil {
+		unmapFile(data)
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// decode gives the index in the contents of a file written by Store. The index
+// uses data's memory.
+func decode(data []byte, file string) (*Index, error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(storeMagic)) {
+		return nil, fmt.Errorf("Not a suffix array file: %s", file)
+	}
+
+	pos := len(storeMagic)
+	version := binary.LittleEndian.Uint32(data[pos:])
+	offsetSize := binary.LittleEndian.Uint32(data[pos+4:])
+	textLength := binary.LittleEndian.Uint64(data[pos+8:])
+	lcpLength := binary.LittleEndian.Uint64(data[pos+16:])
+
+	if version != storeVersion {
+		return nil, fmt.Errorf("Unsupported suffix array version: %s: %d", file,
+			version)
+	}
+
+	if offsetSize != 4 && offsetSize != 8 {
+		return nil, fmt.Errorf("Unsupported offset size: %s: %d", file,
+			offsetSize)
+	}
+
+	if lcpLength != 0 && lcpLength != textLength {
+		return nil, fmt.Errorf("Corrupt suffix array file: %s", file)
+	}
+
+	// Check the sizes against the file's before using them, so a corrupt file
+	// can't have us go out of bounds.
+	remaining := uint64(len(data) - headerSize)
+	if textLength > remaining/uint64(offsetSize+1) ||
+		textLength+uint64(padding(int(textLength)))+
+			(textLength+lcpLength)*uint64(offsetSize) != remaining {
+		return nil, fmt.Errorf("Corrupt suffix array file: %s", file)
+	}
+
+	n := int(textLength)
+	pos = headerSize
+
+	ix := &Index{text: data[pos : pos+n]}
+	pos += n + padding(n)
+
+	size := n * int(offsetSize)
+	ix.sa = decodeInts(data[pos:pos+size], int(offsetSize))
+	pos += size
+
+	if lcpLength > 0 {
+		ix.lcp = decodeInts(data[pos:pos+size], int(offsetSize))
+	}
+
+	return ix, nil
+}
+
+// decodeInts gives the array of offsets in b. If we can, it shares b's memory
+// rather than copying it.
+func decodeInts(b []byte, offsetSize int) ints {
+	n := len(b) / offsetSize
+
+	if offsetSize == 8 {
+		if nativeLittleEndian && n > 0 {
+			return ints{int64: unsafe.Slice((*int64)(unsafe.Pointer(&b[0])), n)}
+		}
+
+		a := make([]int64, n)
+		for i := range a {
+			a[i] = int64(binary.LittleEndian.Uint64(b[i*8:]))
+		}
+		return ints{int64: a}
+	}
+
+	if nativeLittleEndian && n > 0 {
+		return ints{int32: unsafe.Slice((*int32)(unsafe.Pointer(&b[0])), n)}
+	}
+
+	a := make([]int32, n)
+	for i := range a {
+		a[i] = int32(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return ints{int32: a}
+}
+
+// padding gives how many bytes to add after a text of the given length to
+// align the arrays.
+func padding(length int) int {
+	return (8 - length%8) % 8
+}
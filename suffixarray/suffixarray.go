@@ -1,41 +1,164 @@
 /*
  * suffixarray provides a simple suffix array implementation.
  *
- * I use it for text generation.
+ * I use it for text generation, and for finding substrings in logs.
  *
- * Note there is a suffixarray in the core library (index/suffixarray).
+ * Note there is a suffixarray in the core library (index/suffixarray). Ours
+ * adds an LCP array, lets you walk the array, and can be stored and restored.
  */
 
 package suffixarray
 
 import (
+	"bytes"
+	"math"
 	"sort"
 )
 
-// Build takes a text and generates a suffix array.
-func Build(text string) ([]string, error) {
-	var suffixes []string
-	suffixes = append(suffixes, text[0:])
+// maxData32 is the largest text for which we use 32 bit offsets. It is a
+// variable so tests can try 64 bit offsets on small texts.
+var maxData32 = math.MaxInt32
 
-	for i, c := range text {
-		if c == ' ' {
-			suffixes = append(suffixes, text[i+1:])
-			continue
-		}
+// Index is a suffix array of every position in a text. It finds substrings
+// anywhere, including in the middle of words.
+type Index struct {
+	text []byte
+
+	// sa holds where each suffix starts in text, in sorted order of the
+	// suffixes.
+	sa ints
+
+	// lcp holds the length of the longest common prefix of each suffix in sa
+	// and the one before it. It is empty until BuildLCP.
+	lcp ints
+}
+
+// ints is an array of offsets. Only one of the slices is set. We use 32 bit
+// offsets when they're big enough as they take half the memory.
+type ints struct {
+	int32 []int32
+	int64 []int64
+}
+
+func (a *ints) len() int {
+	if a.int64 != nil {
+		return len(a.int64)
 	}
+	return len(a.int32)
+}
 
-	return suffixes, nil
+func (a *ints) get(i int) int {
+	if a.int64 != nil {
+		return int(a.int64[i])
+	}
+	return int(a.int32[i])
 }
 
-// Sort sorts the suffix array.
-func Sort(suffixArray []string) ([]string, error) {
-	sort.Strings(suffixArray)
-	return suffixArray, nil
+// New builds an index of a text.
+func New(text []byte) *Index {
+	ix := &Index{text: text}
+
+	if len(text) <= maxData32 {
+		ix.sa.int32 = make([]int32, len(text))
+		sais(text, ix.sa.int32, 256)
+	} else {
+		ix.sa.int64 = make([]int64, len(text))
+		sais(text, ix.sa.int64, 256)
+	}
+
+	return ix
 }
 
-// Store writes a suffix array to disk. This is so it can be restored later.
+// BuildLCP builds the LCP array. You need to call it before LCP.
+func (ix *Index) BuildLCP() {
+	if ix.lcp.int32 != nil || ix.lcp.int64 != nil {
+		return
+	}
+
+	if ix.sa.int64 != nil {
+		ix.lcp.int64 = kasai(ix.text, ix.sa.int64)
+		return
+	}
+	ix.lcp.int32 = kasai(ix.text, ix.sa.int32)
+}
+
+// Bytes gives the indexed text.
+func (ix *Index) Bytes() []byte {
+	return ix.text
+}
+
+// Len gives the number of suffixes, which is the length of the text.
+func (ix *Index) Len() int {
+	return ix.sa.len()
+}
+
+// Offset gives where in the text the suffix at position i of the suffix array
+// starts.
+func (ix *Index) Offset(i int) int {
+	return ix.sa.get(i)
+}
+
+// LCP gives the length of the longest common prefix of the suffix at position
+// i of the suffix array and the one before it. It is 0 for the first.
 //
-// The reason this could be useful is to mean loading and sorting the array
-// is not needed on restore.
-func Store(file string) error {
+// You must call BuildLCP first.
+func (ix *Index) LCP(i int) int {
+	return ix.lcp.get(i)
+}
+
+// Range finds the suffixes starting with s. They are at positions start up to
+// but not including end of the suffix array. If there are none, start and end
+// are equal.
+func (ix *Index) Range(s []byte) (int, int) {
+	if len(s) == 0 {
+		return 0, 0
+	}
+
+	n := ix.sa.len()
+
+	start := sort.Search(n, func(i int) bool {
+		return bytes.Compare(ix.suffix(i, len(s)), s) >= 0
+	})
+
+	end := start + sort.Search(n-start, func(i int) bool {
+		return !bytes.Equal(ix.suffix(start+i, len(s)), s)
+	})
+
+	return start, end
+}
+
+// Count gives how many times s occurs in the text.
+func (ix *Index) Count(s []byte) int {
+	start, end := ix.Range(s)
+	return end - start
+}
+
+// Lookup gives the offsets where s occurs in the text, in increasing order. If
+// n is positive, we give at most n, though not necessarily the first n.
+func (ix *Index) Lookup(s []byte, n int) []int {
+	start, end := ix.Range(s)
+	if n > 0 && end-start > n {
+		end = start + n
+	}
+
+	if start == end {
+		return nil
+	}
+
+	found := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		found = append(found, ix.sa.get(i))
+	}
+	sort.Ints(found)
+	return found
+}
+
+// suffix gives at most the first n bytes of the suffix at position i of the
+// suffix array.
+func (ix *Index) suffix(i, n int) []byte {
+	suffix := ix.text[ix.sa.get(i):]
+	if len(suffix) > n {
+		return suffix[:n]
+	}
+	return suffix
 }
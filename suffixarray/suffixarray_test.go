@@ -0,0 +1,471 @@
+package suffixarray
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/horgh/irssi_log"
+)
+
+func TestIndex(t *testing.T) {
+	text := "banana bandana and a cabana"
+	index := New([]byte(text))
+
+	patterns := []string{"a", "an", "ana", "banana", "ban", "na ", "and", "x",
+		"cabana", "cabanas", " ", text}
+
+	for _, pattern := range patterns {
+		var wanted []int
+		for i := 0; i+len(pattern) <= len(text); i++ {
+			if strings.HasPrefix(text[i:], pattern) {
+				wanted = append(wanted, i)
+			}
+		}
+
+		found := index.Lookup([]byte(pattern), 0)
+		if !intsEqual(found, wanted) {
+			t.Errorf("Lookup(%q) = %v, wanted %v", pattern, found, wanted)
+		}
+
+		if count := index.Count([]byte(pattern)); count != len(wanted) {
+			t.Errorf("Count(%q) = %d, wanted %d", pattern, count, len(wanted))
+		}
+	}
+
+	if found := index.Lookup([]byte("an"), 2); len(found) != 2 {
+		t.Errorf("Lookup with a limit of 2 found %v", found)
+	}
+
+	if count := index.Count(nil); count != 0 {
+		t.Errorf("Count of empty pattern = %d, wanted 0", count)
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	fh, err := os.Open(filepath.Join("..", "testdata", "sample.log"))
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	entries, err := irssi_log.ParseLog(fh, 0, location)
+	if err != nil {
+		t.Fatalf("Unable to parse log: %s", err.Error())
+	}
+
+	corpus := NewCorpus(irssi_log.FilterEntries(entries,
+		irssi_log.HasType(irssi_log.Message)))
+
+	type TestCase struct {
+		Pattern   string
+		Lines     []int
+		Positions []int
+	}
+
+	cases := []TestCase{
+		{"deploy", []int{7, 8, 21, 27, 28, 35, 36}, []int{22, 10, 26, 8, 13, 0, 0}},
+		// Mid-word.
+		{"ploy", []int{7, 8, 21, 27, 28, 35, 36}, []int{24, 12, 28, 10, 15, 2, 2}},
+		{"yed(ed)", []int{36}, []int{5}},
+		// A match can't run from one message into the next.
+		{"going?hello", nil, nil},
+		{"fine\nit", nil, nil},
+		{"Deploy", nil, nil},
+	}
+
+	for _, c := range cases {
+		hits := corpus.Search(c.Pattern, 0)
+
+		var lines, positions []int
+		for _, hit := range hits {
+			lines = append(lines, hit.Entry.LineNumber)
+			positions = append(positions, hit.Position)
+
+			text := hit.Entry.Text[hit.Position:]
+			if !strings.HasPrefix(text, c.Pattern) {
+				t.Errorf("Hit for %q at %q", c.Pattern, text)
+			}
+		}
+
+		if !intsEqual(lines, c.Lines) || !intsEqual(positions, c.Positions) {
+			t.Errorf("Search(%q) found lines %v positions %v, wanted %v %v",
+				c.Pattern, lines, positions, c.Lines, c.Positions)
+		}
+
+		if count := corpus.Count(c.Pattern); count != len(c.Lines) {
+			t.Errorf("Count(%q) = %d, wanted %d", c.Pattern, count, len(c.Lines))
+		}
+	}
+
+	hits := corpus.Search("deploy", 2)
+	if len(hits) != 2 || hits[0].Entry.LineNumber != 7 ||
+		hits[1].Entry.LineNumber != 8 {
+		t.Errorf("Search with a limit of 2 found %+v", hits)
+	}
+}
+
+func TestNew(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	// Small alphabets give lots of repeats, which is where it gets hard.
+	var texts []string
+	for _, alphabet := range []string{"a", "ab", "abc", "ab \x00\xff"} {
+		for length := 0; length < 64; length++ {
+			text := make([]byte, length)
+			for i := range text {
+				text[i] = alphabet[random.Intn(len(alphabet))]
+			}
+			texts = append(texts, string(text))
+		}
+	}
+	texts = append(texts, "mississippi", "banana bandana and a cabana")
+
+	defer func(max int) {
+		maxData32 = max
+	}(maxData32)
+
+	for _, max := range []int{math.MaxInt32, 0} {
+		maxData32 = max
+
+		for _, text := range texts {
+			index := New([]byte(text))
+			index.BuildLCP()
+
+			wanted := naiveSuffixArray(text)
+
+			var found []int
+			for i := 0; i < index.Len(); i++ {
+				found = append(found, index.Offset(i))
+			}
+
+			if !intsEqual(found, wanted) {
+				t.Errorf("Suffix array of %q = %v, wanted %v", text, found, wanted)
+				continue
+			}
+
+			for i := 1; i < len(wanted); i++ {
+				a, b := text[wanted[i-1]:], text[wanted[i]:]
+				lcp := 0
+				for lcp < len(a) && lcp < len(b) && a[lcp] == b[lcp] {
+					lcp++
+				}
+
+				if index.LCP(i) != lcp {
+					t.Errorf("LCP(%d) of %q = %d, wanted %d", i, text, index.LCP(i),
+						lcp)
+				}
+			}
+		}
+	}
+}
+
+func TestRepeats(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	day := func(d int) time.Time {
+		return time.Date(2016, 3, d, 12, 0, 0, 0, location)
+	}
+
+	entries := []*irssi_log.LogEntry{
+		{Nick: "alice", Time: day(1), Text: "please deploy the thing now"},
+		{Nick: "bob", Time: day(2), Text: "please deploy the thing now"},
+		{Nick: "carol", Time: day(3), Text: "ok please deploy the thing"},
+		{Nick: "Alice", Time: day(4), Text: "lol"},
+		{Nick: "bob", Time: day(5), Text: "lol"},
+		{Nick: "dave", Time: day(6), Text: "LOL"},
+		{Nick: "dave", Time: day(7), Text: "reploy"},
+	}
+
+	corpus := NewCorpus(entries)
+
+	type TestCase struct {
+		WholeWords bool
+		MinLength  int
+		Repeats    []Repeat
+	}
+
+	cases := []TestCase{
+		{
+			WholeWords: true,
+			MinLength:  3,
+			Repeats: []Repeat{
+				{"please deploy the thing", 3, []string{"alice", "bob", "carol"}, day(1),
+					day(3)},
+				{"please deploy the thing now", 2, []string{"alice", "bob"}, day(1),
+					day(2)},
+				{"lol", 2, []string{"Alice", "bob"}, day(4), day(5)},
+			},
+		},
+		{
+			WholeWords: true,
+			MinLength:  24,
+			Repeats: []Repeat{
+				{"please deploy the thing now", 2, []string{"alice", "bob"}, day(1),
+					day(2)},
+			},
+		},
+		{
+			WholeWords: false,
+			MinLength:  5,
+			Repeats: []Repeat{
+				// Mid-word.
+				{"eploy", 4, []string{"alice", "bob", "carol", "dave"}, day(1), day(7)},
+				{"please deploy the thing", 3, []string{"alice", "bob", "carol"}, day(1),
+					day(3)},
+				{"please deploy the thing now", 2, []string{"alice", "bob"}, day(1),
+					day(2)},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		repeats := corpus.Repeats(c.MinLength, c.WholeWords)
+
+		if len(repeats) != len(c.Repeats) {
+			t.Errorf("Repeats(%d, %v) = %+v, wanted %+v", c.MinLength, c.WholeWords,
+				repeats, c.Repeats)
+			continue
+		}
+
+		for i, r := range repeats {
+			wanted := c.Repeats[i]
+			if r.Text != wanted.Text || r.Count != wanted.Count ||
+				strings.Join(r.Nicks, ",") != strings.Join(wanted.Nicks, ",") ||
+				!r.First.Equal(wanted.First) || !r.Last.Equal(wanted.Last) {
+				t.Errorf("Repeats(%d, %v)[%d] = %+v, wanted %+v", c.MinLength,
+					c.WholeWords, i, r, wanted)
+			}
+		}
+	}
+
+	// Words may be separated by more than one space.
+	corpus = NewCorpus([]*irssi_log.LogEntry{
+		{Nick: "alice", Time: day(1), Text: "we  ship it  today"},
+		{Nick: "bob", Time: day(2), Text: "they  ship it  now"},
+	})
+
+	repeats := corpus.Repeats(3, true)
+	if len(repeats) != 1 || repeats[0].Text != "ship it" ||
+		repeats[0].Count != 2 {
+		t.Errorf("Repeats(3, true) = %+v, wanted ship it twice", repeats)
+	}
+}
+
+func TestStoreLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	defer func(max int) {
+		maxData32 = max
+	}(maxData32)
+
+	// Text lengths around the padding, with both offset sizes, and with and
+	// without the LCP array.
+	texts := []string{"", "a", "hi there", "hi there you", "the cat sat on the mat"}
+
+	for _, max := range []int{math.MaxInt32, 0} {
+		maxData32 = max
+
+		for i, text := range texts {
+			index := New([]byte(text))
+			if i%2 == 0 {
+				index.BuildLCP()
+			}
+
+			file := filepath.Join(dir, "array")
+			err = index.Store(file)
+			if err != nil {
+				t.Fatalf("Unable to store: %s", err.Error())
+			}
+
+			loaded, err := Load(file)
+			if err != nil {
+				t.Fatalf("Unable to load: %s", err.Error())
+			}
+
+			if string(loaded.Bytes()) != text || loaded.Len() != index.Len() ||
+				loaded.lcp.len() != index.lcp.len() ||
+				(loaded.sa.int64 != nil) != (index.sa.int64 != nil) {
+				t.Errorf("Loaded %q with %d suffixes, %d LCPs, wanted %q with %d, %d",
+					loaded.Bytes(), loaded.Len(), loaded.lcp.len(), text, index.Len(),
+					index.lcp.len())
+				continue
+			}
+
+			for j := 0; j < index.Len(); j++ {
+				if loaded.Offset(j) != index.Offset(j) {
+					t.Errorf("Loaded offset %d = %d, wanted %d", j, loaded.Offset(j),
+						index.Offset(j))
+				}
+				if index.lcp.len() > 0 && loaded.LCP(j) != index.LCP(j) {
+					t.Errorf("Loaded LCP %d = %d, wanted %d", j, loaded.LCP(j),
+						index.LCP(j))
+				}
+			}
+
+			if loaded.Count([]byte("t")) != strings.Count(text, "t") {
+				t.Errorf("Loaded index of %q counted %d t", text,
+					loaded.Count([]byte("t")))
+			}
+		}
+	}
+
+	file := filepath.Join(dir, "corrupt")
+	err = ioutil.WriteFile(file, []byte(storeMagic+"\x02\x00\x00\x00"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+
+	_, err = Load(file)
+	if err == nil {
+		t.Errorf("Loaded a truncated file")
+	}
+
+	// Files with a bad header. We release the mapping of each.
+
+	err = New([]byte("hi there")).Store(file)
+	if err != nil {
+		t.Fatalf("Unable to store: %s", err.Error())
+	}
+
+	stored, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	pos := len(storeMagic)
+	for i, corrupt := range []func(buf []byte){
+		func(buf []byte) { buf[0] = 'X' },
+		func(buf []byte) { buf[pos] = storeVersion + 1 },
+		func(buf []byte) { buf[pos+4] = 3 },
+		func(buf []byte) { buf[pos+8]++ },
+	} {
+		buf := append([]byte{}, stored...)
+		corrupt(buf)
+
+		err := ioutil.WriteFile(file, buf, 0644)
+		if err != nil {
+			t.Fatalf("Unable to write file: %s", err.Error())
+		}
+
+		_, err = Load(file)
+		if err == nil {
+			t.Errorf("Loaded corrupt file %d", i)
+		}
+	}
+
+	maps, err := ioutil.ReadFile("/proc/self/maps")
+	if err == nil && strings.Contains(string(maps), file) {
+		t.Errorf("A corrupt file is still mapped")
+	}
+}
+
+// BenchmarkNew builds suffix arrays of text like a log's. The bytes per
+// second and bytes allocated per operation show how it scales. Memory is about
+// 6 bytes per byte of text with 32 bit offsets, and 10 with 64 bit ones, so a
+// multi-GB corpus fits in memory.
+func BenchmarkNew(b *testing.B) {
+	for _, size := range []int{1 << 20, 16 << 20} {
+		text := benchmarkText(size)
+
+		b.Run(fmt.Sprintf("%dMiB", size>>20), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				New(text)
+			}
+		})
+	}
+}
+
+func BenchmarkBuildLCP(b *testing.B) {
+	text := benchmarkText(16 << 20)
+	index := New(text)
+
+	b.SetBytes(int64(len(text)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		index.lcp = ints{}
+		index.BuildLCP()
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	text := benchmarkText(16 << 20)
+	index := New(text)
+	pattern := []byte("deploy the")
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		index.Count(pattern)
+	}
+}
+
+// benchmarkText makes text of the given size from random words.
+func benchmarkText(size int) []byte {
+	random := rand.New(rand.NewSource(1))
+
+	words := []string{"the", "deploy", "is", "done", "alice:", "bob", "ok", "lol",
+		"it", "works", "on", "my", "machine", "what", "about", "tuesday?", "no"}
+
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[random.Intn(len(words))])
+		buf.WriteByte(' ')
+	}
+
+	return buf.Bytes()[:size]
+}
+
+// naiveSuffixArray sorts a text's suffixes by comparing them.
+func naiveSuffixArray(text string) []int {
+	var offsets []int
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+
+	sort.Slice(offsets, func(i, j int) bool {
+		return text[offsets[i]:] < text[offsets[j]:]
+	})
+
+	return offsets
+}
+
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
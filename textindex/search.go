@@ -0,0 +1,364 @@
+/*
+ * Searching an index.
+ *
+ * A query is words separated by spaces. We find entries with all of them.
+ * Words in double quotes are a phrase, and must be next to each other in
+ * order. A word ending in * matches any word starting with it.
+ *
+ * For example: deploy* "is stuck"
+ */
+
+package textindex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/horgh/irssi_log"
+)
+
+// phraseWord is a word in a phrase.
+type phraseWord struct {
+	term string
+
+	// prefix is whether the word matches any term starting with it.
+	prefix bool
+}
+
+// hit is a document a search found.
+type hit struct {
+	file *indexedFile
+	doc  document
+}
+
+// Search finds entries with text matching the query. Results are in time
+// order. If limit is positive we return at most that many.
+//
+// Searching does not update the index. Entries added to logs since the last
+// update are not found.
+func (ix *Index) Search(query string, limit int) ([]Result, error) {
+	phrases, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	live := ix.liveFiles()
+
+	var hits []hit
+	for _, s := range ix.segments {
+		docNums, err := s.search(phrases)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, docNum := range docNums {
+			doc := s.docs[docNum]
+			file, exists := live[doc.fileID]
+			if !exists {
+				continue
+			}
+			hits = append(hits, hit{file: file, doc: doc})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].doc.time != hits[j].doc.time {
+			return hits[i].doc.time < hits[j].doc.time
+		}
+		if hits[i].file.ID != hits[j].file.ID {
+			return hits[i].file.ID < hits[j].file.ID
+		}
+		return hits[i].doc.offset < hits[j].doc.offset
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	return readHits(hits)
+}
+
+// parseSearchQuery splits a query into phrases. A word on its own is a phrase
+// of one word.
+func parseSearchQuery(query string) ([][]phraseWord, error) {
+	var phrases [][]phraseWord
+
+	parts := strings.Split(query, "\"")
+	if len(parts)%2 == 0 {
+		return nil, fmt.Errorf("Unbalanced quotes in query: %s", query)
+	}
+
+	for i, part := range parts {
+		// Odd parts were in quotes.
+		if i%2 == 1 {
+			phrase := parsePhrase(part)
+			if len(phrase) > 0 {
+				phrases = append(phrases, phrase)
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			phrase := parsePhrase(word)
+			if len(phrase) > 0 {
+				phrases = append(phrases, phrase)
+			}
+		}
+	}
+
+	if len(phrases) == 0 {
+		return nil, fmt.Errorf("Query has no words: %s", query)
+	}
+
+	return phrases, nil
+}
+
+// parsePhrase tokenizes words into a phrase. Only the last word may be a
+// prefix, since a * ends a word when we tokenize.
+func parsePhrase(text string) []phraseWord {
+	var phrase []phraseWord
+	for _, term := range Tokenize(text) {
+		phrase = append(phrase, phraseWord{term: term})
+	}
+
+	if len(phrase) > 0 && strings.HasSuffix(strings.TrimSpace(text), "*") {
+		phrase[len(phrase)-1].prefix = true
+	}
+
+	return phrase
+}
+
+// search finds the documents in the segment matching every phrase. It gives
+// their numbers in order.
+func (s *segment) search(phrases [][]phraseWord) ([]int, error) {
+	var docNums []int
+	for i, phrase := range phrases {
+		matches, err := s.searchPhrase(phrase)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			docNums = matches
+		} else {
+			docNums = intersect(docNums, matches)
+		}
+
+		if len(docNums) == 0 {
+			return nil, nil
+		}
+	}
+
+	return docNums, nil
+}
+
+// searchPhrase finds the documents in the segment with the phrase.
+func (s *segment) searchPhrase(phrase []phraseWord) ([]int, error) {
+	// Postings of each word. Positions where the word is at the position the
+	// phrase needs, shifted back to where the phrase starts.
+	var candidates []posting
+
+	for i, word := range phrase {
+		postings, err := s.wordPostings(word)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			candidates = postings
+		} else {
+			candidates = followedBy(candidates, postings, i)
+		}
+
+		if len(candidates) == 0 {
+			return nil, nil
+		}
+	}
+
+	var docNums []int
+	for _, p := range candidates {
+		docNums = append(docNums, p.doc)
+	}
+	return docNums, nil
+}
+
+// wordPostings gives the postings for a word. For a prefix we combine the
+// postings of every term it matches.
+func (s *segment) wordPostings(word phraseWord) ([]posting, error) {
+	if !word.prefix {
+		return s.termPostings(word.term)
+	}
+
+	byDoc := map[int]map[int]struct{}{}
+	for _, i := range s.prefixTerms(word.term) {
+		postings, err := s.decodePostings(i)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range postings {
+			positions, exists := byDoc[p.doc]
+			if !exists {
+				positions = map[int]struct{}{}
+				byDoc[p.doc] = positions
+			}
+			for _, position := range p.positions {
+				positions[position] = struct{}{}
+			}
+		}
+	}
+
+	var postings []posting
+	for doc, positionSet := range byDoc {
+		var positions []int
+		for position := range positionSet {
+			positions = append(positions, position)
+		}
+		sort.Ints(positions)
+		postings = append(postings, posting{doc: doc, positions: positions})
+	}
+
+	sort.Slice(postings, func(i, j int) bool {
+		return postings[i].doc < postings[j].doc
+	})
+
+	return postings, nil
+}
+
+// followedBy keeps the phrase starts in candidates where the next word is
+// at distance words after the start.
+func followedBy(candidates, next []posting, distance int) []posting {
+	var kept []posting
+
+	i, j := 0, 0
+	for i < len(candidates) && j < len(next) {
+		if candidates[i].doc < next[j].doc {
+			i++
+			continue
+		}
+		if candidates[i].doc > next[j].doc {
+			j++
+			continue
+		}
+
+		nextPositions := map[int]struct{}{}
+		for _, position := range next[j].positions {
+			nextPositions[position] = struct{}{}
+		}
+
+		var positions []int
+		for _, start := range candidates[i].positions {
+			if _, exists := nextPositions[start+distance]; exists {
+				positions = append(positions, start)
+			}
+		}
+
+		if len(positions) > 0 {
+			kept = append(kept, posting{doc: candidates[i].doc, positions: positions})
+		}
+
+		i++
+		j++
+	}
+
+	return kept
+}
+
+// intersect gives the numbers in both sorted lists.
+func intersect(a, b []int) []int {
+	var both []int
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			both = append(both, a[i])
+			i++
+			j++
+		}
+	}
+	return both
+}
+
+// readHits reads the entries for hits from their logs.
+func readHits(hits []hit) ([]Result, error) {
+	files := map[*indexedFile]*os.File{}
+	defer func() {
+		for _, fh := range files {
+			_ = fh.Close()
+		}
+	}()
+
+	locations := map[*indexedFile]*time.Location{}
+
+	var results []Result
+	for _, h := range hits {
+		fh, exists := files[h.file]
+		if !exists {
+			var err error
+			fh, err = os.Open(h.file.Path)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to open file: %s: %s", h.file.Path,
+					err.Error())
+			}
+			files[h.file] = fh
+
+			// If the log changed since we indexed it, offsets may be wrong.
+			err = h.file.Checkpoint.Check(fh)
+			if err != nil {
+				if err == irssi_log.ErrStaleCheckpoint {
+					return nil, fmt.Errorf("Log changed since it was indexed. Update the index: %s",
+						h.file.Path)
+				}
+				return nil, err
+			}
+
+			location, err := time.LoadLocation(h.file.Checkpoint.Location)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid location: %s", err.Error())
+			}
+			locations[h.file] = location
+		}
+
+		location := locations[h.file]
+
+		line, err := readLineAt(fh, h.doc.offset)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read line %d of %s: %s",
+				h.doc.lineNumber, h.file.Path, err.Error())
+		}
+
+		entry, err := irssi_log.ParseLine(line, location,
+			time.Unix(h.doc.time, 0).In(location))
+		if err != nil {
+			return nil, err
+		}
+
+		entry.LineNumber = h.doc.lineNumber
+		entry.Offset = h.doc.offset
+
+		results = append(results, Result{File: h.file.Path, Entry: entry})
+	}
+
+	return results, nil
+}
+
+// readLineAt reads the line starting at an offset, without its line ending.
+func readLineAt(fh *os.File, offset int64) (string, error) {
+	reader := bufio.NewReader(io.NewSectionReader(fh, offset, 1<<62))
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
@@ -0,0 +1,340 @@
+/*
+ * Segments hold part of an index.
+ *
+ * Each update writes a new segment with the entries it added. A segment file
+ * is:
+ *
+ *   magic, "IRSSITIX"
+ *   version, uvarint
+ *   document count, uvarint
+ *   for each document:
+ *     file id, offset, line number: uvarint
+ *     time: varint, Unix seconds
+ *   term count, uvarint
+ *   for each term, in sorted order:
+ *     length: uvarint, then the term's bytes
+ *     size of its postings: uvarint
+ *   postings for each term, in the same order:
+ *     posting count: uvarint
+ *     for each posting:
+ *       document number minus the previous one: uvarint
+ *       position count: uvarint
+ *       each position minus the previous one: uvarint
+ */
+
+package textindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/horgh/irssi_log"
+)
+
+const segmentMagic = "IRSSITIX"
+
+const segmentVersion = 1
+
+// document is an entry in a segment.
+type document struct {
+	fileID     int
+	offset     int64
+	lineNumber int
+
+	// Unix time in seconds
+	time int64
+}
+
+// posting records where a term is in a document.
+type posting struct {
+	doc int
+
+	// Token positions of the term in the document's text, in order
+	positions []int
+}
+
+// segment is a loaded segment file.
+type segment struct {
+	docs []document
+
+	// Sorted terms, with where each one's postings are in data.
+	terms         []string
+	postingsStart []int
+	postingsEnd   []int
+
+	data []byte
+}
+
+// segmentBuilder collects documents and postings for a new segment.
+type segmentBuilder struct {
+	docs     []document
+	postings map[string][]posting
+}
+
+func newSegmentBuilder() *segmentBuilder {
+	return &segmentBuilder{postings: map[string][]posting{}}
+}
+
+// add adds a document with the given tokens.
+func (b *segmentBuilder) add(doc document, tokens []string) {
+	docNum := len(b.docs)
+	b.docs = append(b.docs, doc)
+
+	for position, token := range tokens {
+		postings := b.postings[token]
+		if len(postings) > 0 && postings[len(postings)-1].doc == docNum {
+			last := &postings[len(postings)-1]
+			last.positions = append(last.positions, position)
+			continue
+		}
+
+		b.postings[token] = append(postings, posting{
+			doc:       docNum,
+			positions: []int{position},
+		})
+	}
+}
+
+// write writes the segment to a file.
+func (b *segmentBuilder) write(filename string) error {
+	var terms []string
+	for term := range b.postings {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(segmentMagic)
+	putUvarint(buf, segmentVersion)
+
+	putUvarint(buf, uint64(len(b.docs)))
+	for _, doc := range b.docs {
+		putUvarint(buf, uint64(doc.fileID))
+		putUvarint(buf, uint64(doc.offset))
+		putUvarint(buf, uint64(doc.lineNumber))
+		putVarint(buf, doc.time)
+	}
+
+	var postingsBufs []*bytes.Buffer
+	for _, term := range terms {
+		postingsBuf := &bytes.Buffer{}
+		postings := b.postings[term]
+
+		putUvarint(postingsBuf, uint64(len(postings)))
+		lastDoc := 0
+		for _, p := range postings {
+			putUvarint(postingsBuf, uint64(p.doc-lastDoc))
+			lastDoc = p.doc
+
+			putUvarint(postingsBuf, uint64(len(p.positions)))
+			lastPosition := 0
+			for _, position := range p.positions {
+				putUvarint(postingsBuf, uint64(position-lastPosition))
+				lastPosition = position
+			}
+		}
+
+		postingsBufs = append(postingsBufs, postingsBuf)
+	}
+
+	putUvarint(buf, uint64(len(terms)))
+	for i, term := range terms {
+		putUvarint(buf, uint64(len(term)))
+		buf.WriteString(term)
+		putUvarint(buf, uint64(postingsBufs[i].Len()))
+	}
+
+	for _, postingsBuf := range postingsBufs {
+		buf.Write(postingsBuf.Bytes())
+	}
+
+	return irssi_log.WriteFileAtomically(filename, buf.Bytes())
+}
+
+// loadSegment reads a segment file. We decode the documents and terms up
+// front, and postings as we need them.
+func loadSegment(filename string) (*segment, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read segment: %s", err.Error())
+	}
+
+	if !bytes.HasPrefix(data, []byte(segmentMagic)) {
+		return nil, fmt.Errorf("Not a segment file: %s", filename)
+	}
+
+	d := &decoder{buf: data, pos: len(segmentMagic)}
+
+	version := d.uvarint()
+	if d.err == nil && version != segmentVersion {
+		return nil, fmt.Errorf("Unsupported segment version: %s: %d", filename,
+			version)
+	}
+
+	s := &segment{data: data}
+
+	docCount := d.count()
+	for i := 0; i < docCount && d.err == nil; i++ {
+		s.docs = append(s.docs, document{
+			fileID:     int(d.uvarint()),
+			offset:     int64(d.uvarint()),
+			lineNumber: int(d.uvarint()),
+			time:       d.varint(),
+		})
+	}
+
+	termCount := d.count()
+	var sizes []int
+	for i := 0; i < termCount && d.err == nil; i++ {
+		length := d.count()
+		s.terms = append(s.terms, d.bytes(length))
+		sizes = append(sizes, d.count())
+	}
+
+	position := d.pos
+	for _, size := range sizes {
+		s.postingsStart = append(s.postingsStart, position)
+		position += size
+		s.postingsEnd = append(s.postingsEnd, position)
+	}
+
+	if d.err == nil && position != len(data) {
+		d.err = fmt.Errorf("Postings do not match file size")
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("Corrupt segment: %s: %s", filename,
+			d.err.Error())
+	}
+
+	return s, nil
+}
+
+// termPostings gives the postings for a term. It gives nil if the segment
+// doesn't have the term.
+func (s *segment) termPostings(term string) ([]posting, error) {
+	i := sort.SearchStrings(s.terms, term)
+	if i == len(s.terms) || s.terms[i] != term {
+		return nil, nil
+	}
+	return s.decodePostings(i)
+}
+
+// prefixTerms gives the indexes of the terms starting with a prefix.
+func (s *segment) prefixTerms(prefix string) []int {
+	var indexes []int
+	for i := sort.SearchStrings(s.terms, prefix); i < len(s.terms) &&
+		strings.HasPrefix(s.terms[i], prefix); i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// decodePostings decodes the postings of the term at index i.
+func (s *segment) decodePostings(i int) ([]posting, error) {
+	d := &decoder{buf: s.data[:s.postingsEnd[i]], pos: s.postingsStart[i]}
+
+	count := d.count()
+	postings := make([]posting, 0, count)
+	doc := 0
+	for j := 0; j < count && d.err == nil; j++ {
+		doc += d.count()
+
+		positionCount := d.count()
+		positions := make([]int, 0, positionCount)
+		position := 0
+		for k := 0; k < positionCount && d.err == nil; k++ {
+			position += d.count()
+			positions = append(positions, position)
+		}
+
+		postings = append(postings, posting{doc: doc, positions: positions})
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("Corrupt postings for %s: %s", s.terms[i],
+			d.err.Error())
+	}
+
+	return postings, nil
+}
+
+// decoder reads values from a buffer. After an error, it returns zero values
+// and keeps the first error.
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		d.err = fmt.Errorf("Invalid number at byte %d", d.pos)
+		return 0
+	}
+
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf[d.pos:])
+	if n <= 0 {
+		d.err = fmt.Errorf("Invalid number at byte %d", d.pos)
+		return 0
+	}
+
+	d.pos += n
+	return v
+}
+
+// count reads a uvarint that must fit in what is left of the buffer, such as
+// a length. This stops a corrupt count from having us allocate a huge amount.
+func (d *decoder) count() int {
+	v := d.uvarint()
+	if d.err == nil && v > uint64(len(d.buf)) {
+		d.err = fmt.Errorf("Count too large at byte %d", d.pos)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) bytes(length int) string {
+	if d.err != nil {
+		return ""
+	}
+
+	if d.pos+length > len(d.buf) {
+		d.err = fmt.Errorf("Truncated at byte %d", d.pos)
+		return ""
+	}
+
+	s := string(d.buf[d.pos : d.pos+length])
+	d.pos += length
+	return s
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
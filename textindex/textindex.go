@@ -0,0 +1,397 @@
+/*
+ * Package textindex is an on-disk inverted index of the text in Irssi logs.
+ *
+ * We index the text of messages, emotes, and topics. Each word maps to the
+ * entries it is in, and where in them it is. This lets us search for words,
+ * phrases, and word prefixes without reading the logs.
+ *
+ * An index is a directory. It has a manifest recording the logs in the index
+ * and how far into each we got, and segment files holding the postings. Each
+ * update adds a segment. When there are too many we merge them into one.
+ */
+
+package textindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/horgh/irssi_log"
+)
+
+const manifestName = "manifest.json"
+
+const manifestVersion = 1
+
+// maxSegments is how many segments we allow before merging them.
+const maxSegments = 8
+
+// indexedTypes are the entry types whose text we index.
+var indexedTypes = irssi_log.HasType(irssi_log.Message, irssi_log.Emote,
+	irssi_log.Topic)
+
+// Index is an inverted index of one or more logs.
+type Index struct {
+	dir      string
+	manifest *manifest
+	segments []*segment
+}
+
+// manifest records what is in the index.
+type manifest struct {
+	Version int
+
+	// Files are the logs in the index.
+	Files []*indexedFile
+
+	// Segments are the names of the segment files, oldest first.
+	Segments []string
+
+	// Ids to use for the next file and segment.
+	NextFileID    int
+	NextSegmentID int
+}
+
+// indexedFile is a log in the index.
+type indexedFile struct {
+	// ID identifies the file in segments. If a log is replaced, it gets a new
+	// ID. Documents with IDs no longer in the manifest are ignored.
+	ID int
+
+	Path string
+
+	// Checkpoint is how far we indexed.
+	Checkpoint *irssi_log.Checkpoint
+}
+
+// Result is an entry found by a search.
+type Result struct {
+	// File is the path to the log the entry is from.
+	File string
+
+	Entry *irssi_log.LogEntry
+}
+
+// Open opens the index in a directory, creating it if necessary.
+func Open(dir string) (*Index, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create directory: %s: %s", dir,
+			err.Error())
+	}
+
+	index := &Index{
+		dir:      dir,
+		manifest: &manifest{Version: manifestVersion, NextFileID: 1},
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("Unable to read manifest: %s", err.Error())
+	}
+
+	err = json.Unmarshal(buf, index.manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode manifest: %s", err.Error())
+	}
+
+	if index.manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("Unsupported index version: %d",
+			index.manifest.Version)
+	}
+
+	for _, name := range index.manifest.Segments {
+		segment, err := loadSegment(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		index.segments = append(index.segments, segment)
+	}
+
+	return index, nil
+}
+
+// Add indexes the lines in a log that are not yet in the index. It returns
+// how many entries it indexed.
+//
+// The first time we see a log we parse it in the given location. After that
+// we carry on in the location we started with. If the log was replaced, such
+// as by rotation, we index it again from the start.
+func (ix *Index) Add(logFilename string, location *time.Location) (int,
+	error) {
+	path, err := filepath.Abs(logFilename)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find path: %s: %s", logFilename,
+			err.Error())
+	}
+
+	var file *indexedFile
+	for _, f := range ix.manifest.Files {
+		if f.Path == path {
+			file = f
+			break
+		}
+	}
+
+	if file != nil {
+		count, err := ix.update(file, location)
+		if err != nil {
+			return 0, err
+		}
+		return count, ix.save()
+	}
+
+	file = &indexedFile{Path: path}
+
+	count, err := ix.update(file, location)
+	if err != nil {
+		return 0, err
+	}
+
+	ix.manifest.Files = append(ix.manifest.Files, file)
+
+	return count, ix.save()
+}
+
+// Update indexes the lines added to each log in the index since we last
+// indexed it. It returns how many entries it indexed.
+func (ix *Index) Update() (int, error) {
+	total := 0
+	for _, file := range ix.manifest.Files {
+		count, err := ix.update(file, nil)
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, ix.save()
+}
+
+// update indexes what is new in a log. location is for if we have not
+// indexed the log before.
+func (ix *Index) update(file *indexedFile, location *time.Location) (int,
+	error) {
+	fh, err := os.Open(file.Path)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to open file: %s: %s", file.Path,
+			err.Error())
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	checkpoint := file.Checkpoint
+
+	if checkpoint != nil {
+		err := checkpoint.Check(fh)
+		if err != nil {
+			if err != irssi_log.ErrStaleCheckpoint {
+				return 0, err
+			}
+
+			// The log was replaced. We keep its location. Giving it a new ID drops
+			// what we indexed before.
+			location, err = time.LoadLocation(checkpoint.Location)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid location: %s", err.Error())
+			}
+			checkpoint = nil
+			file.ID = 0
+		}
+	}
+
+	if checkpoint == nil {
+		if location == nil {
+			return 0, fmt.Errorf("No location for %s", file.Path)
+		}
+
+		checkpoint, err = irssi_log.NewParser(location).Checkpoint(fh)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if file.ID == 0 {
+		file.ID = ix.manifest.NextFileID
+		ix.manifest.NextFileID++
+	}
+
+	reader, parser, err := irssi_log.NewReaderFromCheckpoint(fh, checkpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	builder := newSegmentBuilder()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+
+		if !indexedTypes.Match(entry) {
+			continue
+		}
+
+		tokens := Tokenize(entry.Text)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		builder.add(document{
+			fileID:     file.ID,
+			offset:     entry.Offset,
+			lineNumber: entry.LineNumber,
+			time:       entry.Time.Unix(),
+		}, tokens)
+	}
+
+	file.Checkpoint, err = parser.Checkpoint(fh)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(builder.docs) > 0 {
+		name := fmt.Sprintf("segment-%06d", ix.manifest.NextSegmentID)
+		ix.manifest.NextSegmentID++
+
+		err := builder.write(filepath.Join(ix.dir, name))
+		if err != nil {
+			return 0, err
+		}
+
+		segment, err := loadSegment(filepath.Join(ix.dir, name))
+		if err != nil {
+			return 0, err
+		}
+
+		ix.manifest.Segments = append(ix.manifest.Segments, name)
+		ix.segments = append(ix.segments, segment)
+	}
+
+	return len(builder.docs), nil
+}
+
+// save writes the manifest, merging segments first if there are too many.
+func (ix *Index) save() error {
+	if len(ix.segments) > maxSegments {
+		return ix.Merge()
+	}
+	return ix.writeManifest()
+}
+
+// Merge combines all segments into one. It drops documents from logs that
+// were replaced.
+func (ix *Index) Merge() error {
+	live := ix.liveFiles()
+
+	builder := newSegmentBuilder()
+	for _, s := range ix.segments {
+		// Map the segment's document numbers to the new ones.
+		docNums := make([]int, len(s.docs))
+		for i, doc := range s.docs {
+			if _, exists := live[doc.fileID]; !exists {
+				docNums[i] = -1
+				continue
+			}
+			docNums[i] = len(builder.docs)
+			builder.docs = append(builder.docs, doc)
+		}
+
+		for i, term := range s.terms {
+			postings, err := s.decodePostings(i)
+			if err != nil {
+				return err
+			}
+
+			for _, p := range postings {
+				if docNums[p.doc] == -1 {
+					continue
+				}
+				builder.postings[term] = append(builder.postings[term], posting{
+					doc:       docNums[p.doc],
+					positions: p.positions,
+				})
+			}
+		}
+	}
+
+	oldSegments := ix.manifest.Segments
+
+	name := fmt.Sprintf("segment-%06d", ix.manifest.NextSegmentID)
+	ix.manifest.NextSegmentID++
+
+	err := builder.write(filepath.Join(ix.dir, name))
+	if err != nil {
+		return err
+	}
+
+	merged, err := loadSegment(filepath.Join(ix.dir, name))
+	if err != nil {
+		return err
+	}
+
+	ix.manifest.Segments = []string{name}
+	ix.segments = []*segment{merged}
+
+	// Switch to the new segment before removing the old ones. If we crash in
+	// between, the old ones are only left over files.
+	err = ix.writeManifest()
+	if err != nil {
+		return err
+	}
+
+	for _, old := range oldSegments {
+		err := os.Remove(filepath.Join(ix.dir, old))
+		if err != nil {
+			return fmt.Errorf("Unable to remove segment: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// liveFiles gives the files in the index by ID.
+func (ix *Index) liveFiles() map[int]*indexedFile {
+	files := map[int]*indexedFile{}
+	for _, file := range ix.manifest.Files {
+		files[file.ID] = file
+	}
+	return files
+}
+
+// writeManifest saves the manifest.
+func (ix *Index) writeManifest() error {
+	buf, err := json.MarshalIndent(ix.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Unable to encode manifest: %s", err.Error())
+	}
+
+	return irssi_log.WriteFileAtomically(filepath.Join(ix.dir, manifestName), buf)
+}
+
+// Tokenize splits text into the terms we index. Terms are runs of letters and
+// digits, case folded.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+
+	return words
+}
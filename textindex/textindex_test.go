@@ -0,0 +1,197 @@
+package textindex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndex(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "irssi_log")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %s", err.Error())
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	sample, err := ioutil.ReadFile(filepath.Join("..", "testdata", "sample.log"))
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	logFile := filepath.Join(dir, "test.log")
+	writeFile(t, logFile, string(sample))
+
+	indexDir := filepath.Join(dir, "index")
+
+	index, err := Open(indexDir)
+	if err != nil {
+		t.Fatalf("Unable to open index: %s", err.Error())
+	}
+
+	count, err := index.Add(logFile, location)
+	if err != nil {
+		t.Fatalf("Unable to add log: %s", err.Error())
+	}
+
+	// Messages, emotes, and topics with text.
+	if count != 14 {
+		t.Errorf("Indexed %d entries, wanted 14", count)
+	}
+
+	checkSearch(t, index, "deploy", []int{7, 21, 27, 35})
+	checkSearch(t, index, "DEPLOY*", []int{7, 8, 13, 21, 27, 28, 35, 36})
+	checkSearch(t, index, "deploy* alice", []int{8, 28})
+	checkSearch(t, index, `"the deploy"`, []int{7, 27})
+	checkSearch(t, index, `"deploy the"`, nil)
+	checkSearch(t, index, `"yes deployed at"`, []int{28})
+	checkSearch(t, index, `"deployed ed"`, []int{36})
+	checkSearch(t, index, `"deployed e*"`, []int{36})
+	checkSearch(t, index, "waves", []int{9})
+	checkSearch(t, index, "nothing", nil)
+
+	results, err := index.Search("midnight", 0)
+	if err != nil {
+		t.Fatalf("Unable to search: %s", err.Error())
+	}
+	if len(results) != 1 {
+		t.Fatalf("Found %d results, wanted 1", len(results))
+	}
+
+	entry := results[0].Entry
+	wantedTime := time.Date(2016, 3, 28, 0, 5, 0, 0, location)
+	if results[0].File != logFile || entry.Nick != "bob" ||
+		!entry.Time.Equal(wantedTime) || entry.Offset == 0 ||
+		entry.Line != "00:05 <@bob> alice_: yes, deployed at midnight" {
+		t.Errorf("Found %s %+v", results[0].File, entry)
+	}
+
+	results, err = index.Search("deploy*", 2)
+	if err != nil {
+		t.Fatalf("Unable to search: %s", err.Error())
+	}
+	if len(results) != 2 {
+		t.Errorf("Found %d results with a limit of 2", len(results))
+	}
+
+	_, err = index.Search(`"deploy`, 0)
+	if err == nil {
+		t.Errorf("Searched with unbalanced quotes")
+	}
+
+	// Only new lines are indexed. A partial line waits until it is complete.
+
+	writeFile(t, logFile, string(sample)+
+		"--- Log opened Wed Mar 30 08:00:00 2016\n"+
+		"08:01 <@bob> the deploy is stuck\n"+
+		"08:02 < alice> again")
+
+	count, err = index.Update()
+	if err != nil {
+		t.Fatalf("Unable to update: %s", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Indexed %d new entries, wanted 1", count)
+	}
+
+	checkSearch(t, index, "deploy", []int{7, 21, 27, 35, 40})
+
+	// The index survives reopening, and merging.
+
+	index, err = Open(indexDir)
+	if err != nil {
+		t.Fatalf("Unable to open index: %s", err.Error())
+	}
+
+	checkSearch(t, index, "deploy", []int{7, 21, 27, 35, 40})
+
+	err = index.Merge()
+	if err != nil {
+		t.Fatalf("Unable to merge: %s", err.Error())
+	}
+
+	checkSearch(t, index, "deploy", []int{7, 21, 27, 35, 40})
+	checkSearch(t, index, `"is stuck"`, []int{40})
+
+	// If the log is replaced we index it again.
+
+	writeFile(t, logFile, "--- Log opened Thu Mar 31 08:00:00 2016\n"+
+		"08:01 <@bob> a new deploy\n")
+
+	count, err = index.Update()
+	if err != nil {
+		t.Fatalf("Unable to update: %s", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Indexed %d entries from the new log, wanted 1", count)
+	}
+
+	checkSearch(t, index, "deploy", []int{2})
+
+	err = index.Merge()
+	if err != nil {
+		t.Fatalf("Unable to merge: %s", err.Error())
+	}
+
+	checkSearch(t, index, "deploy", []int{2})
+	if len(index.segments) != 1 || len(index.segments[0].docs) != 1 {
+		t.Errorf("Merging kept entries from the old log")
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("Check https://example.com/Deploy, ok? Ça va")
+	wanted := []string{"check", "https", "example", "com", "deploy", "ok", "ça",
+		"va"}
+
+	if len(tokens) != len(wanted) {
+		t.Fatalf("Tokenized to %q, wanted %q", tokens, wanted)
+	}
+	for i := range tokens {
+		if tokens[i] != wanted[i] {
+			t.Errorf("Tokenized to %q, wanted %q", tokens, wanted)
+			break
+		}
+	}
+}
+
+// checkSearch searches and checks the results are the given lines.
+func checkSearch(t *testing.T, index *Index, query string, lines []int) {
+	results, err := index.Search(query, 0)
+	if err != nil {
+		t.Errorf("Unable to search for %s: %s", query, err)
+		return
+	}
+
+	var found []int
+	for _, result := range results {
+		found = append(found, result.Entry.LineNumber)
+	}
+
+	if len(found) != len(lines) {
+		t.Errorf("Search for %s found lines %v, wanted %v", query, found, lines)
+		return
+	}
+
+	for i := range found {
+		if found[i] != lines[i] {
+			t.Errorf("Search for %s found lines %v, wanted %v", query, found, lines)
+			return
+		}
+	}
+}
+
+func writeFile(t *testing.T, filename, content string) {
+	err := ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err.Error())
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Package tokenizer splits message text into the tokens we model.
+ *
+ * By default tokens are the words of the text, split on white space, without
+ * URLs. Options change this: lower casing, splitting punctuation off words,
+ * replacing URLs, nicks, and numbers with placeholder tokens, and leaving out
+ * the nick a message addresses ("alice: ...").
+ *
+ * Programs building text for the model and programs generating from it should
+ * tokenize the same way, so the model's words match what they look up.
+ */
+
+package tokenizer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/horgh/irssi_log"
+)
+
+// Placeholder tokens. They have no white space so they are one token, and
+// tokenizing them again leaves them as they are.
+const (
+	URLToken    = "<url>"
+	NickToken   = "<nick>"
+	NumberToken = "<number>"
+)
+
+var urlPattern = regexp.MustCompile("https?:")
+
+var numberPattern = regexp.MustCompile(`^[0-9]+([.,:][0-9]+)*$`)
+
+// URLMode says what to do with URLs.
+type URLMode int
+
+const (
+	// DropURLs leaves URLs out.
+	DropURLs URLMode = iota
+
+	// KeepURLs keeps URLs as tokens.
+	KeepURLs
+
+	// ReplaceURLs replaces each URL with URLToken.
+	ReplaceURLs
+)
+
+// Options say how to tokenize. The zero value splits on white space and drops
+// URLs.
+type Options struct {
+	// Lowercase makes tokens lower case. Placeholders and nicks we replace are
+	// left alone.
+	Lowercase bool
+
+	// SeparatePunctuation splits punctuation at the start and end of words into
+	// their own tokens. Words that are all punctuation, such as emoticons, are
+	// kept whole.
+	SeparatePunctuation bool
+
+	// URLs says what to do with URLs.
+	URLs URLMode
+
+	// ReplaceNicks replaces nicks with NickToken. We know the nicks added with
+	// AddNick.
+	ReplaceNicks bool
+
+	// ReplaceNumbers replaces numbers with NumberToken.
+	ReplaceNumbers bool
+
+	// StripAddress leaves out the nick a message starts by addressing, as in
+	// "alice: hi" or "alice, hi". It must be a nick added with AddNick, so
+	// "note: hi" stays as it is.
+	StripAddress bool
+}
+
+// Tokenizer splits text into tokens.
+type Tokenizer struct {
+	options Options
+
+	// nicks are the nicks we know, in IRC lower case.
+	nicks map[string]struct{}
+}
+
+// New creates a tokenizer.
+func New(options Options) *Tokenizer {
+	return &Tokenizer{
+		options: options,
+		nicks:   map[string]struct{}{},
+	}
+}
+
+// ParseOptions parses options from a comma separated list of their names:
+// lowercase, punctuation, urls=drop, urls=keep, urls=replace, nicks, numbers,
+// and strip-address. A blank string gives the default options.
+func ParseOptions(s string) (Options, error) {
+	var options Options
+
+	for _, field := range strings.Split(s, ",") {
+		switch strings.TrimSpace(field) {
+		case "":
+		case "lowercase":
+			options.Lowercase = true
+		case "punctuation":
+			options.SeparatePunctuation = true
+		case "urls=drop":
+			options.URLs = DropURLs
+		case "urls=keep":
+			options.URLs = KeepURLs
+		case "urls=replace":
+			options.URLs = ReplaceURLs
+		case "nicks":
+			options.ReplaceNicks = true
+		case "numbers":
+			options.ReplaceNumbers = true
+		case "strip-address":
+			options.StripAddress = true
+		default:
+			return Options{}, fmt.Errorf("Unknown tokenizer option: %s", field)
+		}
+	}
+
+	return options, nil
+}
+
+// Options gives the tokenizer's options.
+func (t *Tokenizer) Options() Options {
+	return t.options
+}
+
+// AddNick tells us about a nick. We only keep nicks if the options need them.
+func (t *Tokenizer) AddNick(nick string) {
+	if !t.options.ReplaceNicks && !t.options.StripAddress {
+		return
+	}
+
+	if len(nick) == 0 {
+		return
+	}
+
+	t.nicks[irssi_log.IRCLower(nick)] = struct{}{}
+}
+
+// Nicks gives the nicks we know, in IRC lower case and sorted.
+func (t *Tokenizer) Nicks() []string {
+	var nicks []string
+	for nick := range t.nicks {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// IsPlaceholder decides whether a token is a placeholder.
+func IsPlaceholder(token string) bool {
+	return token == URLToken || token == NickToken || token == NumberToken
+}
+
+// Tokens splits text into tokens.
+func (t *Tokenizer) Tokens(text string) []string {
+	words := strings.Fields(text)
+
+	if t.options.StripAddress && len(words) > 0 && t.isAddress(words[0]) {
+		words = words[1:]
+	}
+
+	var tokens []string
+	for _, word := range words {
+		tokens = append(tokens, t.wordTokens(word)...)
+	}
+	return tokens
+}
+
+// isAddress decides whether a word addresses a nick.
+func (t *Tokenizer) isAddress(word string) bool {
+	if len(word) < 2 {
+		return false
+	}
+
+	last := word[len(word)-1]
+	nick := word[:len(word)-1]
+
+	if last != ':' && last != ',' {
+		return false
+	}
+
+	_, exists := t.nicks[irssi_log.IRCLower(nick)]
+	return exists
+}
+
+// wordTokens gives the tokens of one word.
+func (t *Tokenizer) wordTokens(word string) []string {
+	// Placeholders from tokenizing before stay as they are, as does any
+	// punctuation after them.
+	for _, placeholder := range []string{URLToken, NickToken, NumberToken} {
+		trailing := strings.TrimPrefix(word, placeholder)
+		if len(trailing) < len(word) &&
+			len(strings.TrimFunc(trailing, isPunctuation)) == 0 {
+			return t.join("", placeholder, trailing)
+		}
+	}
+
+	if urlPattern.MatchString(word) {
+		switch t.options.URLs {
+		case KeepURLs:
+			return []string{word}
+		case ReplaceURLs:
+			return []string{URLToken}
+		default:
+			return nil
+		}
+	}
+
+	// Nicks may contain punctuation, so look for them before splitting it off.
+	// We allow punctuation after them, as in "alice:" or "bob?".
+	if t.options.ReplaceNicks {
+		nick := strings.TrimRight(word, ",:;.!?")
+		if _, exists := t.nicks[irssi_log.IRCLower(nick)]; exists {
+			return t.join("", NickToken, word[len(nick):])
+		}
+	}
+
+	rest := strings.TrimLeftFunc(word, isPunctuation)
+	core := strings.TrimRightFunc(rest, isPunctuation)
+	if len(core) == 0 {
+		return []string{word}
+	}
+
+	leading := word[:len(word)-len(rest)]
+	trailing := rest[len(core):]
+
+	if t.options.ReplaceNumbers && numberPattern.MatchString(core) {
+		core = NumberToken
+	} else if t.options.Lowercase {
+		core = strings.ToLower(core)
+	}
+
+	return t.join(leading, core, trailing)
+}
+
+// join gives a word's tokens from its core and the punctuation around it.
+func (t *Tokenizer) join(leading, core, trailing string) []string {
+	if !t.options.SeparatePunctuation {
+		return []string{leading + core + trailing}
+	}
+
+	var tokens []string
+	if len(leading) > 0 {
+		tokens = append(tokens, leading)
+	}
+	tokens = append(tokens, core)
+	if len(trailing) > 0 {
+		tokens = append(tokens, trailing)
+	}
+	return tokens
+}
+
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
@@ -0,0 +1,145 @@
+package tokenizer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	type TestCase struct {
+		Name    string
+		Options string
+		Nicks   []string
+		Text    string
+		Tokens  []string
+	}
+
+	cases := []TestCase{
+		{
+			Name:   "default",
+			Text:   "alice: Check  https://example.com/x, it's 42!",
+			Tokens: []string{"alice:", "Check", "it's", "42!"},
+		},
+		{
+			Name:    "lowercase",
+			Options: "lowercase",
+			Text:    "Hello WORLD :)",
+			Tokens:  []string{"hello", "world", ":)"},
+		},
+		{
+			Name:    "punctuation",
+			Options: "punctuation",
+			Text:    `"Hello," it's (really) me... :) ok?!`,
+			Tokens: []string{`"`, "Hello", `,"`, "it's", "(", "really", ")", "me",
+				"...", ":)", "ok", "?!"},
+		},
+		{
+			Name:    "keep urls",
+			Options: "urls=keep",
+			Text:    "see https://example.com/x",
+			Tokens:  []string{"see", "https://example.com/x"},
+		},
+		{
+			Name:    "replace urls",
+			Options: "urls=replace",
+			Text:    "see https://example.com/x",
+			Tokens:  []string{"see", URLToken},
+		},
+		{
+			Name:    "nicks",
+			Options: "nicks,punctuation",
+			Nicks:   []string{"Alice", "bob_", "[carol]"},
+			Text:    "ALICE: ask bob_? or [carol], not bob",
+			Tokens: []string{NickToken, ":", "ask", NickToken, "?", "or",
+				NickToken, ",", "not", "bob"},
+		},
+		{
+			Name:    "numbers",
+			Options: "numbers",
+			Text:    "at 10:30 it was 3.5% up, not v2",
+			Tokens: []string{"at", NumberToken, "it", "was", NumberToken + "%", "up,",
+				"not", "v2"},
+		},
+		{
+			Name:    "strip address of a nick",
+			Options: "strip-address",
+			Nicks:   []string{"alice"},
+			Text:    "alice, note: hi",
+			Tokens:  []string{"note:", "hi"},
+		},
+		{
+			Name:    "don't strip address of something else",
+			Options: "strip-address",
+			Nicks:   []string{"alice"},
+			Text:    "note: hi",
+			Tokens:  []string{"note:", "hi"},
+		},
+		{
+			Name:    "don't strip address without nicks",
+			Options: "strip-address",
+			Text:    "alice: note: hi",
+			Tokens:  []string{"alice:", "note:", "hi"},
+		},
+		{
+			Name:    "placeholders stay",
+			Options: "lowercase,punctuation,nicks,numbers,urls=replace",
+			Text:    "<nick>: <number>, <url>",
+			Tokens:  []string{NickToken, ":", NumberToken, ",", URLToken},
+		},
+		{
+			Name:   "blank",
+			Text:   "   ",
+			Tokens: nil,
+		},
+	}
+
+	for _, c := range cases {
+		options, err := ParseOptions(c.Options)
+		if err != nil {
+			t.Fatalf("%s: Unable to parse options: %s", c.Name, err.Error())
+		}
+
+		tokenizer := New(options)
+		for _, nick := range c.Nicks {
+			tokenizer.AddNick(nick)
+		}
+
+		tokens := tokenizer.Tokens(c.Text)
+		if !reflect.DeepEqual(tokens, c.Tokens) {
+			t.Errorf("%s: Tokenized %q as %q, wanted %q", c.Name, c.Text, tokens,
+				c.Tokens)
+		}
+
+		// Tokenizing again changes nothing.
+		again := tokenizer.Tokens(strings.Join(tokens, " "))
+		if !reflect.DeepEqual(again, tokens) {
+			t.Errorf("%s: Tokenized %q again as %q", c.Name, tokens, again)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions("lowercase, punctuation,urls=replace,nicks," +
+		"numbers,strip-address")
+	if err != nil {
+		t.Fatalf("Unable to parse options: %s", err.Error())
+	}
+
+	want := Options{
+		Lowercase:           true,
+		SeparatePunctuation: true,
+		URLs:                ReplaceURLs,
+		ReplaceNicks:        true,
+		ReplaceNumbers:      true,
+		StripAddress:        true,
+	}
+	if options != want {
+		t.Errorf("Parsed %+v, wanted %+v", options, want)
+	}
+
+	_, err = ParseOptions("lowercase,shouting")
+	if err == nil {
+		t.Errorf("Parsed an unknown option")
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Write entries back out in Irssi's log format.
+ */
+
+package irssi_log
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DayChangeTimeLayout is the layout of the date in a DayChange line.
+const DayChangeTimeLayout = "Mon Jan 02 2006"
+
+// Writer writes entries as an Irssi log.
+//
+// It adds LogOpen and DayChange lines where the entries need them. For
+// example if entries are on a later day than the one before, we add a
+// DayChange line. If we are given those lines ourselves we write them as they
+// are.
+type Writer struct {
+	writer *bufio.Writer
+
+	// open is whether the log is open. That is, we wrote a LogOpen line and no
+	// LogClosed line since.
+	open bool
+
+	// date is midnight of the current day in the log.
+	date time.Time
+}
+
+// NewWriter creates a Writer.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: bufio.NewWriter(w)}
+}
+
+// Write writes an entry, along with any LogOpen or DayChange lines that need
+// to come before it.
+func (w *Writer) Write(entry *LogEntry) error {
+	line, err := FormatEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	switch entry.Type {
+	case LogOpen:
+		w.open = true
+		w.date = midnight(entry.Time, entry.Time.Location())
+	case DayChange:
+		w.date = midnight(entry.Time, entry.Time.Location())
+	case LogClosed:
+		w.open = false
+	default:
+		err := w.writeHeaders(entry.Time)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.writeLine(line)
+}
+
+// writeHeaders writes the LogOpen or DayChange lines needed before a line at
+// the given time.
+//
+// Lines parsed before any LogOpen or DayChange line have no real date. We
+// write them with no headers.
+func (w *Writer) writeHeaders(t time.Time) error {
+	if t.Year() <= 1 {
+		return nil
+	}
+
+	day := midnight(t, t.Location())
+
+	if !w.open {
+		w.open = true
+		w.date = day
+		return w.writeLine(formatLogOpen(t))
+	}
+
+	// Irssi notes each day as it starts, even if nothing happened on it.
+	for w.date.Before(day) {
+		w.date = midnight(w.date.AddDate(0, 0, 1), day.Location())
+		err := w.writeLine(formatDayChange(w.date))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeLine writes a line followed by a newline.
+func (w *Writer) writeLine(line string) error {
+	_, err := w.writer.WriteString(line + "\n")
+	if err != nil {
+		return fmt.Errorf("Unable to write: %s", err.Error())
+	}
+	return nil
+}
+
+// Flush writes any buffered data.
+func (w *Writer) Flush() error {
+	err := w.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Unable to flush: %s", err.Error())
+	}
+	return nil
+}
+
+// FormatEntry renders an entry as a line in Irssi's default format.
+func FormatEntry(entry *LogEntry) (string, error) {
+	clock := entry.Time.Format("15:04")
+
+	switch entry.Type {
+	case LogOpen:
+		return formatLogOpen(entry.Time), nil
+	case Join:
+		return fmt.Sprintf("%s -!- %s [%s] has joined %s", clock, entry.Nick,
+			entry.UserHost, entry.Channel), nil
+	case ChannelSummary:
+		return fmt.Sprintf("%s -!- Irssi: %s: %s", clock, entry.Channel,
+			entry.Text), nil
+	case Mode:
+		return fmt.Sprintf("%s -!- mode/%s [%s] by %s", clock, entry.Channel,
+			entry.Text, entry.Nick), nil
+	case JoinSync:
+		return fmt.Sprintf("%s -!- Irssi: Join to %s was synced in %d secs", clock,
+			entry.Channel, entry.SyncSeconds), nil
+	case Message:
+		// Irssi shows a space where there is no status.
+		nickMode := entry.NickMode
+		if nickMode == "" {
+			nickMode = " "
+		}
+		return fmt.Sprintf("%s <%s%s> %s", clock, nickMode, entry.Nick,
+			entry.Text), nil
+	case Quit:
+		return fmt.Sprintf("%s -!- %s [%s] has quit [%s]", clock, entry.Nick,
+			entry.UserHost, entry.Text), nil
+	case NickChange:
+		return fmt.Sprintf("%s -!- %s is now known as %s", clock, entry.Nick,
+			entry.Text), nil
+	case DayChange:
+		return formatDayChange(entry.Time), nil
+	case LogClosed:
+		return "--- Log closed " + entry.Time.Format(LogOpenTimeLayout), nil
+	case NowTalking:
+		return fmt.Sprintf("%s -!- Irssi: You are now talking in %s", clock,
+			entry.Channel), nil
+	case Emote:
+		return fmt.Sprintf("%s  * %s %s", clock, entry.Nick, entry.Text), nil
+	case Topic:
+		return fmt.Sprintf("%s -!- %s changed the topic of %s to: %s", clock,
+			entry.Nick, entry.Channel, entry.Text), nil
+	case Kick:
+		return fmt.Sprintf("%s -!- %s was kicked from %s by %s [%s]", clock,
+			entry.Nick, entry.Channel, entry.ByNick, entry.Text), nil
+	case Part:
+		return fmt.Sprintf("%s -!- %s [%s] has left %s [%s]", clock, entry.Nick,
+			entry.UserHost, entry.Channel, entry.Text), nil
+	case YourNickChange:
+		return fmt.Sprintf("%s -!- You're now known as %s", clock, entry.Nick), nil
+	case ServerMode:
+		return fmt.Sprintf("%s -!- ServerMode/%s [%s] by %s", clock, entry.Channel,
+			entry.Text, entry.Nick), nil
+	case ChannelNotice:
+		return fmt.Sprintf("%s -%s:%s%s- %s", clock, entry.Nick,
+			entry.NoticeTarget, entry.Channel,
+			entry.Text), nil
+	case IgnoreThis:
+		// The only lines we ignore are from Keepnick.
+		return fmt.Sprintf("%s -!- Keepnick:%s", clock, entry.Text), nil
+	case ServerNotice:
+		return fmt.Sprintf("%s !%s %s", clock, entry.Nick, entry.Text), nil
+	case BansNone:
+		return fmt.Sprintf("%s -!- Irssi: No bans in channel %s", clock,
+			entry.Channel), nil
+	}
+
+	return "", fmt.Errorf("Unknown entry type: %d", entry.Type)
+}
+
+// formatLogOpen renders a LogOpen line.
+func formatLogOpen(t time.Time) string {
+	return "--- Log opened " + t.Format(LogOpenTimeLayout)
+}
+
+// formatDayChange renders a DayChange line.
+func formatDayChange(t time.Time) string {
+	return "--- Day changed " + t.Format(DayChangeTimeLayout)
+}
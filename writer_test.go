@@ -0,0 +1,186 @@
+package irssi_log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatEntry(t *testing.T) {
+	location, currentDate, cases := parseLineTestCases(t)
+
+	for _, testCase := range cases {
+		if testCase.Error != nil {
+			continue
+		}
+
+		entry, err := ParseLine(testCase.Line, location, currentDate)
+		if err != nil {
+			t.Errorf("Unable to parse line: %s: %s", testCase.Line, err)
+			continue
+		}
+
+		line, err := FormatEntry(entry)
+		if err != nil {
+			t.Errorf("Unable to format entry: %s: %s", testCase.Line, err)
+			continue
+		}
+
+		if line != testCase.Line {
+			t.Errorf("Formatted %q, wanted %q", line, testCase.Line)
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	wanted, err := ioutil.ReadFile("testdata/sample.log")
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	entries := parseFile(t, "testdata/sample.log", location)
+
+	found := writeEntries(t, entries)
+	if found != string(wanted) {
+		t.Errorf("Wrote %q, wanted %q", found, string(wanted))
+	}
+}
+
+// TestWriterRoundTripWithoutLines writes entries that don't have their raw
+// line. We still write the log as it was.
+func TestWriterRoundTripWithoutLines(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	wanted, err := ioutil.ReadFile("testdata/sample.log")
+	if err != nil {
+		t.Fatalf("Unable to read file: %s", err.Error())
+	}
+
+	// Parsed with DropLines.
+
+	parser := NewParser(location)
+	parser.DropLines = true
+	entries := parseLines(t, parser, readLines(t, "testdata/sample.log"))
+
+	found := writeEntries(t, entries)
+	if found != string(wanted) {
+		t.Errorf("Wrote %q from entries without lines, wanted %q", found,
+			string(wanted))
+	}
+
+	// From a CompactLog.
+
+	compactLog := NewCompactLog(location)
+	for _, entry := range parseFile(t, "testdata/sample.log", location) {
+		compactLog.Add(entry)
+	}
+
+	entries = nil
+	for i := 0; i < compactLog.Len(); i++ {
+		entries = append(entries, compactLog.Entry(i))
+	}
+
+	found = writeEntries(t, entries)
+	if found != string(wanted) {
+		t.Errorf("Wrote %q from a compact log, wanted %q", found, string(wanted))
+	}
+
+	// Through JSON without lines.
+
+	entries = nil
+	for _, entry := range parseFile(t, "testdata/sample.log", location) {
+		entry.Line = ""
+
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatalf("Unable to encode entry: %s", err.Error())
+		}
+
+		decoded := &LogEntry{}
+		err = json.Unmarshal(buf, decoded)
+		if err != nil {
+			t.Fatalf("Unable to decode entry: %s", err.Error())
+		}
+		decoded.Time = decoded.Time.In(location)
+
+		entries = append(entries, decoded)
+	}
+
+	found = writeEntries(t, entries)
+	if found != string(wanted) {
+		t.Errorf("Wrote %q from JSON, wanted %q", found, string(wanted))
+	}
+}
+
+func TestWriterHeaders(t *testing.T) {
+	location, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("Invalid location: %s", err.Error())
+	}
+
+	entries := []*LogEntry{
+		&LogEntry{
+			Time: time.Date(2016, 3, 27, 15, 5, 0, 0, location),
+			Type: Message,
+			Nick: "bob",
+			Text: "hi",
+		},
+		&LogEntry{
+			Time: time.Date(2016, 3, 27, 15, 6, 0, 0, location),
+			Type: Message,
+			Nick: "alice",
+			Text: "hi",
+		},
+		&LogEntry{
+			Time: time.Date(2016, 3, 29, 9, 0, 0, 0, location),
+			Type: Emote,
+			Nick: "bob",
+			Text: "waves",
+		},
+	}
+
+	wanted := strings.Join([]string{
+		"--- Log opened Sun Mar 27 15:05:00 2016",
+		"15:05 < bob> hi",
+		"15:06 < alice> hi",
+		"--- Day changed Mon Mar 28 2016",
+		"--- Day changed Tue Mar 29 2016",
+		"09:00  * bob waves",
+	}, "\n") + "\n"
+
+	found := writeEntries(t, entries)
+	if found != wanted {
+		t.Errorf("Wrote %q, wanted %q", found, wanted)
+	}
+}
+
+// writeEntries writes entries with a Writer and gives what it wrote.
+func writeEntries(t *testing.T, entries []*LogEntry) string {
+	buf := &bytes.Buffer{}
+	writer := NewWriter(buf)
+
+	for _, entry := range entries {
+		err := writer.Write(entry)
+		if err != nil {
+			t.Fatalf("Unable to write entry: %s: %s", entry.Line, err)
+		}
+	}
+
+	err := writer.Flush()
+	if err != nil {
+		t.Fatalf("Unable to flush: %s", err)
+	}
+
+	return buf.String()
+}